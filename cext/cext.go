@@ -7,6 +7,7 @@ package cext
 import "C"
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -17,6 +18,9 @@ import (
 	"github.com/rivosinc/prometheus-slurm-exporter/exporter"
 )
 
+// shared by the c fetchers, labeled by the slurm api call that failed
+var commandErrors = exporter.NewCommandErrorCounter(nil)
+
 type Destructor interface {
 	Deinit()
 }
@@ -25,7 +29,7 @@ type CNodeFetcher struct {
 	cache        *exporter.AtomicThrottledCache[exporter.NodeMetric]
 	scraper      NodeMetricScraper
 	duration     time.Duration
-	errorCounter prometheus.Counter
+	errorCounter *prometheus.CounterVec
 }
 
 // should be defer'd immediately after new cmd to prevent mem leaks
@@ -35,7 +39,7 @@ func (cni *CNodeFetcher) Deinit() {
 
 func (cni *CNodeFetcher) CToGoMetricConvert() ([]exporter.NodeMetric, error) {
 	if errno := cni.scraper.CollectNodeInfo(); errno != 0 {
-		cni.errorCounter.Inc()
+		cni.errorCounter.WithLabelValues("exit").Inc()
 		return nil, fmt.Errorf("Node Info CPP errno: %d", errno)
 	}
 	cni.scraper.IterReset()
@@ -74,7 +78,7 @@ func (cni *CNodeFetcher) CToGoMetricConvert() ([]exporter.NodeMetric, error) {
 	return nodeMetrics, nil
 }
 
-func (cni *CNodeFetcher) FetchMetrics() ([]exporter.NodeMetric, error) {
+func (cni *CNodeFetcher) FetchMetrics(ctx context.Context) ([]exporter.NodeMetric, error) {
 	return cni.cache.FetchOrThrottle(cni.CToGoMetricConvert)
 }
 
@@ -82,18 +86,11 @@ func (cni *CNodeFetcher) ScrapeDuration() time.Duration {
 	return cni.duration
 }
 
-func (cni *CNodeFetcher) ScrapeError() prometheus.Counter {
-	return cni.errorCounter
-}
-
 func NewNodeFetcher(pollLimit float64) *CNodeFetcher {
 	return &CNodeFetcher{
-		cache:   exporter.NewAtomicThrottledCache[exporter.NodeMetric](pollLimit),
-		scraper: NewNodeMetricScraper(""),
-		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
-			Name: "slurm_cplugin_node_fetch_error",
-			Help: "slurm cplugin fetch error",
-		}),
+		cache:        exporter.NewAtomicThrottledCache[exporter.NodeMetric](pollLimit),
+		scraper:      NewNodeMetricScraper(""),
+		errorCounter: commandErrors.MustCurryWith(prometheus.Labels{"command": "slurm_load_node"}),
 	}
 }
 
@@ -101,12 +98,12 @@ type CJobFetcher struct {
 	cache        *exporter.AtomicThrottledCache[exporter.JobMetric]
 	scraper      JobMetricScraper
 	duration     time.Duration
-	errorCounter prometheus.Counter
+	errorCounter *prometheus.CounterVec
 }
 
 func (cjf *CJobFetcher) CToGoMetricConvert() ([]exporter.JobMetric, error) {
 	if errno := cjf.scraper.CollectJobInfo(); errno != 0 {
-		cjf.errorCounter.Inc()
+		cjf.errorCounter.WithLabelValues("exit").Inc()
 		return nil, fmt.Errorf("Job Info CPP errno: %d", errno)
 	}
 	jobStates := map[int]string{
@@ -149,7 +146,7 @@ func (cjf *CJobFetcher) CToGoMetricConvert() ([]exporter.JobMetric, error) {
 	return metrics, nil
 }
 
-func (cjf *CJobFetcher) FetchMetrics() ([]exporter.JobMetric, error) {
+func (cjf *CJobFetcher) FetchMetrics(ctx context.Context) ([]exporter.JobMetric, error) {
 	return cjf.cache.FetchOrThrottle(cjf.CToGoMetricConvert)
 }
 
@@ -157,21 +154,14 @@ func (cjf *CJobFetcher) ScrapeDuration() time.Duration {
 	return cjf.duration
 }
 
-func (cjf *CJobFetcher) ScrapeError() prometheus.Counter {
-	return cjf.errorCounter
-}
-
 func (cjf *CJobFetcher) Deinit() {
 	DeleteJobMetricScraper(cjf.scraper)
 }
 
 func NewJobFetcher(pollLimit float64) *CJobFetcher {
 	return &CJobFetcher{
-		cache:   exporter.NewAtomicThrottledCache[exporter.JobMetric](pollLimit),
-		scraper: NewJobMetricScraper(""),
-		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
-			Name: "slurm_cplugin_job_fetch_error",
-			Help: "slurm cplugin job fetch error",
-		}),
+		cache:        exporter.NewAtomicThrottledCache[exporter.JobMetric](pollLimit),
+		scraper:      NewJobMetricScraper(""),
+		errorCounter: commandErrors.MustCurryWith(prometheus.Labels{"command": "slurm_load_jobs"}),
 	}
 }
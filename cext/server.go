@@ -26,5 +26,6 @@ func InitPromServer(config *exporter.Config) (http.Handler, []Destructor) {
 	jobCollector := exporter.NewJobsController(config)
 	jobCollector.SetFetcher(CJobFetcher)
 	prometheus.MustRegister(jobCollector)
+	prometheus.MustRegister(commandErrors)
 	return promhttp.Handler(), []Destructor{cNodeFetcher, CJobFetcher}
 }
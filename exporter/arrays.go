@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// states a job can't leave
+var terminalJobStates = map[string]struct{}{
+	"BOOT_FAIL":     {},
+	"CANCELLED":     {},
+	"COMPLETED":     {},
+	"DEADLINE":      {},
+	"FAILED":        {},
+	"NODE_FAIL":     {},
+	"OUT_OF_MEMORY": {},
+	"PREEMPTED":     {},
+	"REVOKED":       {},
+	"SPECIAL_EXIT":  {},
+	"TIMEOUT":       {},
+}
+
+func isTerminalState(state string) bool {
+	_, ok := terminalJobStates[state]
+	return ok
+}
+
+// number of tasks in an array task string, i.e 1-9999%10, 0-15:4 or 3,5,7-9
+func countArrayTasks(tasks string) float64 {
+	tasks, _, _ = strings.Cut(tasks, "%")
+	count := 0.
+	for _, item := range strings.Split(tasks, ",") {
+		item, stepStr, hasStep := strings.Cut(item, ":")
+		step := 1.
+		if hasStep {
+			if s, err := strconv.ParseFloat(stepStr, 64); err == nil && s > 0 {
+				step = s
+			}
+		}
+		startStr, endStr, isRange := strings.Cut(item, "-")
+		if !isRange {
+			if _, err := strconv.ParseFloat(item, 64); err == nil {
+				count++
+			}
+			continue
+		}
+		start, err := strconv.ParseFloat(startStr, 64)
+		if err != nil {
+			continue
+		}
+		end, err := strconv.ParseFloat(endStr, 64)
+		if err != nil || end < start {
+			continue
+		}
+		count += float64(int((end-start)/step)) + 1
+	}
+	return count
+}
+
+// pending array tasks a job record stands for
+// squeue --json folds tasks that haven't started into a single record with the remaining tasks in array_task_string
+func pendingArrayTasks(job *JobMetric) float64 {
+	if job.ArrayJobId <= 0 || job.JobState != "PENDING" {
+		return 0
+	}
+	if job.ArrayTaskString != "" {
+		return countArrayTasks(job.ArrayTaskString)
+	}
+	return 1
+}
+
+// an array in one state. Tasks sharing a key are counted as one job in the per user, account, qos and partition totals
+type arrayStateKey struct {
+	arrayJobId float64
+	state      string
+}
+
+// whether job counts as a job of its own. Only the first task of an array seen in a given state does,
+// so a 10k task array reads as a single workload. Jobs outside of arrays always count
+func countsAsJob(seen map[arrayStateKey]struct{}, job *JobMetric) bool {
+	if job.ArrayJobId <= 0 {
+		return true
+	}
+	key := arrayStateKey{arrayJobId: float64(job.ArrayJobId), state: job.JobState}
+	if _, ok := seen[key]; ok {
+		return false
+	}
+	seen[key] = struct{}{}
+	return true
+}
+
+type ArrayMetric struct {
+	active           float64
+	userActive       map[string]float64
+	userPendingTasks map[string]float64
+}
+
+// arrays with tasks that haven't finished, counted once no matter how many tasks they have
+func parseArrayMetrics(jobs []JobMetric) *ArrayMetric {
+	metric := &ArrayMetric{
+		userActive:       make(map[string]float64),
+		userPendingTasks: make(map[string]float64),
+	}
+	arrays := make(map[float64]string)
+	for i := range jobs {
+		job := &jobs[i]
+		if job.ArrayJobId <= 0 || isTerminalState(job.JobState) {
+			continue
+		}
+		arrays[float64(job.ArrayJobId)] = job.UserName
+		metric.userPendingTasks[job.UserName] += pendingArrayTasks(job)
+	}
+	for _, user := range arrays {
+		metric.active++
+		metric.userActive[user]++
+	}
+	return metric
+}
+
+type arrayTask struct {
+	arrayJobId float64
+	taskId     float64
+}
+
+// counts array tasks leaving the queue between fetches as a measure of array throughput
+// only tasks with their own record are tracked, so tasks count once they have started
+type ArrayTaskTracker struct {
+	sync.Mutex
+	// unfinished tasks of the last fetch and their owner as exported, after the user limit. nil until the first fetch
+	active      map[arrayTask]string
+	labelLimits LabelLimiter
+	users       *stickyLabels
+	finished    *prometheus.CounterVec
+}
+
+func NewArrayTaskTracker(constLabels prometheus.Labels, labelLimits LabelLimiter) *ArrayTaskTracker {
+	return &ArrayTaskTracker{
+		labelLimits: labelLimits,
+		users:       newStickyLabels(labelLimits.maxUsers),
+		finished: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "slurm_user_array_tasks_finished_total",
+			Help:        "array tasks that finished or left the queue per owner",
+			ConstLabels: constLabels,
+		}, []string{"username"}),
+	}
+}
+
+// idempotent for a given job list, so cached fetches can be observed on every scrape
+func (at *ArrayTaskTracker) observe(jobs []JobMetric) {
+	// ranked like the per user job metrics
+	weights := make(map[string]float64)
+	for i := range jobs {
+		weights[jobs[i].UserName] += at.labelLimits.jobWeight(&jobs[i])
+	}
+	users := at.users.fold(weights)
+	active := make(map[arrayTask]string)
+	for i := range jobs {
+		job := &jobs[i]
+		if job.ArrayJobId <= 0 || job.ArrayTaskString != "" || isTerminalState(job.JobState) {
+			continue
+		}
+		active[arrayTask{arrayJobId: float64(job.ArrayJobId), taskId: float64(job.ArrayTaskId)}] = users[job.UserName]
+	}
+	at.Lock()
+	defer at.Unlock()
+	if at.active != nil {
+		for task, user := range at.active {
+			if _, ok := active[task]; !ok {
+				at.finished.WithLabelValues(user).Inc()
+			}
+		}
+	}
+	at.active = active
+}
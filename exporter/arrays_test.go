@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountArrayTasks(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(10., countArrayTasks("1-10"))
+	assert.Equal(9999., countArrayTasks("1-9999%10"))
+	assert.Equal(4., countArrayTasks("0-15:4"))
+	assert.Equal(5., countArrayTasks("3,5,7-9"))
+	assert.Equal(0., countArrayTasks(""))
+}
+
+func TestParseArrayMetrics_Fallback(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := JobCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: newMockErrorCounter(),
+	}
+	jobs, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	metric := parseArrayMetrics(jobs)
+	// 3 tasks make up a single array
+	assert.Equal(1., metric.active)
+	assert.Equal(map[string]float64{"user2": 1}, metric.userActive)
+	assert.Equal(2., metric.userPendingTasks["user2"])
+}
+
+func TestParseArrayMetrics_Json(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := JobJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_out.json"},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: newMockErrorCounter(),
+	}
+	jobs, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	metric := parseArrayMetrics(jobs)
+	assert.Equal(1., metric.active)
+	// pending tasks are folded into a single record
+	assert.Equal(10., metric.userPendingTasks["bkd"])
+}
+
+func TestArrayTaskTracker(t *testing.T) {
+	assert := assert.New(t)
+	tracker := NewArrayTaskTracker(nil, LabelLimiter{})
+	task := func(id float64, state string) JobMetric {
+		return JobMetric{JobId: 100 + id, ArrayJobId: 100, ArrayTaskId: OptionalFloat(id), JobState: state, UserName: "user1"}
+	}
+	// the first fetch is only a baseline
+	tracker.observe([]JobMetric{task(0, "RUNNING"), task(1, "RUNNING"), task(2, "PENDING"), {JobId: 1, JobState: "RUNNING", UserName: "user1"}})
+	assert.Equal(0., CollectCounterValue(tracker.finished.WithLabelValues("user1")))
+	// task 0 left the queue and task 1 completed
+	jobs := []JobMetric{task(1, "COMPLETED"), task(2, "RUNNING")}
+	tracker.observe(jobs)
+	assert.Equal(2., CollectCounterValue(tracker.finished.WithLabelValues("user1")))
+	// cached fetches aren't counted twice
+	tracker.observe(jobs)
+	assert.Equal(2., CollectCounterValue(tracker.finished.WithLabelValues("user1")))
+	tracker.observe(nil)
+	assert.Equal(3., CollectCounterValue(tracker.finished.WithLabelValues("user1")))
+}
+
+func TestCountsAsJob_Arrays(t *testing.T) {
+	assert := assert.New(t)
+	cliFallbackFetcher := &JobCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](100),
+		errCounter: newMockErrorCounter(),
+	}
+	jobs, err := cliFallbackFetcher.fetch(context.Background())
+	assert.NoError(err)
+	user2 := parseUserJobMetrics(jobs)["user2"]
+	// array 60000 has a running task and 2 pending tasks
+	assert.Equal(1., user2.stateJobCount["RUNNING"])
+	assert.Equal(1., user2.stateJobCount["PENDING"])
+	// resources still add up over every task
+	assert.Equal(2., user2.allocCpu["PENDING"])
+	account2 := parseAccountMetrics(jobs)["account2"]
+	assert.Equal(1., account2.stateJobCount["PENDING"])
+	assert.Equal(2., account2.stateAllocCpu["PENDING"])
+	// partition totals agree with the account totals
+	assert.Equal(map[string]float64{"RUNNING": 1, "PENDING": 1}, parsePartitionJobMetrics(jobs)["array"].partitionState)
+}
@@ -0,0 +1,258 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// label value standing in for every user or account past the limit
+const otherLabelValue = "other"
+
+// quantities users and accounts are ranked by
+const (
+	limitByCpus = "cpus"
+	limitByMem  = "mem"
+	limitByJobs = "jobs"
+)
+
+// caps the users and accounts exported by the aggregate metrics, keeping the largest by a quantity
+// and folding the rest into otherLabelValue. The zero value keeps every user and account
+type LabelLimiter struct {
+	maxUsers    int
+	maxAccounts int
+	by          string
+}
+
+func NewLabelLimiter(maxUsers int, maxAccounts int, by string) (LabelLimiter, error) {
+	switch by {
+	case "":
+		by = limitByCpus
+	case limitByCpus, limitByMem, limitByJobs:
+	default:
+		return LabelLimiter{}, fmt.Errorf("invalid label limit quantity %q, expected one of %s, %s or %s", by, limitByCpus, limitByMem, limitByJobs)
+	}
+	return LabelLimiter{maxUsers: maxUsers, maxAccounts: maxAccounts, by: by}, nil
+}
+
+func (ll LabelLimiter) enabled() bool {
+	return ll.maxUsers > 0 || ll.maxAccounts > 0
+}
+
+func (ll LabelLimiter) jobWeight(job *JobMetric) float64 {
+	return ll.weight(job.JobResources.AllocCpus, totalAllocMem(&job.JobResources))
+}
+
+// weight of a job holding cpus and mem by the limit quantity
+func (ll LabelLimiter) weight(cpus float64, mem float64) float64 {
+	switch ll.by {
+	case limitByJobs:
+		return 1
+	case limitByMem:
+		return mem
+	default:
+		return cpus
+	}
+}
+
+// label values by descending weight, ties go to the smallest value so the order is stable across scrapes
+func rankLabelValues(weights map[string]float64) []string {
+	values := make([]string, 0, len(weights))
+	for value := range weights {
+		values = append(values, value)
+	}
+	slices.SortFunc(values, func(a, b string) int {
+		if c := cmp.Compare(weights[b], weights[a]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	return values
+}
+
+// the n label values with the largest weight, returns nil when every value fits
+func topLabelValues(weights map[string]float64, n int) map[string]struct{} {
+	if n <= 0 || len(weights) <= n {
+		return nil
+	}
+	kept := make(map[string]struct{}, n)
+	for _, value := range rankLabelValues(weights)[:n] {
+		kept[value] = struct{}{}
+	}
+	return kept
+}
+
+// real values reading as otherLabelValue get one more leading underscore so they never share a series with the folded values,
+// i.e a user named other is exported as _other and one named _other as __other
+func escapeLabel(value string) string {
+	if strings.TrimLeft(value, "_") == otherLabelValue {
+		return "_" + value
+	}
+	return value
+}
+
+// whether value is among kept, every value is when kept is nil
+func keepsLabel(kept map[string]struct{}, value string) bool {
+	_, ok := kept[value]
+	return kept == nil || ok
+}
+
+// value as exported by a limited label, otherLabelValue unless kept is nil or holds value
+func foldLabel(kept map[string]struct{}, value string) string {
+	if !keepsLabel(kept, value) {
+		return otherLabelValue
+	}
+	return escapeLabel(value)
+}
+
+// values of weights folded by kept
+func foldedCount(weights map[string]float64, kept map[string]struct{}) float64 {
+	if kept == nil {
+		return 0
+	}
+	return float64(len(weights) - len(kept))
+}
+
+// copy of jobs with the users and accounts past the limits renamed to otherLabelValue
+// so every metric aggregated from them is capped. Also returns how many values were folded per label
+func (ll LabelLimiter) foldJobs(jobs []JobMetric) ([]JobMetric, map[string]float64) {
+	folded := map[string]float64{"username": 0, "account": 0}
+	if !ll.enabled() {
+		return jobs, folded
+	}
+	userWeights := make(map[string]float64)
+	accountWeights := make(map[string]float64)
+	for i := range jobs {
+		weight := ll.jobWeight(&jobs[i])
+		userWeights[jobs[i].UserName] += weight
+		accountWeights[jobs[i].Account] += weight
+	}
+	users := topLabelValues(userWeights, ll.maxUsers)
+	accounts := topLabelValues(accountWeights, ll.maxAccounts)
+	folded["username"] = foldedCount(userWeights, users)
+	folded["account"] = foldedCount(accountWeights, accounts)
+	// values are escaped even when everything fits so a user named other doesn't change series once folding starts
+	foldedJobs := slices.Clone(jobs)
+	for i := range foldedJobs {
+		job := &foldedJobs[i]
+		if ll.maxUsers > 0 {
+			job.UserName = foldLabel(users, job.UserName)
+		}
+		if ll.maxAccounts > 0 {
+			job.Account = foldLabel(accounts, job.Account)
+		}
+	}
+	return foldedJobs, folded
+}
+
+// rpc users past the user limit merged into a single otherLabelValue entry, ranked by rpc count
+// entries sharing an exported user are merged so every user is emitted once. Also returns how many users were folded
+func (ll LabelLimiter) foldUserRpcs(rpcs []UserRpcInfo) ([]UserRpcInfo, float64) {
+	if ll.maxUsers <= 0 {
+		return rpcs, 0
+	}
+	counts := make(map[string]float64, len(rpcs))
+	for _, rpc := range rpcs {
+		counts[rpc.User] += float64(rpc.Count)
+	}
+	users := topLabelValues(counts, ll.maxUsers)
+	foldedRpcs := make([]UserRpcInfo, 0, min(len(counts), ll.maxUsers+1))
+	index := make(map[string]int, cap(foldedRpcs))
+	for _, rpc := range rpcs {
+		user := foldLabel(users, rpc.User)
+		if i, ok := index[user]; ok {
+			foldedRpcs[i].Count += rpc.Count
+			foldedRpcs[i].TotalTime += rpc.TotalTime
+			continue
+		}
+		if user == otherLabelValue {
+			rpc = UserRpcInfo{Count: rpc.Count, TotalTime: rpc.TotalTime}
+		}
+		rpc.User = user
+		index[user] = len(foldedRpcs)
+		foldedRpcs = append(foldedRpcs, rpc)
+	}
+	return foldedRpcs, foldedCount(counts, users)
+}
+
+// admitted label values not seen in any fetch for this long free their slot for the largest new values
+const stickyLabelsIdle = 24 * time.Hour
+
+// label values of counters and histograms, which keep exporting every series they've seen.
+// Ranking every fetch again would move values between their own series and other, so admitted values stay admitted
+// while they're active and the largest values of each fetch are admitted while fewer than limit are kept.
+// Values idle for stickyLabelsIdle are evicted so a new large user isn't folded until a restart. A limit of 0 admits everything
+type stickyLabels struct {
+	sync.Mutex
+	limit int
+	idle  time.Duration
+	now   func() time.Time
+	// admitted values and the last fetch they were seen in
+	kept   map[string]time.Time
+	folded map[string]struct{}
+}
+
+func newStickyLabels(limit int) *stickyLabels {
+	return &stickyLabels{
+		limit:  limit,
+		idle:   stickyLabelsIdle,
+		now:    time.Now,
+		kept:   make(map[string]time.Time),
+		folded: make(map[string]struct{}),
+	}
+}
+
+// exported label of every value of a fetch, weighted by its share of the fetch
+func (sl *stickyLabels) fold(weights map[string]float64) map[string]string {
+	labels := make(map[string]string, len(weights))
+	if sl.limit <= 0 {
+		for value := range weights {
+			labels[value] = value
+		}
+		return labels
+	}
+	sl.Lock()
+	defer sl.Unlock()
+	now := sl.now()
+	for value, seen := range sl.kept {
+		if _, ok := weights[value]; !ok && now.Sub(seen) > sl.idle {
+			delete(sl.kept, value)
+		}
+	}
+	for _, value := range rankLabelValues(weights) {
+		if _, ok := sl.kept[value]; ok || len(sl.kept) < sl.limit {
+			sl.kept[value] = now
+			delete(sl.folded, value)
+			labels[value] = escapeLabel(value)
+			continue
+		}
+		sl.folded[value] = struct{}{}
+		labels[value] = otherLabelValue
+	}
+	return labels
+}
+
+// values counted as otherLabelValue since the exporter started and not admitted since
+func (sl *stickyLabels) foldedCount() float64 {
+	sl.Lock()
+	defer sl.Unlock()
+	return float64(len(sl.folded))
+}
+
+// the collector const label keeps the desc unique when several collectors fold values
+func newFoldedValuesDesc(config *Config, collector string) *prometheus.Desc {
+	constLabels := prometheus.Labels{"collector": collector}
+	for name, value := range config.constLabels() {
+		constLabels[name] = value
+	}
+	return prometheus.NewDesc("slurm_label_values_folded", "label values folded into other by the cardinality limits", []string{"label"}, constLabels)
+}
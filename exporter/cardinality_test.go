@@ -0,0 +1,154 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLabelLimiter(t *testing.T) {
+	assert := assert.New(t)
+	limiter, err := NewLabelLimiter(10, 0, "")
+	assert.NoError(err)
+	assert.Equal(limitByCpus, limiter.by)
+	assert.True(limiter.enabled())
+	_, err = NewLabelLimiter(10, 10, "gpus")
+	assert.Error(err)
+	assert.False(LabelLimiter{}.enabled())
+}
+
+func TestTopLabelValues(t *testing.T) {
+	assert := assert.New(t)
+	weights := map[string]float64{"user1": 4, "user2": 8, "user3": 4, "user4": 1}
+	assert.Nil(topLabelValues(weights, 0))
+	assert.Nil(topLabelValues(weights, 4))
+	// ties go to the smallest value
+	assert.Equal(map[string]struct{}{"user2": {}, "user1": {}}, topLabelValues(weights, 2))
+}
+
+func TestEscapeLabel(t *testing.T) {
+	assert := assert.New(t)
+	for value, expected := range map[string]string{"user1": "user1", otherLabelValue: "_other", "_other": "__other", "other_": "other_", "": ""} {
+		assert.Equal(expected, escapeLabel(value), value)
+	}
+}
+
+func TestStickyLabels(t *testing.T) {
+	assert := assert.New(t)
+	labels := newStickyLabels(2)
+	assert.Equal(map[string]string{"user1": "user1", "user2": "user2", "user3": otherLabelValue}, labels.fold(map[string]float64{"user1": 3, "user2": 2, "user3": 1}))
+	// user3 outranks user1 now, but admitted values stay admitted so series don't move to other
+	assert.Equal(map[string]string{"user1": "user1", "user3": otherLabelValue, "user4": otherLabelValue}, labels.fold(map[string]float64{"user1": 1, "user3": 5, "user4": 5}))
+	assert.Equal(2., labels.foldedCount())
+	// no limit
+	assert.Equal(map[string]string{"user1": "user1"}, newStickyLabels(0).fold(map[string]float64{"user1": 1}))
+	// user1 idles past the window and frees its slot for the largest new value
+	now := time.Now()
+	labels.now = func() time.Time { return now.Add(stickyLabelsIdle / 2) }
+	assert.Equal(map[string]string{"user2": "user2", "user3": otherLabelValue}, labels.fold(map[string]float64{"user2": 1, "user3": 5}))
+	labels.now = func() time.Time { return now.Add(2 * stickyLabelsIdle) }
+	assert.Equal(map[string]string{"user2": "user2", "user3": "user3", "user4": otherLabelValue}, labels.fold(map[string]float64{"user2": 1, "user3": 5, "user4": 4}))
+	assert.Equal(1., labels.foldedCount())
+	// a real value named other never shares the folded series
+	assert.Equal(map[string]string{otherLabelValue: "_other", "user1": otherLabelValue}, newStickyLabels(1).fold(map[string]float64{otherLabelValue: 2, "user1": 1}))
+}
+
+func TestFoldJobs(t *testing.T) {
+	assert := assert.New(t)
+	job := func(user string, account string, cpus float64) JobMetric {
+		return JobMetric{UserName: user, Account: account, JobState: "RUNNING", JobResources: JobResource{AllocCpus: cpus}}
+	}
+	jobs := []JobMetric{job("user1", "account1", 8), job("user2", "account1", 2), job("user2", "account2", 2), job("user3", "account3", 1)}
+	limiter, err := NewLabelLimiter(1, 0, limitByCpus)
+	assert.NoError(err)
+	folded, count := limiter.foldJobs(jobs)
+	assert.Equal(map[string]float64{"username": 2, "account": 0}, count)
+	assert.Equal([]string{"user1", otherLabelValue, otherLabelValue, otherLabelValue}, []string{folded[0].UserName, folded[1].UserName, folded[2].UserName, folded[3].UserName})
+	assert.Equal("account2", folded[2].Account)
+	// the fetched jobs are shared with the other collectors and must not be renamed
+	assert.Equal("user2", jobs[1].UserName)
+
+	// user2 has the most jobs, account1 the most cpus
+	limiter, err = NewLabelLimiter(1, 1, limitByJobs)
+	assert.NoError(err)
+	folded, count = limiter.foldJobs(jobs)
+	assert.Equal(map[string]float64{"username": 2, "account": 2}, count)
+	assert.Equal("user2", folded[1].UserName)
+	assert.Equal(otherLabelValue, folded[0].UserName)
+	assert.Equal("account1", folded[0].Account)
+	assert.Equal(otherLabelValue, folded[3].Account)
+
+	// everything fits
+	folded, count = LabelLimiter{maxUsers: 10, maxAccounts: 10}.foldJobs(jobs)
+	assert.Equal(jobs, folded)
+	assert.Equal(map[string]float64{"username": 0, "account": 0}, count)
+
+	// a real user named other is escaped whether or not anyone is folded
+	jobs = append(jobs, job(otherLabelValue, "account1", 16))
+	folded, _ = LabelLimiter{maxUsers: 1}.foldJobs(jobs)
+	assert.Equal([]string{otherLabelValue, "_other"}, []string{folded[0].UserName, folded[4].UserName})
+	folded, _ = LabelLimiter{maxUsers: 10}.foldJobs(jobs)
+	assert.Equal("_other", folded[4].UserName)
+	assert.Equal(otherLabelValue, jobs[4].UserName)
+}
+
+func TestFoldUserRpcs(t *testing.T) {
+	assert := assert.New(t)
+	rpcs := []UserRpcInfo{{User: "root", Count: 100, TotalTime: 10}, {User: "user1", Count: 5, TotalTime: 3}, {User: "user2", Count: 7, TotalTime: 2}}
+	folded, count := LabelLimiter{maxUsers: 1}.foldUserRpcs(rpcs)
+	assert.Equal(2., count)
+	assert.Equal([]UserRpcInfo{{User: "root", Count: 100, TotalTime: 10}, {User: otherLabelValue, Count: 12, TotalTime: 5}}, folded)
+	folded, count = LabelLimiter{}.foldUserRpcs(rpcs)
+	assert.Equal(0., count)
+	assert.Equal(rpcs, folded)
+	// no other entry when every user fits
+	folded, count = LabelLimiter{maxUsers: 3}.foldUserRpcs(rpcs)
+	assert.Equal(0., count)
+	assert.Equal(rpcs, folded)
+
+	// a real user named other keeps its own series
+	rpcs = []UserRpcInfo{{User: otherLabelValue, UserId: 1000, Count: 50, TotalTime: 4}, {User: "user1", UserId: 1001, Count: 5, TotalTime: 3}, {User: "user2", UserId: 1002, Count: 7, TotalTime: 2}}
+	folded, count = LabelLimiter{maxUsers: 1}.foldUserRpcs(rpcs)
+	assert.Equal(2., count)
+	assert.Equal([]UserRpcInfo{{User: "_other", UserId: 1000, Count: 50, TotalTime: 4}, {User: otherLabelValue, Count: 12, TotalTime: 5}}, folded)
+}
+
+func TestDiagCollect_LabelLimits(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{MaxUsers: 1})
+	assert.NoError(err)
+	dc := NewDiagsCollector(config)
+	dc.fetcher = &DiagJsonFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/sdiag.json"},
+		cache:        NewAtomicThrottledCache[DiagMetric](1),
+		errorCounter: newMockErrorCounter(),
+	}
+	metricChan := make(chan prometheus.Metric)
+	go func() {
+		dc.Collect(metricChan)
+		close(metricChan)
+	}()
+	users := make(map[string]float64)
+	folded := 0.
+	for metric := range metricChan {
+		dtoMetric := new(dto.Metric)
+		assert.NoError(metric.Write(dtoMetric))
+		desc := metric.Desc().String()
+		if strings.Contains(desc, "slurm_rpc_user_count") {
+			users[dtoMetric.GetLabel()[0].GetValue()] = dtoMetric.GetGauge().GetValue()
+		}
+		if strings.Contains(desc, "slurm_label_values_folded") {
+			folded = dtoMetric.GetGauge().GetValue()
+		}
+	}
+	assert.Equal(map[string]float64{"root": 141368, otherLabelValue: 20954}, users)
+	assert.Equal(1., folded)
+}
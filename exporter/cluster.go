@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// per cluster command overrides. Unset commands default to the global command with `-M <name>` injected
+type ClusterFlags struct {
+	Name                     string `yaml:"name"`
+	SlurmSqueueOverride      string `yaml:"squeue_cli"`
+	SlurmSinfoOverride       string `yaml:"sinfo_cli"`
+	SlurmDiagOverride        string `yaml:"diag_cli"`
+	SlurmLicenseOverride     string `yaml:"lic_cli"`
+	SlurmAcctOverride        string `yaml:"sacctmgr_cli"`
+	SlurmSshareOverride      string `yaml:"sshare_cli"`
+	SlurmSprioOverride       string `yaml:"sprio_cli"`
+	SlurmSacctOverride       string `yaml:"sacct_cli"`
+	SlurmReservationOverride string `yaml:"reservation_cli"`
+	SlurmSstatOverride       string `yaml:"sstat_cli"`
+	SlurmSacctEffOverride    string `yaml:"sacct_efficiency_cli"`
+}
+
+// implements flag.Value so clusters can be listed on the cli as a comma separated list of names
+type ClusterList []ClusterFlags
+
+func (cl *ClusterList) String() string {
+	if cl == nil {
+		return ""
+	}
+	names := make([]string, 0, len(*cl))
+	for _, cluster := range *cl {
+		names = append(names, cluster.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (cl *ClusterList) Set(value string) error {
+	clusters := make(ClusterList, 0)
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			clusters = append(clusters, ClusterFlags{Name: name})
+		}
+	}
+	*cl = clusters
+	return nil
+}
+
+// prefix slurm prints before the output of each cluster when invoked with -M
+var clusterHeader = []byte("CLUSTER: ")
+
+func isClusterHeader(line []byte) bool {
+	return bytes.HasPrefix(line, clusterHeader)
+}
+
+// insert `-M <cluster>` right after the command name
+func withCluster(args []string, cluster string) []string {
+	if len(args) == 0 {
+		return args
+	}
+	return append([]string{args[0], "-M", cluster}, args[1:]...)
+}
+
+// sacctmgr selects clusters with a `cluster=` condition instead of -M
+func withSacctCluster(args []string, cluster string) []string {
+	return append(append([]string{}, args...), "cluster="+cluster)
+}
+
+// sstat has no -M, it only reaches the cluster it runs on. Clusters without an sstat_cli override get no sstat
+func withoutCluster(args []string, cluster string) []string {
+	return nil
+}
+
+// pick the cluster override if set, otherwise scope the global command to the cluster
+func clusterCommand(override string, global []string, cluster string, scope func([]string, string) []string) []string {
+	if override != "" {
+		return strings.Split(override, " ")
+	}
+	return scope(global, cluster)
+}
+
+// derive a config scoped to a single cluster from the global config
+// caches, error counters and the shared job fetcher are never shared between clusters
+func newClusterConfig(global *Config, cluster ClusterFlags, traceEnabled bool) (*Config, error) {
+	if cluster.Name == "" {
+		return nil, errors.New("cluster name must be set")
+	}
+	cliOpts := *global.cliOpts
+	cliOpts.squeue = clusterCommand(cluster.SlurmSqueueOverride, global.cliOpts.squeue, cluster.Name, withCluster)
+	cliOpts.sinfo = clusterCommand(cluster.SlurmSinfoOverride, global.cliOpts.sinfo, cluster.Name, withCluster)
+	cliOpts.sdiag = clusterCommand(cluster.SlurmDiagOverride, global.cliOpts.sdiag, cluster.Name, withCluster)
+	cliOpts.lic = clusterCommand(cluster.SlurmLicenseOverride, global.cliOpts.lic, cluster.Name, withCluster)
+	cliOpts.sacctmgr = clusterCommand(cluster.SlurmAcctOverride, global.cliOpts.sacctmgr, cluster.Name, withSacctCluster)
+	cliOpts.sshare = clusterCommand(cluster.SlurmSshareOverride, global.cliOpts.sshare, cluster.Name, withCluster)
+	cliOpts.sprio = clusterCommand(cluster.SlurmSprioOverride, global.cliOpts.sprio, cluster.Name, withCluster)
+	cliOpts.sacct = clusterCommand(cluster.SlurmSacctOverride, global.cliOpts.sacct, cluster.Name, withCluster)
+	cliOpts.reservations = clusterCommand(cluster.SlurmReservationOverride, global.cliOpts.reservations, cluster.Name, withCluster)
+	cliOpts.sacctEfficiency = clusterCommand(cluster.SlurmSacctEffOverride, global.cliOpts.sacctEfficiency, cluster.Name, withCluster)
+	cliOpts.sstat = clusterCommand(cluster.SlurmSstatOverride, global.cliOpts.sstat, cluster.Name, withoutCluster)
+	if cliOpts.sacctCursorFile != "" {
+		// every cluster has its own high-water mark
+		cliOpts.sacctCursorFile += "." + cluster.Name
+	}
+	traceConf := *global.TraceConf
+	traceConf.enabled = traceEnabled
+	config := *global
+	config.cliOpts = &cliOpts
+	config.TraceConf = &traceConf
+	config.caches = nil
+	config.commandErrors = nil
+	config.clusters = nil
+	config.cluster = cluster.Name
+	config.initJobFetcher()
+	return &config, nil
+}
+
+// labels added to every metric emitted for this config. nil unless scraping multiple clusters
+func (c *Config) constLabels() prometheus.Labels {
+	if c.cluster == "" {
+		return nil
+	}
+	return prometheus.Labels{"cluster": c.cluster}
+}
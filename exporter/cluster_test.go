@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterList(t *testing.T) {
+	assert := assert.New(t)
+	var clusters ClusterList
+	assert.NoError(clusters.Set("alpha, beta,,"))
+	assert.Equal(ClusterList{{Name: "alpha"}, {Name: "beta"}}, clusters)
+	assert.Equal("alpha,beta", clusters.String())
+}
+
+func TestWithCluster(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal([]string{"squeue", "-M", "alpha", "--json"}, withCluster([]string{"squeue", "--json"}, "alpha"))
+	args := []string{"sacctmgr", "show", "assoc"}
+	assert.Equal([]string{"sacctmgr", "show", "assoc", "cluster=alpha"}, withSacctCluster(args, "alpha"))
+	// global args are never mutated
+	assert.Equal([]string{"sacctmgr", "show", "assoc"}, args)
+}
+
+func TestNewConfig_Clusters(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{
+		SlurmCliFallback: true,
+		TraceEnabled:     true,
+		SacctCursorFile:  "/var/lib/slurm-exporter/sacct.cursor",
+		Clusters: ClusterList{
+			{Name: "alpha"},
+			{Name: "beta", SlurmSqueueOverride: "cat fixtures/squeue_fallback.txt"},
+		},
+	})
+	assert.NoError(err)
+	assert.Len(config.clusters, 2)
+	alpha, beta := config.clusters[0], config.clusters[1]
+	assert.Equal([]string{"squeue", "-M", "alpha"}, alpha.cliOpts.squeue[:3])
+	assert.Equal([]string{"cat", "fixtures/squeue_fallback.txt"}, beta.cliOpts.squeue)
+	assert.Equal([]string{"sinfo", "-M", "beta"}, beta.cliOpts.sinfo[:3])
+	assert.Equal([]string{"sshare", "-M", "alpha"}, alpha.cliOpts.sshare[:3])
+	assert.Equal([]string{"scontrol", "-M", "alpha", "show", "reservation", "-o"}, alpha.cliOpts.reservations)
+	// sstat can't select a cluster without an override
+	assert.Nil(alpha.cliOpts.sstat)
+	assert.Equal("/var/lib/slurm-exporter/sacct.cursor.alpha", alpha.cliOpts.sacctCursorFile)
+	// global commands are left untouched
+	assert.NotContains(config.cliOpts.squeue, "-M")
+	// only the first cluster accepts traces
+	assert.True(alpha.TraceConf.enabled)
+	assert.False(beta.TraceConf.enabled)
+	assert.NotSame(alpha.TraceConf.sharedFetcher, beta.TraceConf.sharedFetcher)
+	assert.NotSame(alpha.commandErrors, beta.commandErrors)
+	assert.Equal("beta", beta.constLabels()["cluster"])
+	assert.Nil(config.constLabels())
+}
+
+func TestNewConfig_ClustersInvalid(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewConfig(&CliFlags{Clusters: ClusterList{{Name: ""}}})
+	assert.Error(err)
+	_, err = NewConfig(&CliFlags{SlurmRestUrl: "http://localhost:6820", Clusters: ClusterList{{Name: "alpha"}}})
+	assert.Error(err)
+}
+
+func TestClusterCollectors(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{
+		SlurmCliFallback: true,
+		Clusters: ClusterList{
+			{Name: "alpha", SlurmSqueueOverride: "cat fixtures/squeue_fallback.txt", SlurmSinfoOverride: "cat fixtures/sinfo_fallback.txt"},
+			{Name: "beta", SlurmSqueueOverride: "cat fixtures/squeue_fallback.txt", SlurmSinfoOverride: "cat fixtures/sinfo_fallback.txt"},
+		},
+	})
+	assert.NoError(err)
+	collectors, _ := newCollectors(context.Background(), config)
+	server := NewPromHTTPServer(nil, collectors...)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	// identical series from both clusters must not collide
+	assert.Equal(200, w.Code)
+	txt := w.Body.String()
+	assert.Contains(txt, `slurm_cpus_total{cluster="alpha"}`)
+	assert.Contains(txt, `slurm_cpus_total{cluster="beta"}`)
+}
+
+func TestClusterHeader_Fallback(t *testing.T) {
+	assert := assert.New(t)
+	squeue, err := os.ReadFile("fixtures/squeue_fallback.txt")
+	assert.NoError(err)
+	path := filepath.Join(t.TempDir(), "squeue.txt")
+	assert.NoError(os.WriteFile(path, append([]byte("CLUSTER: alpha\n"), squeue...), 0o600))
+	newFetcher := func(path string) *JobCliFallbackFetcher {
+		return &JobCliFallbackFetcher{
+			scraper:    NewCliScraper("cat", path),
+			cache:      NewAtomicThrottledCache[JobMetric](1),
+			errCounter: newMockErrorCounter(),
+		}
+	}
+	// the header line is skipped rather than counted as a parse error
+	expected, control := newFetcher("fixtures/squeue_fallback.txt"), newFetcher(path)
+	expectedMetrics, err := expected.fetch(context.Background())
+	assert.NoError(err)
+	metrics, err := control.fetch(context.Background())
+	assert.NoError(err)
+	assert.Equal(expectedMetrics, metrics)
+	assert.Equal(CollectCounterValue(expected.errCounter.WithLabelValues(reasonParse)), CollectCounterValue(control.errCounter.WithLabelValues(reasonParse)))
+}
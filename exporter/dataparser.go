@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"log/slog"
+)
+
+// data_parser versions whose job and node schemas we've verified against. Newer versions are decoded on a best effort basis
+var supportedDataParsers = []string{"data_parser/v0.0.40", "data_parser/v0.0.41"}
+
+// meta block shared by every slurm json response
+// openapi/v0.0.37 only reports the plugin type while the data_parser plugin (slurm 23.11+) also reports its version
+type slurmMeta struct {
+	SlurmVersion SlurmVersion      `json:"Slurm"`
+	Plugins      map[string]string `json:"plugins"`
+	Plugin       map[string]string `json:"plugin"`
+}
+
+func (sm *slurmMeta) isDataParser() bool {
+	if sm.Plugins != nil {
+		_, ok := sm.Plugins["data_parser"]
+		return ok
+	}
+	if sm.Plugin != nil {
+		_, ok := sm.Plugin["data_parser"]
+		return ok
+	}
+	return false
+}
+
+// i.e data_parser/v0.0.41. Empty for legacy responses
+func (sm *slurmMeta) dataParser() string {
+	if sm.Plugins != nil {
+		return sm.Plugins["data_parser"]
+	}
+	return sm.Plugin["data_parser"]
+}
+
+// decode only the meta block of a response so the rest can be decoded with the matching schema
+func parseSlurmMeta(data []byte) (*slurmMeta, error) {
+	var resp struct {
+		Meta slurmMeta `json:"meta"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Meta.isDataParser() && !slices.Contains(supportedDataParsers, resp.Meta.dataParser()) {
+		slog.Warn(fmt.Sprintf("untested data_parser version %q, supported versions are %v", resp.Meta.dataParser(), supportedDataParsers))
+	}
+	return &resp.Meta, nil
+}
+
+// number that the data_parser may wrap as {"set": true, "infinite": false, "number": 1234}
+// plain numbers are accepted as is. Unset and infinite numbers decode to 0
+type OptionalFloat float64
+
+func (of *OptionalFloat) UnmarshalJSON(data []byte) error {
+	var nativeFloat float64
+	if err := json.Unmarshal(data, &nativeFloat); err == nil {
+		*of = OptionalFloat(nativeFloat)
+		return nil
+	}
+	var numStruct struct {
+		Set      bool    `json:"set"`
+		Infinite bool    `json:"infinite"`
+		Number   float64 `json:"number"`
+	}
+	if err := json.Unmarshal(data, &numStruct); err != nil {
+		return err
+	}
+	if !numStruct.Set || numStruct.Infinite {
+		*of = 0
+		return nil
+	}
+	*of = OptionalFloat(numStruct.Number)
+	return nil
+}
+
+// data_parser errors are objects instead of plain strings
+type dataParserError struct {
+	Description string `json:"description"`
+	Error       string `json:"error"`
+}
+
+func (dpe dataParserError) String() string {
+	if dpe.Description == "" {
+		return dpe.Error
+	}
+	return dpe.Error + ": " + dpe.Description
+}
+
+// data_parser reports node states as a base state followed by flags i.e ["IDLE", "DRAIN"]
+// flatten to the + separated form scontrol uses, lowercased like sinfo i.e idle+drain
+func joinStates(states []string) string {
+	return strings.ToLower(strings.Join(states, "+"))
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSlurmMeta(t *testing.T) {
+	assert := assert.New(t)
+	for fixture, expected := range map[string]string{
+		"fixtures/squeue_out.json":  "",
+		"fixtures/squeue_2311.json": "data_parser/v0.0.40",
+		"fixtures/sinfo_2405.json":  "data_parser/v0.0.41",
+		"fixtures/sdiag_2405.json":  "data_parser/v0.0.41",
+	} {
+		data, err := os.ReadFile(fixture)
+		assert.NoError(err)
+		meta, err := parseSlurmMeta(data)
+		assert.NoError(err)
+		assert.Equal(expected != "", meta.isDataParser(), fixture)
+		assert.Equal(expected, meta.dataParser(), fixture)
+	}
+	meta, err := parseSlurmMeta([]byte(`{"meta": {"plugin": {"data_parser": "data_parser/v0.0.41"}, "slurm": {"version": {"major": "24", "minor": "05", "micro": "5"}}}}`))
+	assert.NoError(err)
+	assert.Equal(24, int(meta.SlurmVersion.Version.Major))
+}
+
+func TestOptionalFloat(t *testing.T) {
+	assert := assert.New(t)
+	for data, expected := range map[string]float64{
+		`12.5`: 12.5,
+		`{"set": true, "infinite": false, "number": 1234}`:  1234,
+		`{"set": false, "infinite": false, "number": 1234}`: 0,
+		`{"set": true, "infinite": true, "number": 0}`:      0,
+		`null`: 0,
+	} {
+		var f OptionalFloat
+		assert.NoError(f.UnmarshalJSON([]byte(data)), data)
+		assert.Equal(expected, float64(f), data)
+	}
+	var f OptionalFloat
+	assert.Error(f.UnmarshalJSON([]byte(`"12"`)))
+}
+
+func TestJoinStates(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("idle+drain", joinStates([]string{"IDLE", "DRAIN"}))
+	assert.Equal("mixed", joinStates([]string{"MIXED"}))
+}
@@ -5,8 +5,11 @@
 package exporter
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"log/slog"
 
@@ -76,26 +79,14 @@ type DiagMetric struct {
 
 type SdiagResponse struct {
 	// Response coercible between slurm 23 and 24 data versions
-	Meta struct {
-		SlurmVersion SlurmVersion      `json:"Slurm"`
-		Plugins      map[string]string `json:"plugins"`
-		Plugin       map[string]string `json:"plugin"`
-	} `json:"meta"`
+	Meta       slurmMeta `json:"meta"`
 	Statistics DiagMetric
 	Errors     []string `json:"errors"`
 	Warnings   []string `json:"warnings"`
 }
 
 func (sr *SdiagResponse) IsDataParserPlugin() bool {
-	if sr.Meta.Plugins != nil {
-		_, ok := sr.Meta.Plugins["data_parser"]
-		return ok
-	}
-	if sr.Meta.Plugin != nil {
-		_, ok := sr.Meta.Plugin["data_parser"]
-		return ok
-	}
-	return false
+	return sr.Meta.isDataParser()
 }
 
 func parseDiagMetrics(sdiagResp []byte) (*SdiagResponse, error) {
@@ -104,14 +95,47 @@ func parseDiagMetrics(sdiagResp []byte) (*SdiagResponse, error) {
 	return sdiag, err
 }
 
+type DiagJsonFetcher struct {
+	scraper      SlurmByteScraper
+	cache        *AtomicThrottledCache[DiagMetric]
+	errorCounter *prometheus.CounterVec
+}
+
+func (djf *DiagJsonFetcher) fetch(ctx context.Context) ([]DiagMetric, error) {
+	sdiag, err := djf.scraper.FetchRawBytes(ctx)
+	if err != nil {
+		observeCommandError(djf.errorCounter, err)
+		return nil, err
+	}
+	sdiagResponse, err := parseDiagMetrics(sdiag)
+	if err != nil {
+		djf.errorCounter.WithLabelValues(reasonParse).Inc()
+		return nil, err
+	}
+	if !sdiagResponse.IsDataParserPlugin() {
+		djf.errorCounter.WithLabelValues(reasonParse).Inc()
+		return nil, errors.New("only the data_parser plugin is supported")
+	}
+	return []DiagMetric{sdiagResponse.Statistics}, nil
+}
+
+func (djf *DiagJsonFetcher) FetchMetrics(ctx context.Context) ([]DiagMetric, error) {
+	return djf.cache.FetchOrThrottle(func() ([]DiagMetric, error) { return djf.fetch(ctx) })
+}
+
+func (djf *DiagJsonFetcher) ScrapeDuration() time.Duration {
+	return djf.scraper.Duration()
+}
+
 type DiagnosticsCollector struct {
 	// collector state
-	fetcher            SlurmByteScraper
-	diagScrapeError    prometheus.Counter
+	fetcher            SlurmMetricFetcher[DiagMetric]
 	diagScrapeDuration *prometheus.Desc
 	// user rpc metrics
 	slurmUserRpcCount     *prometheus.Desc
 	slurmUserRpcTotalTime *prometheus.Desc
+	labelLimits           LabelLimiter
+	foldedValues          *prometheus.Desc
 	// type rpc metrics
 	slurmTypeRpcCount     *prometheus.Desc
 	slurmTypeRpcAvgTime   *prometheus.Desc
@@ -128,31 +152,35 @@ type DiagnosticsCollector struct {
 
 func NewDiagsCollector(config *Config) *DiagnosticsCollector {
 	cliOpts := config.cliOpts
+	fetcher := &DiagJsonFetcher{
+		scraper:      cliOpts.newScraper(restDiags, cliOpts.sdiag),
+		cache:        newConfiguredCache[DiagMetric](config, "diags"),
+		errorCounter: config.commandErrorCounter("sdiag"),
+	}
 	return &DiagnosticsCollector{
-		fetcher:                        NewCliScraper(config.cliOpts.sdiag...),
-		slurmUserRpcCount:              prometheus.NewDesc("slurm_rpc_user_count", "slurm rpc count per user", []string{"user"}, nil),
-		slurmUserRpcTotalTime:          prometheus.NewDesc("slurm_rpc_user_total_time", "slurm rpc avg time per user", []string{"user"}, nil),
-		slurmTypeRpcCount:              prometheus.NewDesc("slurm_rpc_msg_type_count", "slurm rpc count per message type", []string{"type"}, nil),
-		slurmTypeRpcAvgTime:            prometheus.NewDesc("slurm_rpc_msg_type_avg_time", "slurm rpc total time consumed per message type", []string{"type"}, nil),
-		slurmTypeRpcTotalTime:          prometheus.NewDesc("slurm_rpc_msg_type_total_time", "slurm rpc avg time per message type", []string{"type"}, nil),
-		slurmCtlThreadCount:            prometheus.NewDesc("slurm_daemon_thread_count", "slurm daemon thread count", nil, nil),
-		slurmDbdAgentQueueSize:         prometheus.NewDesc("slurm_dbd_agent_queue_size", "slurmDbd queue size. Number of threads interacting with SlrumDBD. Will grow rapidly if DB is down or under stress", nil, nil),
-		slurmBackfillJobCount:          prometheus.NewDesc("slurm_backfill_job_count", "slurm number of jobs started thanks to backfilling since last slurm start", nil, nil),
-		slurmBackfillCycleCount:        prometheus.NewDesc("slurm_backfill_cycle_count", "slurm number of Number of backfill scheduling cycles since last reset", nil, nil),
-		slurmBackfillLastDepth:         prometheus.NewDesc("slurm_backfill_last_depth", "slurm number of processed jobs during last backfilling scheduling cycle. It counts every job even if that job can not be started due to dependencies or limits", nil, nil),
-		slurmBackfillLastDepthTrySched: prometheus.NewDesc("slurm_backfill_last_depth_try_sched", "slurm number of processed jobs during last backfilling scheduling cycle. It counts only jobs with a chance to start using available resources", nil, nil),
-		slurmBackfillCycleCounter:      prometheus.NewDesc("slurm_backfill_cycle_counter", "slurm number of backfill scheduling cycles since last reset", nil, nil),
-		diagScrapeError: prometheus.NewCounter(prometheus.CounterOpts{
-			Name: "slurm_diag_scrape_error",
-			Help: "slurm diag scrape erro",
-		}),
-		diagScrapeDuration: prometheus.NewDesc("slurm_diag_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.sdiag), nil, nil),
+		fetcher:                        fetcher,
+		labelLimits:                    cliOpts.labelLimits,
+		foldedValues:                   newFoldedValuesDesc(config, "diags"),
+		slurmUserRpcCount:              prometheus.NewDesc("slurm_rpc_user_count", "slurm rpc count per user", []string{"user"}, config.constLabels()),
+		slurmUserRpcTotalTime:          prometheus.NewDesc("slurm_rpc_user_total_time", "slurm rpc avg time per user", []string{"user"}, config.constLabels()),
+		slurmTypeRpcCount:              prometheus.NewDesc("slurm_rpc_msg_type_count", "slurm rpc count per message type", []string{"type"}, config.constLabels()),
+		slurmTypeRpcAvgTime:            prometheus.NewDesc("slurm_rpc_msg_type_avg_time", "slurm rpc total time consumed per message type", []string{"type"}, config.constLabels()),
+		slurmTypeRpcTotalTime:          prometheus.NewDesc("slurm_rpc_msg_type_total_time", "slurm rpc avg time per message type", []string{"type"}, config.constLabels()),
+		slurmCtlThreadCount:            prometheus.NewDesc("slurm_daemon_thread_count", "slurm daemon thread count", nil, config.constLabels()),
+		slurmDbdAgentQueueSize:         prometheus.NewDesc("slurm_dbd_agent_queue_size", "slurmDbd queue size. Number of threads interacting with SlrumDBD. Will grow rapidly if DB is down or under stress", nil, config.constLabels()),
+		slurmBackfillJobCount:          prometheus.NewDesc("slurm_backfill_job_count", "slurm number of jobs started thanks to backfilling since last slurm start", nil, config.constLabels()),
+		slurmBackfillCycleCount:        prometheus.NewDesc("slurm_backfill_cycle_count", "slurm number of Number of backfill scheduling cycles since last reset", nil, config.constLabels()),
+		slurmBackfillLastDepth:         prometheus.NewDesc("slurm_backfill_last_depth", "slurm number of processed jobs during last backfilling scheduling cycle. It counts every job even if that job can not be started due to dependencies or limits", nil, config.constLabels()),
+		slurmBackfillLastDepthTrySched: prometheus.NewDesc("slurm_backfill_last_depth_try_sched", "slurm number of processed jobs during last backfilling scheduling cycle. It counts only jobs with a chance to start using available resources", nil, config.constLabels()),
+		slurmBackfillCycleCounter:      prometheus.NewDesc("slurm_backfill_cycle_counter", "slurm number of backfill scheduling cycles since last reset", nil, config.constLabels()),
+		diagScrapeDuration:             prometheus.NewDesc("slurm_diag_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.sdiag), nil, config.constLabels()),
 	}
 }
 
 func (sc *DiagnosticsCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- sc.slurmUserRpcCount
 	ch <- sc.slurmUserRpcTotalTime
+	ch <- sc.foldedValues
 	ch <- sc.slurmTypeRpcCount
 	ch <- sc.slurmTypeRpcAvgTime
 	ch <- sc.slurmTypeRpcTotalTime
@@ -164,48 +192,44 @@ func (sc *DiagnosticsCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- sc.slurmBackfillLastDepth
 	ch <- sc.slurmBackfillLastDepthTrySched
 	ch <- sc.slurmBackfillCycleCounter
-	ch <- sc.diagScrapeError.Desc()
 }
 
 func (sc *DiagnosticsCollector) Collect(ch chan<- prometheus.Metric) {
-	defer func() {
-		ch <- sc.diagScrapeError
-	}()
-	sdiag, err := sc.fetcher.FetchRawBytes()
+	sc.CollectWithContext(context.Background(), ch)
+}
+
+func (sc *DiagnosticsCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	diagMetrics, err := sc.fetcher.FetchMetrics(ctx)
+	ch <- prometheus.MustNewConstMetric(sc.diagScrapeDuration, prometheus.GaugeValue, float64(sc.fetcher.ScrapeDuration().Abs().Milliseconds()))
 	if err != nil {
-		sc.diagScrapeError.Inc()
 		slog.Error(fmt.Sprintf("sdiag fetch error %q", err))
 		return
 	}
-	ch <- prometheus.MustNewConstMetric(sc.diagScrapeDuration, prometheus.GaugeValue, float64(sc.fetcher.Duration().Abs().Milliseconds()))
-	sdiagResponse, err := parseDiagMetrics(sdiag)
-	if err != nil {
-		sc.diagScrapeError.Inc()
-		slog.Error(fmt.Sprintf("diag parse error: %q", err))
-		return
-	}
-	if !sdiagResponse.IsDataParserPlugin() {
-		sc.diagScrapeError.Inc()
-		slog.Error("only the data_parser plugin is supported")
+	if len(diagMetrics) == 0 {
 		return
 	}
+	stats := diagMetrics[0]
 	emitNonZero := func(desc *prometheus.Desc, val float64, label string) {
 		if val > 0 {
 			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, val, label)
 		}
 	}
-	ch <- prometheus.MustNewConstMetric(sc.slurmCtlThreadCount, prometheus.GaugeValue, float64(sdiagResponse.Statistics.ServerThreadCount))
-	ch <- prometheus.MustNewConstMetric(sc.slurmDbdAgentQueueSize, prometheus.GaugeValue, float64(sdiagResponse.Statistics.DBDAgentQueueSize))
-	ch <- prometheus.MustNewConstMetric(sc.slurmBackfillJobCount, prometheus.GaugeValue, float64(sdiagResponse.Statistics.BackfillJobCount))
-	ch <- prometheus.MustNewConstMetric(sc.slurmBackfillCycleCount, prometheus.GaugeValue, float64(sdiagResponse.Statistics.BackfillCycleCountSum))
-	ch <- prometheus.MustNewConstMetric(sc.slurmBackfillLastDepth, prometheus.GaugeValue, float64(sdiagResponse.Statistics.BackfillLastDepth))
-	ch <- prometheus.MustNewConstMetric(sc.slurmBackfillLastDepthTrySched, prometheus.GaugeValue, float64(sdiagResponse.Statistics.BackfillLastDepthTry))
-	ch <- prometheus.MustNewConstMetric(sc.slurmBackfillCycleCounter, prometheus.GaugeValue, float64(sdiagResponse.Statistics.BackfillCycleCounter))
-	for _, userRpcInfo := range sdiagResponse.Statistics.RpcByUser {
+	ch <- prometheus.MustNewConstMetric(sc.slurmCtlThreadCount, prometheus.GaugeValue, float64(stats.ServerThreadCount))
+	ch <- prometheus.MustNewConstMetric(sc.slurmDbdAgentQueueSize, prometheus.GaugeValue, float64(stats.DBDAgentQueueSize))
+	ch <- prometheus.MustNewConstMetric(sc.slurmBackfillJobCount, prometheus.GaugeValue, float64(stats.BackfillJobCount))
+	ch <- prometheus.MustNewConstMetric(sc.slurmBackfillCycleCount, prometheus.GaugeValue, float64(stats.BackfillCycleCountSum))
+	ch <- prometheus.MustNewConstMetric(sc.slurmBackfillLastDepth, prometheus.GaugeValue, float64(stats.BackfillLastDepth))
+	ch <- prometheus.MustNewConstMetric(sc.slurmBackfillLastDepthTrySched, prometheus.GaugeValue, float64(stats.BackfillLastDepthTry))
+	ch <- prometheus.MustNewConstMetric(sc.slurmBackfillCycleCounter, prometheus.GaugeValue, float64(stats.BackfillCycleCounter))
+	userRpcs, folded := sc.labelLimits.foldUserRpcs(stats.RpcByUser)
+	if sc.labelLimits.maxUsers > 0 {
+		ch <- prometheus.MustNewConstMetric(sc.foldedValues, prometheus.GaugeValue, folded, "user")
+	}
+	for _, userRpcInfo := range userRpcs {
 		emitNonZero(sc.slurmUserRpcCount, float64(userRpcInfo.Count), userRpcInfo.User)
 		emitNonZero(sc.slurmUserRpcTotalTime, float64(userRpcInfo.TotalTime), userRpcInfo.User)
 	}
-	for _, typeRpcInfo := range sdiagResponse.Statistics.RpcByMessageType {
+	for _, typeRpcInfo := range stats.RpcByMessageType {
 		emitNonZero(sc.slurmTypeRpcAvgTime, float64(typeRpcInfo.AvgTime), typeRpcInfo.MessageType)
 		emitNonZero(sc.slurmTypeRpcCount, float64(typeRpcInfo.Count), typeRpcInfo.MessageType)
 		emitNonZero(sc.slurmTypeRpcTotalTime, float64(typeRpcInfo.TotalTime), typeRpcInfo.MessageType)
@@ -5,6 +5,7 @@
 package exporter
 
 import (
+	"context"
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -14,7 +15,7 @@ import (
 func TestParseDiagJson(t *testing.T) {
 	assert := assert.New(t)
 	fetcher := MockScraper{fixture: "fixtures/sdiag.json"}
-	sdiag, err := fetcher.FetchRawBytes()
+	sdiag, err := fetcher.FetchRawBytes(context.Background())
 	assert.NoError(err)
 	resp, err := parseDiagMetrics(sdiag)
 	assert.NoError(err)
@@ -26,7 +27,11 @@ func TestDiagCollect(t *testing.T) {
 	config, err := NewConfig(new(CliFlags))
 	assert.NoError(err)
 	dc := NewDiagsCollector(config)
-	dc.fetcher = &MockScraper{fixture: "fixtures/sdiag.json"}
+	dc.fetcher = &DiagJsonFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/sdiag.json"},
+		cache:        NewAtomicThrottledCache[DiagMetric](1),
+		errorCounter: newMockErrorCounter(),
+	}
 	metricChan := make(chan prometheus.Metric)
 	go func() {
 		dc.Collect(metricChan)
@@ -45,7 +50,11 @@ func TestDiagCollect_2405(t *testing.T) {
 	config, err := NewConfig(new(CliFlags))
 	assert.NoError(err)
 	dc := NewDiagsCollector(config)
-	dc.fetcher = &MockScraper{fixture: "fixtures/sdiag_2405.json"}
+	dc.fetcher = &DiagJsonFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/sdiag_2405.json"},
+		cache:        NewAtomicThrottledCache[DiagMetric](1),
+		errorCounter: newMockErrorCounter(),
+	}
 	metricChan := make(chan prometheus.Metric)
 	go func() {
 		dc.Collect(metricChan)
@@ -65,7 +74,11 @@ func TestDiagDescribe(t *testing.T) {
 	config, err := NewConfig(new(CliFlags))
 	assert.Nil(err)
 	dc := NewDiagsCollector(config)
-	dc.fetcher = &MockScraper{fixture: "fixtures/sdiag.json"}
+	dc.fetcher = &DiagJsonFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/sdiag.json"},
+		cache:        NewAtomicThrottledCache[DiagMetric](1),
+		errorCounter: newMockErrorCounter(),
+	}
 	go func() {
 		dc.Describe(ch)
 		close(ch)
@@ -80,7 +93,7 @@ func TestDiagDescribe(t *testing.T) {
 func TestDataParserVersionDiscovery_Slurm23(t *testing.T) {
 	assert := assert.New(t)
 	fetcher := MockScraper{fixture: "fixtures/sdiag.json"}
-	sdiag, err := fetcher.FetchRawBytes()
+	sdiag, err := fetcher.FetchRawBytes(context.Background())
 	assert.NoError(err)
 	resp, err := parseDiagMetrics(sdiag)
 	assert.NoError(err)
@@ -90,9 +103,22 @@ func TestDataParserVersionDiscovery_Slurm23(t *testing.T) {
 func TestDataParserVersionDiscovery_Slurm24(t *testing.T) {
 	assert := assert.New(t)
 	fetcher := MockScraper{fixture: "fixtures/sdiag_2405.json"}
-	sdiag, err := fetcher.FetchRawBytes()
+	sdiag, err := fetcher.FetchRawBytes(context.Background())
 	assert.NoError(err)
 	resp, err := parseDiagMetrics(sdiag)
 	assert.NoError(err)
 	assert.Truef(resp.IsDataParserPlugin(), "parsed metadata struct %+v", resp.Meta)
 }
+
+func TestDiagFetcher_NotDataParser(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &DiagJsonFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/squeue_out.json"},
+		cache:        NewAtomicThrottledCache[DiagMetric](1),
+		errorCounter: newMockErrorCounter(),
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.Error(err)
+	assert.Nil(metrics)
+	assert.Equal(1., CollectCounterValue(fetcher.errorCounter.WithLabelValues(reasonParse)))
+}
@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reasons a slurm command can fail. Exported as the reason label of slurm_exporter_command_errors_total
+const (
+	// cmd was killed by the scraper timeout or the scrape was cancelled
+	reasonTimeout string = "timeout"
+	// cmd exited non-zero or failed to start
+	reasonExit string = "exit"
+	// cmd exited cleanly but wrote to stderr
+	reasonStderr string = "stderr"
+	// cmd output couldn't be parsed into metrics
+	reasonParse string = "parse"
+)
+
+// typed error returned by scrapers and fetchers so failures can be classified
+type CommandError struct {
+	Command string
+	Reason  string
+	Err     error
+}
+
+func (ce *CommandError) Error() string {
+	return fmt.Sprintf("%s %s error: %s", ce.Command, ce.Reason, ce.Err)
+}
+
+func (ce *CommandError) Unwrap() error {
+	return ce.Err
+}
+
+// classify err into one of the command error reasons. Unclassified errors are treated as a failed exit
+func errorReason(err error) string {
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Reason
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return reasonTimeout
+	}
+	return reasonExit
+}
+
+func NewCommandErrorCounter(constLabels prometheus.Labels) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "slurm_exporter_command_errors_total",
+		Help:        "slurm command failures by command and reason i.e timeout, exit, stderr, parse",
+		ConstLabels: constLabels,
+	}, []string{"command", "reason"})
+}
+
+// record err against counter, which must be curried with the command label
+func observeCommandError(counter *prometheus.CounterVec, err error) {
+	counter.WithLabelValues(errorReason(err)).Inc()
+}
+
+// error counter for a single command. All fetchers of a config share the same underlying vec
+func (c *Config) commandErrorCounter(command string) *prometheus.CounterVec {
+	if c.commandErrors == nil {
+		c.commandErrors = NewCommandErrorCounter(c.constLabels())
+	}
+	return c.commandErrors.MustCurryWith(prometheus.Labels{"command": command})
+}
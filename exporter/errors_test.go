@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorReason(t *testing.T) {
+	assert := assert.New(t)
+	cmdErr := &CommandError{Command: "squeue", Reason: reasonStderr, Err: errors.New("noise")}
+	assert.Equal(reasonStderr, errorReason(cmdErr))
+	assert.Equal(reasonStderr, errorReason(fmt.Errorf("wrapped: %w", cmdErr)))
+	assert.Equal(reasonTimeout, errorReason(context.DeadlineExceeded))
+	assert.Equal(reasonExit, errorReason(errors.New("mock fetch error")))
+	assert.EqualError(cmdErr, "squeue stderr error: noise")
+}
+
+func TestObserveCommandError(t *testing.T) {
+	assert := assert.New(t)
+	config := new(Config)
+	counter := config.commandErrorCounter("squeue")
+	observeCommandError(counter, &CommandError{Command: "squeue", Reason: reasonTimeout, Err: context.DeadlineExceeded})
+	observeCommandError(counter, errors.New("mock fetch error"))
+	assert.Equal(1., CollectCounterValue(config.commandErrors.WithLabelValues("squeue", reasonTimeout)))
+	assert.Equal(1., CollectCounterValue(config.commandErrors.WithLabelValues("squeue", reasonExit)))
+	// fetchers of the same config share a single vec
+	config.commandErrorCounter("sinfo").WithLabelValues(reasonParse).Inc()
+	assert.Equal(1., CollectCounterValue(config.commandErrors.WithLabelValues("sinfo", reasonParse)))
+}
+
+func TestJobJsonFetcher_CommandErrors(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: newMockErrorCounter(),
+	}
+	_, err := fetcher.fetch(context.Background())
+	assert.Error(err)
+	assert.Equal(1., CollectCounterValue(fetcher.errCounter.WithLabelValues(reasonParse)))
+	fetcher.scraper = new(MockFetchErrored)
+	_, err = fetcher.fetch(context.Background())
+	assert.Error(err)
+	assert.Equal(1., CollectCounterValue(fetcher.errCounter.WithLabelValues(reasonExit)))
+}
@@ -0,0 +1,256 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sshare columns we export, as named in the parsable header
+var fairshareColumns = []string{"RawShares", "NormShares", "RawUsage", "EffectvUsage", "FairShare", "LevelFS"}
+
+// a single sshare association. User is empty for account associations and Partition for associations not tied to a partition
+// values sshare leaves empty are NaN and aren't exported
+type FairshareMetric struct {
+	Account      string
+	User         string
+	Partition    string
+	RawShares    float64
+	NormShares   float64
+	RawUsage     float64
+	EffectvUsage float64
+	FairShare    float64
+	LevelFS      float64
+}
+
+type FairshareCsvFetcher struct {
+	scraper      SlurmByteScraper
+	errorCounter *prometheus.CounterVec
+	cache        *AtomicThrottledCache[FairshareMetric]
+}
+
+// parse `sshare -a -P` output. Columns are looked up by header so overrides can reorder or add columns
+func (fcf *FairshareCsvFetcher) fetchFromCli(ctx context.Context) ([]FairshareMetric, error) {
+	cliCsv, err := fcf.scraper.FetchRawBytes(ctx)
+	if err != nil {
+		observeCommandError(fcf.errorCounter, err)
+		slog.Error(fmt.Sprintf("failed to scrape fairshare metrics with %q", err))
+		return nil, err
+	}
+	reader := csv.NewReader(bytes.NewBuffer(cliCsv))
+	reader.Comma = '|'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	columns := make(map[string]int)
+	fairshareMetrics := make([]FairshareMetric, 0)
+	// an override without the Partition column lists per partition associations of a user more than once
+	seen := make(map[[3]string]struct{})
+	for records, err := reader.Read(); err != io.EOF; records, err = reader.Read() {
+		if err != nil {
+			fcf.errorCounter.WithLabelValues(reasonParse).Inc()
+			slog.Error(fmt.Sprintf("failed to scrape fairshare row %v with err: %q", records, err))
+			continue
+		}
+		if len(records) == 1 && isClusterHeader([]byte(records[0])) {
+			continue
+		}
+		if len(columns) == 0 {
+			if !slices.Contains(records, "Account") {
+				fcf.errorCounter.WithLabelValues(reasonParse).Inc()
+				slog.Error(fmt.Sprintf("sshare output has no header, failed to scrape fairshare row %v", records))
+				continue
+			}
+			for i, column := range records {
+				columns[column] = i
+			}
+			continue
+		}
+		if len(records) != len(columns) {
+			fcf.errorCounter.WithLabelValues(reasonParse).Inc()
+			slog.Error(fmt.Sprintf("failed to scrape fairshare row %v", records))
+			continue
+		}
+		field := func(column string) string {
+			if i, ok := columns[column]; ok {
+				// sshare indents accounts by their depth in the association tree
+				return strings.TrimSpace(records[i])
+			}
+			return ""
+		}
+		values := make(map[string]float64, len(fairshareColumns))
+		for _, column := range fairshareColumns {
+			values[column] = math.NaN()
+			// users sharing their parent's shares report `parent` instead of a number
+			value := field(column)
+			if value == "" || value == "parent" {
+				continue
+			}
+			if val, err := strconv.ParseFloat(value, 64); err != nil {
+				slog.Error(fmt.Sprintf("failed to scrape fairshare %s string %s", column, value))
+				fcf.errorCounter.WithLabelValues(reasonParse).Inc()
+			} else {
+				values[column] = val
+			}
+		}
+		key := [3]string{field("Account"), field("User"), field("Partition")}
+		if _, ok := seen[key]; ok {
+			slog.Warn(fmt.Sprintf("dropping duplicate fairshare association %v, add Partition to the sshare format", key))
+			continue
+		}
+		seen[key] = struct{}{}
+		fairshareMetrics = append(fairshareMetrics, FairshareMetric{
+			Account:      key[0],
+			User:         key[1],
+			Partition:    key[2],
+			RawShares:    values["RawShares"],
+			NormShares:   values["NormShares"],
+			RawUsage:     values["RawUsage"],
+			EffectvUsage: values["EffectvUsage"],
+			FairShare:    values["FairShare"],
+			LevelFS:      values["LevelFS"],
+		})
+	}
+	return fairshareMetrics, nil
+}
+
+func (fcf *FairshareCsvFetcher) FetchMetrics(ctx context.Context) ([]FairshareMetric, error) {
+	return fcf.cache.FetchOrThrottle(func() ([]FairshareMetric, error) { return fcf.fetchFromCli(ctx) })
+}
+
+func (fcf *FairshareCsvFetcher) ScrapeDuration() time.Duration {
+	return fcf.scraper.Duration()
+}
+
+type FairshareCollector struct {
+	fetcher                 SlurmMetricFetcher[FairshareMetric]
+	fairshareRawShares      *prometheus.Desc
+	fairshareNormShares     *prometheus.Desc
+	fairshareRawUsage       *prometheus.Desc
+	fairshareEffectiveUsage *prometheus.Desc
+	fairshareFactor         *prometheus.Desc
+	fairshareLevelFS        *prometheus.Desc
+	fairshareScrapeDuration *prometheus.Desc
+	labelLimits             LabelLimiter
+	foldedValues            *prometheus.Desc
+}
+
+func NewFairshareCollector(config *Config) *FairshareCollector {
+	cliOpts := config.cliOpts
+	labels := []string{"account", "user", "partition"}
+	return &FairshareCollector{
+		fetcher: &FairshareCsvFetcher{
+			scraper:      cliOpts.newCliScraper(cliOpts.sshare),
+			cache:        newConfiguredCache[FairshareMetric](config, "fairshare"),
+			errorCounter: config.commandErrorCounter("sshare"),
+		},
+		fairshareRawShares:      prometheus.NewDesc("slurm_fairshare_raw_shares", "shares assigned to the association", labels, config.constLabels()),
+		fairshareNormShares:     prometheus.NewDesc("slurm_fairshare_norm_shares", "shares assigned to the association normalized against its siblings", labels, config.constLabels()),
+		fairshareRawUsage:       prometheus.NewDesc("slurm_fairshare_raw_usage", "decayed usage of the association in cpu seconds", labels, config.constLabels()),
+		fairshareEffectiveUsage: prometheus.NewDesc("slurm_fairshare_effective_usage", "usage of the association normalized against its siblings", labels, config.constLabels()),
+		fairshareFactor:         prometheus.NewDesc("slurm_fairshare_factor", "fairshare factor of the association", labels, config.constLabels()),
+		fairshareLevelFS:        prometheus.NewDesc("slurm_fairshare_level_fs", "fairshare of the association relative to its siblings, LevelFS", labels, config.constLabels()),
+		fairshareScrapeDuration: prometheus.NewDesc("slurm_fairshare_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.sshare), nil, config.constLabels()),
+		labelLimits:             cliOpts.labelLimits,
+		foldedValues:            newFoldedValuesDesc(config, "fairshare"),
+	}
+}
+
+func (fc *FairshareCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- fc.fairshareRawShares
+	ch <- fc.fairshareNormShares
+	ch <- fc.fairshareRawUsage
+	ch <- fc.fairshareEffectiveUsage
+	ch <- fc.fairshareFactor
+	ch <- fc.fairshareLevelFS
+	ch <- fc.fairshareScrapeDuration
+	ch <- fc.foldedValues
+}
+
+// fairshare values can't be added up into other, so associations of users and accounts past the limits are dropped.
+// Users and accounts are ranked by raw usage. Also returns how many users and accounts were dropped
+func (fc *FairshareCollector) limitAssociations(associations []FairshareMetric) ([]FairshareMetric, map[string]float64) {
+	userUsage := make(map[string]float64)
+	accountUsage := make(map[string]float64)
+	for _, association := range associations {
+		usage := association.RawUsage
+		if math.IsNaN(usage) {
+			usage = 0
+		}
+		if association.User == "" {
+			accountUsage[association.Account] += usage
+			continue
+		}
+		userUsage[association.User] += usage
+	}
+	users := topLabelValues(userUsage, fc.labelLimits.maxUsers)
+	accounts := topLabelValues(accountUsage, fc.labelLimits.maxAccounts)
+	dropped := map[string]float64{"user": 0, "account": 0}
+	if users == nil && accounts == nil {
+		return associations, dropped
+	}
+	if users != nil {
+		dropped["user"] = float64(len(userUsage) - len(users))
+	}
+	if accounts != nil {
+		dropped["account"] = float64(len(accountUsage) - len(accounts))
+	}
+	kept := make([]FairshareMetric, 0, len(associations))
+	for _, association := range associations {
+		if !keepsLabel(accounts, association.Account) {
+			continue
+		}
+		if association.User != "" && !keepsLabel(users, association.User) {
+			continue
+		}
+		kept = append(kept, association)
+	}
+	return kept, dropped
+}
+
+func (fc *FairshareCollector) Collect(ch chan<- prometheus.Metric) {
+	fc.CollectWithContext(context.Background(), ch)
+}
+
+func (fc *FairshareCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	fairshareMetrics, err := fc.fetcher.FetchMetrics(ctx)
+	ch <- prometheus.MustNewConstMetric(fc.fairshareScrapeDuration, prometheus.GaugeValue, float64(fc.fetcher.ScrapeDuration().Milliseconds()))
+	if err != nil {
+		slog.Error(fmt.Sprintf("fairshare fetch error %q", err))
+		return
+	}
+	emitSetVal := func(desc *prometheus.Desc, val float64, metric *FairshareMetric) {
+		if !math.IsNaN(val) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, val, metric.Account, metric.User, metric.Partition)
+		}
+	}
+	fairshareMetrics, dropped := fc.limitAssociations(fairshareMetrics)
+	if fc.labelLimits.enabled() {
+		for label, count := range dropped {
+			ch <- prometheus.MustNewConstMetric(fc.foldedValues, prometheus.GaugeValue, count, label)
+		}
+	}
+	for i := range fairshareMetrics {
+		metric := &fairshareMetrics[i]
+		emitSetVal(fc.fairshareRawShares, metric.RawShares, metric)
+		emitSetVal(fc.fairshareNormShares, metric.NormShares, metric)
+		emitSetVal(fc.fairshareRawUsage, metric.RawUsage, metric)
+		emitSetVal(fc.fairshareEffectiveUsage, metric.EffectvUsage, metric)
+		emitSetVal(fc.fairshareFactor, metric.FairShare, metric)
+		emitSetVal(fc.fairshareLevelFS, metric.LevelFS, metric)
+	}
+}
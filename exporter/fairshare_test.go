@@ -0,0 +1,178 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+var MockSshareScraper = &MockScraper{fixture: "fixtures/sshare.txt"}
+
+func TestFairshareFetch(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := FairshareCsvFetcher{
+		scraper:      MockSshareScraper,
+		errorCounter: newMockErrorCounter(),
+		cache:        NewAtomicThrottledCache[FairshareMetric](10),
+	}
+	fairshareMetrics, err := fetcher.fetchFromCli(context.Background())
+	assert.NoError(err)
+	assert.Len(fairshareMetrics, 8)
+	associations := make(map[string]FairshareMetric)
+	for _, metric := range fairshareMetrics {
+		associations[metric.Account+"/"+metric.User] = metric
+	}
+	account1 := associations["account1/"]
+	assert.Equal(40., account1.RawShares)
+	assert.Equal(.4, account1.NormShares)
+	assert.Equal(1180119., account1.RawUsage)
+	assert.Equal(.75, account1.EffectvUsage)
+	assert.Equal(.333333, account1.FairShare)
+	assert.Equal(.533333, account1.LevelFS)
+	// parent shares and empty values are left unset
+	assert.True(math.IsNaN(associations["account1/user2"].RawShares))
+	assert.Equal(2., associations["account1/user2"].LevelFS)
+	assert.True(math.IsNaN(associations["root/"].LevelFS))
+	assert.True(math.IsInf(associations["root/root"].LevelFS, 1))
+	// only the malformed raw shares of account3 count as an error
+	assert.True(math.IsNaN(associations["account3/"].RawShares))
+	assert.Equal(1., CollectCounterValue(fetcher.errorCounter.WithLabelValues(reasonParse)))
+}
+
+func TestFairshareFetch_Partitions(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := FairshareCsvFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/sshare_partition.txt"},
+		errorCounter: newMockErrorCounter(),
+		cache:        NewAtomicThrottledCache[FairshareMetric](10),
+	}
+	fairshareMetrics, err := fetcher.fetchFromCli(context.Background())
+	assert.NoError(err)
+	assert.Len(fairshareMetrics, 4)
+	assert.Equal("hw", fairshareMetrics[2].Partition)
+	assert.Equal(.666667, fairshareMetrics[2].LevelFS)
+	assert.Equal("magma", fairshareMetrics[3].Partition)
+	assert.Equal(2., fairshareMetrics[3].LevelFS)
+	assert.Zero(CollectCounterValue(fetcher.errorCounter.WithLabelValues(reasonParse)))
+}
+
+func TestFairshareFetch_DuplicateAssociations(t *testing.T) {
+	assert := assert.New(t)
+	// the Partition column was left out of the format
+	fetcher := FairshareCsvFetcher{
+		scraper:      NewCliScraper("printf", `Account|User|LevelFS\naccount1|user1|0.5\naccount1|user1|2\n`),
+		errorCounter: newMockErrorCounter(),
+		cache:        NewAtomicThrottledCache[FairshareMetric](10),
+	}
+	fairshareMetrics, err := fetcher.fetchFromCli(context.Background())
+	assert.NoError(err)
+	assert.Len(fairshareMetrics, 1)
+	assert.Equal(.5, fairshareMetrics[0].LevelFS)
+}
+
+func TestFairshareFetch_NoHeader(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := FairshareCsvFetcher{
+		scraper:      NewCliScraper("echo", "account1||40|0.4|1180119|0.75|0.333333|0.533333"),
+		errorCounter: newMockErrorCounter(),
+		cache:        NewAtomicThrottledCache[FairshareMetric](10),
+	}
+	fairshareMetrics, err := fetcher.fetchFromCli(context.Background())
+	assert.NoError(err)
+	assert.Empty(fairshareMetrics)
+	assert.Equal(1., CollectCounterValue(fetcher.errorCounter.WithLabelValues(reasonParse)))
+}
+
+func TestFairshareCollector(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	fc := NewFairshareCollector(config)
+	fc.fetcher = &FairshareCsvFetcher{
+		scraper:      MockSshareScraper,
+		errorCounter: newMockErrorCounter(),
+		cache:        NewAtomicThrottledCache[FairshareMetric](10),
+	}
+	fcChan := make(chan prometheus.Metric)
+	go func() {
+		fc.Collect(fcChan)
+		close(fcChan)
+	}()
+	fairshareMetrics := make([]prometheus.Metric, 0)
+	for metric, ok := <-fcChan; ok; metric, ok = <-fcChan {
+		t.Log(metric.Desc().String())
+		fairshareMetrics = append(fairshareMetrics, metric)
+	}
+	// 8 associations with 6 values each, minus the 6 unset values, plus the scrape duration
+	assert.Len(fairshareMetrics, 8*6-6+1)
+}
+
+func TestFairshareCollector_Partitions(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	fc := NewFairshareCollector(config)
+	fc.fetcher = &FairshareCsvFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/sshare_partition.txt"},
+		errorCounter: newMockErrorCounter(),
+		cache:        NewAtomicThrottledCache[FairshareMetric](10),
+	}
+	registry := prometheus.NewPedanticRegistry()
+	assert.NoError(registry.Register(fc))
+	families, err := registry.Gather()
+	assert.NoError(err)
+	levelFS := 0
+	for _, family := range families {
+		if family.GetName() == "slurm_fairshare_level_fs" {
+			levelFS = len(family.GetMetric())
+		}
+	}
+	// root has no LevelFS
+	assert.Equal(3, levelFS)
+}
+
+func TestFairshareLimitAssociations(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := FairshareCsvFetcher{
+		scraper:      MockSshareScraper,
+		errorCounter: newMockErrorCounter(),
+		cache:        NewAtomicThrottledCache[FairshareMetric](10),
+	}
+	associations, err := fetcher.fetchFromCli(context.Background())
+	assert.NoError(err)
+	fc := &FairshareCollector{labelLimits: LabelLimiter{maxUsers: 1, maxAccounts: 2}}
+	kept, dropped := fc.limitAssociations(associations)
+	// user1 and the root and account1 accounts have the most usage
+	assert.Equal(map[string]float64{"user": 3, "account": 2}, dropped)
+	names := make([]string, 0)
+	for _, association := range kept {
+		names = append(names, association.Account+"/"+association.User)
+	}
+	assert.Equal([]string{"root/", "account1/", "account1/user1"}, names)
+	kept, _ = (&FairshareCollector{}).limitAssociations(associations)
+	assert.Equal(associations, kept)
+}
+
+func TestFairshareDescribe(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	fc := NewFairshareCollector(config)
+	fcChan := make(chan *prometheus.Desc)
+	go func() {
+		fc.Describe(fcChan)
+		close(fcChan)
+	}()
+	descs := make([]*prometheus.Desc, 0)
+	for desc, ok := <-fcChan; ok; desc, ok = <-fcChan {
+		descs = append(descs, desc)
+	}
+	assert.Len(descs, 8)
+}
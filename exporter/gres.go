@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"strconv"
+	"strings"
+)
+
+// gres counts keyed by name or name:model, i.e gpu or gpu:a100
+type GresCount map[string]float64
+
+// split a gres list on the commas outside of parentheses, i.e gpu:a100:2(IDX:0,2),mps:100
+func splitGresList(gres string) []string {
+	items := make([]string, 0)
+	depth, start := 0, 0
+	for i, c := range gres {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, gres[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, gres[start:])
+}
+
+// parse a single gres item, i.e gres/gpu:a100=2, gres:gpu:a100:2, gpu:4(S:0-1) or gpu
+func parseGresItem(item string) (key string, count float64, ok bool) {
+	item = strings.TrimSpace(item)
+	item = strings.TrimPrefix(strings.TrimPrefix(item, "gres:"), "gres/")
+	if idx := strings.Index(item, "("); idx >= 0 {
+		item = item[:idx]
+	}
+	if item == "" || item == "N/A" {
+		return "", 0, false
+	}
+	if key, countStr, found := strings.Cut(item, "="); found {
+		count, err := strconv.ParseFloat(countStr, 64)
+		return key, count, err == nil
+	}
+	parts := strings.Split(item, ":")
+	if len(parts) > 1 {
+		if count, err := strconv.ParseFloat(parts[len(parts)-1], 64); err == nil {
+			return strings.Join(parts[:len(parts)-1], ":"), count, true
+		}
+	}
+	// a gres without a count is a single unit
+	return item, 1, true
+}
+
+// gres as reported by sinfo %G, node gres/gres_used and squeue %b
+func parseGres(gres string) GresCount {
+	counts := make(GresCount)
+	if gres == "" || gres == "(null)" || gres == "N/A" {
+		return counts
+	}
+	for _, item := range splitGresList(gres) {
+		if key, count, ok := parseGresItem(item); ok {
+			counts[key] += count
+		}
+	}
+	return counts
+}
+
+// gres entries of a tres string, i.e cpu=4,mem=8G,node=1,gres/gpu=2,gres/gpu:a100=2
+// slurm reports typed gres alongside their untyped total, so the total is dropped to avoid double counting
+func parseTresGres(tres string) GresCount {
+	counts := make(GresCount)
+	for _, item := range strings.Split(tres, ",") {
+		if !strings.HasPrefix(item, "gres/") {
+			continue
+		}
+		if key, count, ok := parseGresItem(item); ok {
+			counts[key] += count
+		}
+	}
+	for key := range counts {
+		if name, model := splitGresKey(key); model != "" {
+			delete(counts, name)
+		}
+	}
+	return counts
+}
+
+// i.e gpu:a100 -> gpu, a100
+func splitGresKey(key string) (name string, model string) {
+	name, model, _ = strings.Cut(key, ":")
+	return name, model
+}
+
+func (gc GresCount) scale(factor float64) GresCount {
+	scaled := make(GresCount, len(gc))
+	for key, count := range gc {
+		scaled[key] = count * factor
+	}
+	return scaled
+}
@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGres(t *testing.T) {
+	assert := assert.New(t)
+	for gres, expected := range map[string]GresCount{
+		"gpu:a100:4(S:0-1)":                       {"gpu:a100": 4},
+		"gpu:a100:2(IDX:0,2),gpu:v100:1(IDX:N/A)": {"gpu:a100": 2, "gpu:v100": 1},
+		"gpu:4,mps:200":                           {"gpu": 4, "mps": 200},
+		"gres:gpu:a100:2":                         {"gpu:a100": 2},
+		"gres/gpu:2":                              {"gpu": 2},
+		"gres/gpu:a100=2":                         {"gpu:a100": 2},
+		"gpu":                                     {"gpu": 1},
+		"(null)":                                  {},
+		"N/A":                                     {},
+		"":                                        {},
+	} {
+		assert.Equal(expected, parseGres(gres), gres)
+	}
+}
+
+func TestParseTresGres(t *testing.T) {
+	assert := assert.New(t)
+	// typed gres replace their untyped total
+	assert.Equal(GresCount{"gpu:a100": 2}, parseTresGres("cpu=4,mem=64000M,node=1,billing=4,gres/gpu=2,gres/gpu:a100=2"))
+	assert.Equal(GresCount{"gpu": 2, "shard": 4}, parseTresGres("cpu=4,gres/gpu=2,gres/shard=4"))
+	assert.Empty(parseTresGres("cpu=1,mem=62.50G,node=1,billing=1"))
+	assert.Empty(parseTresGres(""))
+}
+
+func TestSplitGresKey(t *testing.T) {
+	assert := assert.New(t)
+	name, model := splitGresKey("gpu:a100")
+	assert.Equal([]string{"gpu", "a100"}, []string{name, model})
+	name, model = splitGresKey("gpu")
+	assert.Equal([]string{"gpu", ""}, []string{name, model})
+}
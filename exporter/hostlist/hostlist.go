@@ -0,0 +1,177 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package hostlist expands and compresses slurm hostlist expressions i.e cs[100-105,200]
+package hostlist
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// expand a single hostlist entry, recursing on each bracket group i.e rack[1-2]-node[01-02]
+func expandRange(host string) ([]string, error) {
+	open := strings.IndexByte(host, '[')
+	if open < 0 {
+		return []string{host}, nil
+	}
+	closing := strings.IndexByte(host[open:], ']')
+	if closing < 0 {
+		return nil, fmt.Errorf("unterminated range in %q", host)
+	}
+	closing += open
+	prefix, ranges := host[:open], host[open+1:closing]
+	suffixes, err := expandRange(host[closing+1:])
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]string, 0)
+	for _, r := range strings.Split(ranges, ",") {
+		lo, hi, found := strings.Cut(r, "-")
+		if !found {
+			hi = lo
+		}
+		start, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q in %q", r, host)
+		}
+		end, err := strconv.Atoi(hi)
+		if err != nil || end < start {
+			return nil, fmt.Errorf("invalid range %q in %q", r, host)
+		}
+		// zero padding is taken from the lower bound, i.e node[01-10]
+		for n := start; n <= end; n++ {
+			for _, suffix := range suffixes {
+				hosts = append(hosts, fmt.Sprintf("%s%0*d%s", prefix, len(lo), n, suffix))
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// Expand a slurm hostlist i.e cs[25,31-32],gpu01 into cs25,cs31,cs32,gpu01
+func Expand(hostlist string) ([]string, error) {
+	hosts := make([]string, 0)
+	depth, start := 0, 0
+	for i := 0; i <= len(hostlist); i++ {
+		if i < len(hostlist) {
+			switch hostlist[i] {
+			case '[':
+				depth++
+				continue
+			case ']':
+				depth--
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		if depth != 0 {
+			return nil, fmt.Errorf("unbalanced brackets in hostlist %q", hostlist)
+		}
+		if entry := hostlist[start:i]; entry != "" {
+			expanded, err := expandRange(entry)
+			if err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, expanded...)
+		}
+		start = i + 1
+	}
+	return hosts, nil
+}
+
+// hosts sharing a prefix and a zero padded width. Width is 0 for unpadded numbers
+type rangeKey struct {
+	prefix string
+	width  int
+}
+
+// split the trailing number off a hostname i.e cs025 -> cs, 025
+func splitHost(host string) (string, string) {
+	i := len(host)
+	for i > 0 && host[i-1] >= '0' && host[i-1] <= '9' {
+		i--
+	}
+	return host[:i], host[i:]
+}
+
+// Compress hostnames into a slurm hostlist, the inverse of Expand i.e cs25,cs26,cs27,gpu01 into cs[25-27],gpu01
+// only the trailing number of each hostname is folded into a range. Duplicates are dropped and ranges are sorted
+func Compress(hosts []string) string {
+	literals := make([]string, 0)
+	padded := make(map[string][]int)
+	numbers := make(map[rangeKey][]int)
+	unpadded := make(map[string][]string)
+	for _, host := range hosts {
+		prefix, digits := splitHost(host)
+		// hostnames without a number, or with one too long for an int, are kept as is
+		if digits == "" || len(digits) > 18 {
+			literals = append(literals, host)
+			continue
+		}
+		if len(digits) > 1 && digits[0] == '0' {
+			key := rangeKey{prefix: prefix, width: len(digits)}
+			n, _ := strconv.Atoi(digits)
+			numbers[key] = append(numbers[key], n)
+			padded[prefix] = append(padded[prefix], len(digits))
+			continue
+		}
+		unpadded[prefix] = append(unpadded[prefix], digits)
+	}
+	// node10 belongs with node01-node09 when they're padded to the same width
+	for prefix, digitList := range unpadded {
+		for _, digits := range digitList {
+			key := rangeKey{prefix: prefix}
+			if slices.Contains(padded[prefix], len(digits)) {
+				key.width = len(digits)
+			}
+			n, _ := strconv.Atoi(digits)
+			numbers[key] = append(numbers[key], n)
+		}
+	}
+	keys := make([]rangeKey, 0, len(numbers))
+	for key := range numbers {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b rangeKey) int {
+		if c := cmp.Compare(a.prefix, b.prefix); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.width, b.width)
+	})
+	entries := make([]string, 0, len(keys)+len(literals))
+	for _, key := range keys {
+		ns := numbers[key]
+		slices.Sort(ns)
+		ns = slices.Compact(ns)
+		ranges := make([]string, 0)
+		for i := 0; i < len(ns); {
+			j := i
+			for j+1 < len(ns) && ns[j+1] == ns[j]+1 {
+				j++
+			}
+			r := fmt.Sprintf("%0*d", key.width, ns[i])
+			if j > i {
+				r += fmt.Sprintf("-%0*d", key.width, ns[j])
+			}
+			ranges = append(ranges, r)
+			i = j + 1
+		}
+		if len(ns) == 1 {
+			entries = append(entries, key.prefix+ranges[0])
+			continue
+		}
+		entries = append(entries, fmt.Sprintf("%s[%s]", key.prefix, strings.Join(ranges, ",")))
+	}
+	slices.Sort(literals)
+	return strings.Join(append(entries, slices.Compact(literals)...), ",")
+}
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hostlist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpand(t *testing.T) {
+	assert := assert.New(t)
+	hosts, err := Expand("cs[25,31-32],gpu01")
+	assert.NoError(err)
+	assert.Equal([]string{"cs25", "cs31", "cs32", "gpu01"}, hosts)
+	hosts, err = Expand("node[08-10]")
+	assert.NoError(err)
+	assert.Equal([]string{"node08", "node09", "node10"}, hosts)
+	hosts, err = Expand("rack[1-2]-n[1,3]")
+	assert.NoError(err)
+	assert.Equal([]string{"rack1-n1", "rack1-n3", "rack2-n1", "rack2-n3"}, hosts)
+	hosts, err = Expand("")
+	assert.NoError(err)
+	assert.Empty(hosts)
+	for _, hostlist := range []string{"cs[25", "cs25]", "cs[a-b]", "cs[3-1]"} {
+		_, err = Expand(hostlist)
+		assert.Error(err, hostlist)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("cs[100-105,200]", Compress([]string{"cs200", "cs101", "cs100", "cs102", "cs103", "cs104", "cs105", "cs101"}))
+	assert.Equal("cs[9-10],gpu01,login", Compress([]string{"login", "gpu01", "cs10", "cs9"}))
+	// node10 is padded like node08
+	assert.Equal("node[08-10]", Compress([]string{"node08", "node09", "node10"}))
+	assert.Equal("", Compress(nil))
+}
+
+func TestCompress_RoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	for _, hostlist := range []string{"cs[100-105,200]", "node[001-010,099]", "cs7", "a[1-3],b[01-02]"} {
+		hosts, err := Expand(hostlist)
+		assert.NoError(err)
+		assert.Equal(hostlist, Compress(hosts))
+	}
+}
@@ -6,13 +6,18 @@ package exporter
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rivosinc/prometheus-slurm-exporter/exporter/hostlist"
 	"log/slog"
 )
 
@@ -20,7 +25,8 @@ import (
 const reqNodeNotAvailReason string = "ReqNodeNotAvail, UnavailableNodes"
 
 type NodeResource struct {
-	Mem float64 `json:"memory"`
+	Mem  float64 `json:"memory"`
+	Cpus float64 `json:"cpus"`
 }
 
 type JobResource struct {
@@ -28,79 +34,273 @@ type JobResource struct {
 	AllocNodes map[string]*NodeResource `json:"allocated_nodes"`
 }
 type JobMetric struct {
-	Account      string      `json:"account"`
-	JobId        float64     `json:"job_id"`
-	EndTime      float64     `json:"end_time"`
-	JobState     string      `json:"job_state"`
-	Partition    string      `json:"partition"`
-	UserName     string      `json:"user_name"`
-	Features     string      `json:"features"`
-	JobResources JobResource `json:"job_resources"`
-	StateReason  string      `json:"state_reason"`
+	Account      string        `json:"account"`
+	JobId        float64       `json:"job_id"`
+	EndTime      float64       `json:"end_time"`
+	SubmitTime   float64       `json:"submit_time"`
+	StartTime    OptionalFloat `json:"start_time"`
+	TimeLimit    OptionalFloat `json:"time_limit"` // minutes, 0 when unlimited
+	JobState     string        `json:"job_state"`
+	Partition    string        `json:"partition"`
+	Qos          string        `json:"qos"`
+	UserName     string        `json:"user_name"`
+	Features     string        `json:"features"`
+	JobResources JobResource   `json:"job_resources"`
+	StateReason  string        `json:"state_reason"`
+	TresAlloc    string        `json:"tres_alloc_str"` // i.e cpu=4,mem=8G,node=1,gres/gpu=2
+	Gres         GresCount     `json:"-"`              // parsed by the fetchers
+	ArrayJobId   OptionalFloat `json:"array_job_id"`   // 0 outside of job arrays
+	ArrayTaskId  OptionalFloat `json:"array_task_id"`
+	// tasks folded into a pending array record, i.e 1-9999%10. Empty once a task has its own record
+	ArrayTaskString string `json:"array_task_string"`
+	// allocated nodes as a hostlist, i.e cs[10-11]. Empty until the job starts
+	Nodes string `json:"nodes"`
+	// cpus and mem allocated on each node, by hostname. Parsed by the fetchers, nil when squeue only reports job totals
+	NodeAllocs map[string]NodeResource `json:"-"`
 }
 
+// openapi/v0.0.37 schema
 type squeueResponse struct {
-	Meta struct {
-		SlurmVersion struct {
-			Version struct {
-				Major int `json:"major"`
-				Micro int `json:"micro"`
-				Minor int `json:"minor"`
-			} `json:"version"`
-			Release string `json:"release"`
-		} `json:"Slurm"`
-	} `json:"meta"`
+	Meta   slurmMeta   `json:"meta"`
 	Errors []string    `json:"errors"`
 	Jobs   []JobMetric `json:"jobs"`
 }
 
+// data_parser/v0.0.40+ schema (slurm 23.11+)
+// job_resources changes shape between versions so resources are read from the stable top level fields
+type dataParserJob struct {
+	Account       string                 `json:"account"`
+	JobId         float64                `json:"job_id"`
+	EndTime       OptionalFloat          `json:"end_time"`
+	SubmitTime    OptionalFloat          `json:"submit_time"`
+	StartTime     OptionalFloat          `json:"start_time"`
+	TimeLimit     OptionalFloat          `json:"time_limit"`
+	TresAlloc     string                 `json:"tres_alloc_str"`
+	ArrayJobId    OptionalFloat          `json:"array_job_id"`
+	ArrayTaskId   OptionalFloat          `json:"array_task_id"`
+	ArrayTasks    string                 `json:"array_task_string"`
+	Nodes         string                 `json:"nodes"`
+	JobState      []string               `json:"job_state"`
+	Partition     string                 `json:"partition"`
+	Qos           string                 `json:"qos"`
+	UserName      string                 `json:"user_name"`
+	Features      string                 `json:"features"`
+	StateReason   string                 `json:"state_reason"`
+	Cpus          OptionalFloat          `json:"cpus"`
+	NodeCount     OptionalFloat          `json:"node_count"`
+	MemoryPerNode OptionalFloat          `json:"memory_per_node"`
+	MemoryPerCpu  OptionalFloat          `json:"memory_per_cpu"`
+	Resources     dataParserJobResources `json:"job_resources"`
+}
+
+// only the per node allocations are read from job_resources
+type dataParserJobResources struct {
+	nodeAllocs map[string]NodeResource
+}
+
+// v0.0.40 lists allocated_nodes, v0.0.41+ moved them to nodes.allocation. Other shapes are ignored
+// and leave the job without per node allocations rather than failing the whole squeue parse
+func (dpjr *dataParserJobResources) UnmarshalJSON(data []byte) error {
+	var resources struct {
+		AllocatedNodes []struct {
+			Name   string        `json:"nodename"`
+			Cpus   OptionalFloat `json:"cpus"`
+			Memory OptionalFloat `json:"memory"`
+		} `json:"allocated_nodes"`
+		Nodes json.RawMessage `json:"nodes"`
+	}
+	if err := json.Unmarshal(data, &resources); err != nil {
+		return nil
+	}
+	nodeAllocs := make(map[string]NodeResource)
+	for _, node := range resources.AllocatedNodes {
+		nodeAllocs[node.Name] = NodeResource{Cpus: float64(node.Cpus), Mem: float64(node.Memory) * 1e6}
+	}
+	var nodes struct {
+		Allocation []struct {
+			Name string `json:"name"`
+			Cpus struct {
+				Count OptionalFloat `json:"count"`
+			} `json:"cpus"`
+			Memory struct {
+				Allocated OptionalFloat `json:"allocated"`
+			} `json:"memory"`
+		} `json:"allocation"`
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(resources.Nodes), []byte("{")) && json.Unmarshal(resources.Nodes, &nodes) == nil {
+		for _, node := range nodes.Allocation {
+			nodeAllocs[node.Name] = NodeResource{Cpus: float64(node.Cpus.Count), Mem: float64(node.Memory.Allocated) * 1e6}
+		}
+	}
+	if len(nodeAllocs) > 0 {
+		dpjr.nodeAllocs = nodeAllocs
+	}
+	return nil
+}
+
+type dataParserSqueueResponse struct {
+	Meta   slurmMeta         `json:"meta"`
+	Errors []dataParserError `json:"errors"`
+	Jobs   []dataParserJob   `json:"jobs"`
+}
+
+// memory is requested either per node or per cpu, in MB
+func (dpj *dataParserJob) allocMemory() float64 {
+	if dpj.MemoryPerNode > 0 {
+		return float64(dpj.MemoryPerNode*dpj.NodeCount) * 1e6
+	}
+	return float64(dpj.MemoryPerCpu*dpj.Cpus) * 1e6
+}
+
+func (dpj *dataParserJob) jobMetric() JobMetric {
+	// the first state is the base state, any following are flags i.e ["RUNNING", "COMPLETING"]
+	state := ""
+	if len(dpj.JobState) > 0 {
+		state = dpj.JobState[0]
+	}
+	return JobMetric{
+		Account:         dpj.Account,
+		JobId:           dpj.JobId,
+		EndTime:         float64(dpj.EndTime),
+		SubmitTime:      float64(dpj.SubmitTime),
+		StartTime:       dpj.StartTime,
+		TimeLimit:       dpj.TimeLimit,
+		TresAlloc:       dpj.TresAlloc,
+		Gres:            parseTresGres(dpj.TresAlloc),
+		ArrayJobId:      dpj.ArrayJobId,
+		ArrayTaskId:     dpj.ArrayTaskId,
+		ArrayTaskString: dpj.ArrayTasks,
+		Nodes:           dpj.Nodes,
+		NodeAllocs:      dpj.Resources.nodeAllocs,
+		JobState:        state,
+		Partition:       dpj.Partition,
+		Qos:             dpj.Qos,
+		UserName:        dpj.UserName,
+		Features:        dpj.Features,
+		StateReason:     dpj.StateReason,
+		JobResources: JobResource{
+			AllocCpus:  float64(dpj.Cpus),
+			AllocNodes: map[string]*NodeResource{"0": {Mem: dpj.allocMemory()}},
+		},
+	}
+}
+
+// decode squeue json with the schema reported by its meta block
+func parseJobMetrics(data []byte) ([]JobMetric, error) {
+	meta, err := parseSlurmMeta(data)
+	if err != nil {
+		return nil, err
+	}
+	if !meta.isDataParser() {
+		var squeue squeueResponse
+		if err := json.Unmarshal(data, &squeue); err != nil {
+			return nil, err
+		}
+		for i, j := range squeue.Jobs {
+			for _, resource := range j.JobResources.AllocNodes {
+				resource.Mem *= 1e9
+			}
+			squeue.Jobs[i].Gres = parseTresGres(j.TresAlloc)
+			squeue.Jobs[i].NodeAllocs = indexedNodeAllocs(&squeue.Jobs[i])
+		}
+		return squeue.Jobs, nil
+	}
+	var squeue dataParserSqueueResponse
+	if err := json.Unmarshal(data, &squeue); err != nil {
+		return nil, err
+	}
+	for _, e := range squeue.Errors {
+		slog.Error(fmt.Sprintf("squeue error response %q", e))
+	}
+	jobMetrics := make([]JobMetric, 0, len(squeue.Jobs))
+	for i := range squeue.Jobs {
+		jobMetrics = append(jobMetrics, squeue.Jobs[i].jobMetric())
+	}
+	return jobMetrics, nil
+}
+
+// openapi/v0.0.37 keys allocated_nodes by the index of the node in the job's node list
+func indexedNodeAllocs(job *JobMetric) map[string]NodeResource {
+	if job.Nodes == "" || len(job.JobResources.AllocNodes) == 0 {
+		return nil
+	}
+	nodes, err := hostlist.Expand(job.Nodes)
+	if err != nil {
+		return nil
+	}
+	nodeAllocs := make(map[string]NodeResource)
+	for key, resource := range job.JobResources.AllocNodes {
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= len(nodes) {
+			return nil
+		}
+		nodeAllocs[nodes[i]] = *resource
+	}
+	return nodeAllocs
+}
+
+// calls the trackers diffing consecutive job lists once per successful fetch, so every fetch is diffed,
+// including background polls between scrapes, and cached results never are
+type jobFetchObserver struct {
+	observe func([]JobMetric)
+}
+
+func (jfo *jobFetchObserver) setObserver(observe func([]JobMetric)) {
+	jfo.observe = observe
+}
+
+type observableJobFetcher interface {
+	setObserver(observe func([]JobMetric))
+}
+
+func (jfo *jobFetchObserver) notify(jobs []JobMetric) {
+	if jfo.observe != nil {
+		jfo.observe(jobs)
+	}
+}
+
 type JobJsonFetcher struct {
+	jobFetchObserver
 	scraper    SlurmByteScraper
 	cache      *AtomicThrottledCache[JobMetric]
-	errCounter prometheus.Counter
+	errCounter *prometheus.CounterVec
 }
 
-func (jjf *JobJsonFetcher) fetch() ([]JobMetric, error) {
-	data, err := jjf.scraper.FetchRawBytes()
+func (jjf *JobJsonFetcher) fetch(ctx context.Context) ([]JobMetric, error) {
+	data, err := jjf.scraper.FetchRawBytes(ctx)
 	if err != nil {
-		jjf.errCounter.Inc()
+		observeCommandError(jjf.errCounter, err)
 		return nil, err
 	}
-	var squeue squeueResponse
-	err = json.Unmarshal(data, &squeue)
+	jobMetrics, err := parseJobMetrics(data)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Unmarshaling node metrics %q", err))
+		slog.Error(fmt.Sprintf("Unmarshaling job metrics %q", err))
+		jjf.errCounter.WithLabelValues(reasonParse).Inc()
 		return nil, err
 	}
-	for _, j := range squeue.Jobs {
-		for _, resource := range j.JobResources.AllocNodes {
-			resource.Mem *= 1e9
-		}
-	}
-	return squeue.Jobs, nil
+	jjf.notify(jobMetrics)
+	return jobMetrics, nil
 }
 
-func (jjf *JobJsonFetcher) FetchMetrics() ([]JobMetric, error) {
-	return jjf.cache.FetchOrThrottle(jjf.fetch)
+func (jjf *JobJsonFetcher) FetchMetrics(ctx context.Context) ([]JobMetric, error) {
+	return jjf.cache.FetchOrThrottle(func() ([]JobMetric, error) { return jjf.fetch(ctx) })
 }
 
 func (jjf *JobJsonFetcher) ScrapeDuration() time.Duration {
 	return jjf.scraper.Duration()
 }
 
-func (jjf *JobJsonFetcher) ScrapeError() prometheus.Counter {
-	return jjf.errCounter
-}
-
 type JobCliFallbackFetcher struct {
+	jobFetchObserver
 	scraper    SlurmByteScraper
 	cache      *AtomicThrottledCache[JobMetric]
-	errCounter prometheus.Counter
+	errCounter *prometheus.CounterVec
 }
 
-func (jcf *JobCliFallbackFetcher) fetch() ([]JobMetric, error) {
-	squeue, err := jcf.scraper.FetchRawBytes()
+func (jcf *JobCliFallbackFetcher) fetch(ctx context.Context) ([]JobMetric, error) {
+	squeue, err := jcf.scraper.FetchRawBytes(ctx)
 	if err != nil {
+		observeCommandError(jcf.errCounter, err)
 		return nil, err
 	}
 	jobMetrics := make([]JobMetric, 0)
@@ -109,30 +309,43 @@ func (jcf *JobCliFallbackFetcher) fetch() ([]JobMetric, error) {
 	squeue = bytes.Trim(squeue, "\n")
 	if len(squeue) == 0 {
 		// handle no jobs returned
+		jcf.notify(nil)
 		return nil, nil
 	}
 
 	for i, line := range bytes.Split(squeue, []byte("\n")) {
+		if isClusterHeader(line) {
+			continue
+		}
 		var metric struct {
-			Account     string    `json:"a"`
-			JobId       float64   `json:"id"`
-			EndTime     NAbleTime `json:"end_time"`
-			JobState    string    `json:"state"`
-			Partition   string    `json:"p"`
-			UserName    string    `json:"u"`
-			Cpu         int64     `json:"cpu"`
-			Mem         string    `json:"mem"`
-			StateReason string    `json:"r"`
+			Account     string        `json:"a"`
+			JobId       float64       `json:"id"`
+			EndTime     NAbleTime     `json:"end_time"`
+			SubmitTime  NAbleTime     `json:"submit"`
+			StartTime   NAbleTime     `json:"start"`
+			TimeLimit   NAbleDuration `json:"limit"`
+			Gres        string        `json:"gres"`
+			Nodes       float64       `json:"nodes"`
+			ArrayJobId  float64       `json:"array_job_id"`
+			ArrayTaskId string        `json:"array_id"`
+			JobState    string        `json:"state"`
+			Partition   string        `json:"p"`
+			Qos         string        `json:"qos"`
+			UserName    string        `json:"u"`
+			Cpu         int64         `json:"cpu"`
+			Mem         string        `json:"mem"`
+			StateReason string        `json:"r"`
+			NodeList    string        `json:"n"`
 		}
 		if err := json.Unmarshal(line, &metric); err != nil {
 			slog.Error(fmt.Sprintf("squeue fallback parse error: failed on line %d `%s`", i, line))
-			jcf.errCounter.Inc()
+			jcf.errCounter.WithLabelValues(reasonParse).Inc()
 			continue
 		}
 		mem, err := MemToFloat(metric.Mem)
 		if err != nil {
 			slog.Error(fmt.Sprintf("squeue fallback parse error: failed on line %d `%s` with err `%q`", i, line, err))
-			jcf.errCounter.Inc()
+			jcf.errCounter.WithLabelValues(reasonParse).Inc()
 			continue
 		}
 		re := regexp.MustCompile(`^\((?P<reason>(.+))\)$`)
@@ -141,18 +354,46 @@ func (jcf *JobCliFallbackFetcher) fetch() ([]JobMetric, error) {
 				metric.StateReason = matches[re.SubexpIndex("reason")]
 			} else {
 				slog.Error(fmt.Sprintf("squeue failed to pull pending state reason. Got state reason: %s", metric.StateReason))
-				jcf.errCounter.Inc()
+				jcf.errCounter.WithLabelValues(reasonParse).Inc()
 			}
 		}
 
+		// unknown times are left at 0 so they're excluded from pending ages and time limits
+		submitTime, startTime := 0., 0.
+		if !metric.SubmitTime.IsZero() {
+			submitTime = float64(metric.SubmitTime.Unix())
+		}
+		if !metric.StartTime.IsZero() {
+			startTime = float64(metric.StartTime.Unix())
+		}
+		// %F is the job id itself outside of arrays, %K is N/A
+		var arrayJobId, arrayTaskId float64
+		if metric.ArrayTaskId != "N/A" && metric.ArrayTaskId != "" {
+			if arrayTaskId, err = strconv.ParseFloat(metric.ArrayTaskId, 64); err == nil {
+				arrayJobId = metric.ArrayJobId
+			} else {
+				slog.Error(fmt.Sprintf("squeue fallback parse error: unexpected array task id %q on line %d", metric.ArrayTaskId, i))
+				jcf.errCounter.WithLabelValues(reasonParse).Inc()
+				arrayTaskId = 0
+			}
+		}
 		openapiJobMetric := JobMetric{
-			Account:     metric.Account,
-			JobId:       metric.JobId,
-			JobState:    metric.JobState,
-			Partition:   metric.Partition,
-			UserName:    metric.UserName,
-			EndTime:     float64(metric.EndTime.Unix()),
+			Account:    metric.Account,
+			JobId:      metric.JobId,
+			JobState:   metric.JobState,
+			Partition:  metric.Partition,
+			Qos:        metric.Qos,
+			UserName:   metric.UserName,
+			EndTime:    float64(metric.EndTime.Unix()),
+			SubmitTime: submitTime,
+			StartTime:  OptionalFloat(startTime),
+			TimeLimit:  OptionalFloat(metric.TimeLimit.Minutes()),
+			// squeue only reports gres per node
+			Gres:        parseGres(metric.Gres).scale(metric.Nodes),
+			ArrayJobId:  OptionalFloat(arrayJobId),
+			ArrayTaskId: OptionalFloat(arrayTaskId),
 			StateReason: metric.StateReason,
+			Nodes:       metric.NodeList,
 			JobResources: JobResource{
 				AllocCpus:  float64(metric.Cpu),
 				AllocNodes: map[string]*NodeResource{"0": {Mem: mem}},
@@ -160,21 +401,18 @@ func (jcf *JobCliFallbackFetcher) fetch() ([]JobMetric, error) {
 		}
 		jobMetrics = append(jobMetrics, openapiJobMetric)
 	}
+	jcf.notify(jobMetrics)
 	return jobMetrics, nil
 }
 
-func (jcf *JobCliFallbackFetcher) FetchMetrics() ([]JobMetric, error) {
-	return jcf.cache.FetchOrThrottle(jcf.fetch)
+func (jcf *JobCliFallbackFetcher) FetchMetrics(ctx context.Context) ([]JobMetric, error) {
+	return jcf.cache.FetchOrThrottle(func() ([]JobMetric, error) { return jcf.fetch(ctx) })
 }
 
 func (jcf *JobCliFallbackFetcher) ScrapeDuration() time.Duration {
 	return jcf.scraper.Duration()
 }
 
-func (jcf *JobCliFallbackFetcher) ScrapeError() prometheus.Counter {
-	return jcf.errCounter
-}
-
 func totalAllocMem(resource *JobResource) float64 {
 	var allocMem float64
 	for _, node := range resource.AllocNodes {
@@ -196,16 +434,63 @@ func (nat *NAbleTime) UnmarshalJSON(data []byte) error {
 		nat.Time = time.Time{}
 		return nil
 	}
-	t, err := time.Parse("2006-01-02T15:04:05", tString)
+	// squeue prints times in the local timezone of the cluster, like sacct
+	t, err := time.ParseInLocation(sacctTimeFormat, tString, time.Local)
 	nat.Time = t
 	return err
 }
 
+// squeue time limit, i.e 1-00:00:00, 2:00:00 or 30:00. Unlimited and unset limits are reported as 0
+type NAbleDuration struct{ time.Duration }
+
+func (nad *NAbleDuration) UnmarshalJSON(data []byte) error {
+	var dString string
+	if err := json.Unmarshal(data, &dString); err != nil {
+		return err
+	}
+	nullSet := map[string]struct{}{"N/A": {}, "NONE": {}, "UNLIMITED": {}, "NOT_SET": {}, "INVALID": {}}
+	if _, ok := nullSet[dString]; ok {
+		nad.Duration = 0
+		return nil
+	}
+	var days int64
+	if dayStr, rest, ok := strings.Cut(dString, "-"); ok {
+		d, err := strconv.ParseInt(dayStr, 10, 64)
+		if err != nil {
+			return err
+		}
+		days, dString = d, rest
+	}
+	// [hours:]minutes:seconds, or minutes alone
+	var units []time.Duration
+	parts := strings.Split(dString, ":")
+	switch len(parts) {
+	case 1:
+		units = []time.Duration{time.Minute}
+	case 2:
+		units = []time.Duration{time.Minute, time.Second}
+	case 3:
+		units = []time.Duration{time.Hour, time.Minute, time.Second}
+	default:
+		return fmt.Errorf("unexpected time limit format %q", dString)
+	}
+	nad.Duration = time.Duration(days) * 24 * time.Hour
+	for i, part := range parts {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return err
+		}
+		nad.Duration += time.Duration(n) * units[i]
+	}
+	return nil
+}
+
 type UserJobMetric struct {
 	stateJobCount map[string]float64
 	totalJobCount float64
 	allocMemory   map[string]float64
 	allocCpu      map[string]float64
+	arrays        map[arrayStateKey]struct{}
 }
 
 func parseUserJobMetrics(jobMetrics []JobMetric) map[string]*UserJobMetric {
@@ -217,10 +502,14 @@ func parseUserJobMetrics(jobMetrics []JobMetric) map[string]*UserJobMetric {
 				stateJobCount: make(map[string]float64),
 				allocMemory:   make(map[string]float64),
 				allocCpu:      make(map[string]float64),
+				arrays:        make(map[arrayStateKey]struct{}),
 			}
 		}
-		metric.stateJobCount[jobMetric.JobState]++
-		metric.totalJobCount++
+		// cpus and mem are summed over every task, array tasks only count as a job once per state
+		if countsAsJob(metric.arrays, &jobMetric) {
+			metric.stateJobCount[jobMetric.JobState]++
+			metric.totalJobCount++
+		}
 		metric.allocMemory[jobMetric.JobState] += totalAllocMem(&jobMetric.JobResources)
 		metric.allocCpu[jobMetric.JobState] += jobMetric.JobResources.AllocCpus
 		userMetricMap[jobMetric.UserName] = metric
@@ -232,67 +521,131 @@ type AccountMetric struct {
 	stateAllocMem map[string]float64
 	stateAllocCpu map[string]float64
 	stateJobCount map[string]float64
+	arrays        map[arrayStateKey]struct{}
+}
+
+func newAccountMetric() *AccountMetric {
+	return &AccountMetric{
+		stateJobCount: make(map[string]float64),
+		stateAllocMem: make(map[string]float64),
+		stateAllocCpu: make(map[string]float64),
+		arrays:        make(map[arrayStateKey]struct{}),
+	}
+}
+
+func (am *AccountMetric) add(job *JobMetric) {
+	am.stateAllocCpu[job.JobState] += job.JobResources.AllocCpus
+	am.stateAllocMem[job.JobState] += totalAllocMem(&job.JobResources)
+	if countsAsJob(am.arrays, job) {
+		am.stateJobCount[job.JobState]++
+	}
 }
 
 func parseAccountMetrics(jobs []JobMetric) map[string]*AccountMetric {
 	accountMap := make(map[string]*AccountMetric)
-	for _, job := range jobs {
-		metric, ok := accountMap[job.Account]
+	for i := range jobs {
+		metric, ok := accountMap[jobs[i].Account]
 		if !ok {
-			metric = &AccountMetric{
-				stateJobCount: make(map[string]float64),
-				stateAllocMem: make(map[string]float64),
-				stateAllocCpu: make(map[string]float64),
-			}
-			accountMap[job.Account] = metric
+			metric = newAccountMetric()
+			accountMap[jobs[i].Account] = metric
+		}
+		metric.add(&jobs[i])
+	}
+	return accountMap
+}
+
+// account -> qos -> metric
+func parseAccountQosMetrics(jobs []JobMetric) map[string]map[string]*AccountMetric {
+	accountMap := make(map[string]map[string]*AccountMetric)
+	for i := range jobs {
+		job := &jobs[i]
+		if _, ok := accountMap[job.Account]; !ok {
+			accountMap[job.Account] = make(map[string]*AccountMetric)
 		}
-		metric.stateAllocCpu[job.JobState] += job.JobResources.AllocCpus
-		metric.stateAllocMem[job.JobState] += totalAllocMem(&job.JobResources)
-		metric.stateJobCount[job.JobState]++
+		metric, ok := accountMap[job.Account][job.Qos]
+		if !ok {
+			metric = newAccountMetric()
+			accountMap[job.Account][job.Qos] = metric
+		}
+		metric.add(job)
 	}
 	return accountMap
 }
 
+// qos share the per state breakdown of accounts
+func parseQosMetrics(jobs []JobMetric) map[string]*AccountMetric {
+	qosMap := make(map[string]*AccountMetric)
+	for i := range jobs {
+		metric, ok := qosMap[jobs[i].Qos]
+		if !ok {
+			metric = newAccountMetric()
+			qosMap[jobs[i].Qos] = metric
+		}
+		metric.add(&jobs[i])
+	}
+	return qosMap
+}
+
 type PartitionJobMetric struct {
 	partitionState map[string]float64
+	arrays         map[arrayStateKey]struct{}
 }
 
+// array tasks in the same state count once, like the user, account and qos totals
 func parsePartitionJobMetrics(jobs []JobMetric) map[string]*PartitionJobMetric {
 	partitionMetric := make(map[string]*PartitionJobMetric)
-	for _, job := range jobs {
+	for i := range jobs {
+		job := &jobs[i]
 		metric, ok := partitionMetric[job.Partition]
 		if !ok {
 			metric = &PartitionJobMetric{
 				partitionState: make(map[string]float64),
+				arrays:         make(map[arrayStateKey]struct{}),
 			}
 			partitionMetric[job.Partition] = metric
 		}
-		metric.partitionState[job.JobState]++
+		if countsAsJob(metric.arrays, job) {
+			metric.partitionState[job.JobState]++
+		}
 	}
 	return partitionMetric
 }
 
 type StateReasonMetric struct {
 	pendingStateCount map[string]float64
+	// partition or account -> reason -> count
+	partition map[string]map[string]float64
+	account   map[string]map[string]float64
 }
 
-func parseStateReasonMetric(jobs []JobMetric) *StateReasonMetric {
+// count pending jobs per normalized reason, i.e from (ReqNodeNotAvail, UnavailableNodes:cs[100,...])
+// to (ReqNodeNotAvail, UnavailableNodes) so reasons don't grow with the node or limit names
+func parseStateReasonMetric(jobs []JobMetric, normalizer *ReasonNormalizer) *StateReasonMetric {
 	metric := StateReasonMetric{
 		pendingStateCount: make(map[string]float64),
+		partition:         make(map[string]map[string]float64),
+		account:           make(map[string]map[string]float64),
+	}
+	// the same few reasons are shared by most pending jobs
+	normalized := make(map[string]string)
+	observe := func(counts map[string]map[string]float64, key, reason string) {
+		if _, ok := counts[key]; !ok {
+			counts[key] = make(map[string]float64)
+		}
+		counts[key][reason]++
 	}
-
 	for _, job := range jobs {
 		if job.JobState != "PENDING" {
 			continue
 		}
-		reason := job.StateReason
-		if strings.Contains(reason, reqNodeNotAvailReason) {
-			// consolidate pending node not avail reason to be node agnostic. i.e
-			// from (ReqNodeNotAvail, UnavailableNodes:cs[100,...])
-			// to (ReqNodeNotAvail, UnavailableNodes)
-			reason = fmt.Sprintf("(%s)", reqNodeNotAvailReason)
+		reason, ok := normalized[job.StateReason]
+		if !ok {
+			reason = normalizer.normalize(job.StateReason)
+			normalized[job.StateReason] = reason
 		}
 		metric.pendingStateCount[reason]++
+		observe(metric.partition, job.Partition, reason)
+		observe(metric.account, job.Account, reason)
 	}
 	return &metric
 }
@@ -320,12 +673,214 @@ func parseFeatureMetric(jobs []JobMetric) map[string]*FeatureJobMetric {
 	return featureMap
 }
 
+type JobGresMetric struct {
+	// gres -> user/account/partition/qos -> count
+	user      map[string]map[string]float64
+	account   map[string]map[string]float64
+	partition map[string]map[string]float64
+	qos       map[string]map[string]float64
+}
+
+// gres allocated to running jobs per user, account, partition and qos
+func parseJobGresMetrics(jobs []JobMetric) *JobGresMetric {
+	metric := &JobGresMetric{
+		user:      make(map[string]map[string]float64),
+		account:   make(map[string]map[string]float64),
+		partition: make(map[string]map[string]float64),
+		qos:       make(map[string]map[string]float64),
+	}
+	add := func(metrics map[string]map[string]float64, gres string, key string, count float64) {
+		if _, ok := metrics[gres]; !ok {
+			metrics[gres] = make(map[string]float64)
+		}
+		metrics[gres][key] += count
+	}
+	for _, job := range jobs {
+		if job.JobState != "RUNNING" {
+			continue
+		}
+		for gres, count := range job.Gres {
+			add(metric.user, gres, job.UserName, count)
+			add(metric.account, gres, job.Account, count)
+			add(metric.partition, gres, job.Partition, count)
+			add(metric.qos, gres, job.Qos, count)
+		}
+	}
+	return metric
+}
+
+// pending age histogram buckets in seconds, from a minute up to a week
+var pendingAgeBuckets = []float64{60, 300, 900, 1800, 3600, 3 * 3600, 6 * 3600, 12 * 3600, 24 * 3600, 48 * 3600, 7 * 24 * 3600}
+
+// fraction of the time limit running jobs have used
+var walltimeRatioBuckets = []float64{.1, .25, .5, .75, .9, .95, 1}
+
+// seconds each pending job has waited since submission, grouped by partition and by account
+func parsePendingAgeMetrics(jobs []JobMetric, now time.Time) (partitions map[string]*HistogramMetric, accounts map[string]*HistogramMetric) {
+	partitions = make(map[string]*HistogramMetric)
+	accounts = make(map[string]*HistogramMetric)
+	for _, job := range jobs {
+		if job.JobState != "PENDING" || job.SubmitTime <= 0 {
+			continue
+		}
+		// clamp clock skew between the exporter and slurmctld
+		age := max(float64(now.Unix())-job.SubmitTime, 0)
+		observeHistogram(partitions, job.Partition, pendingAgeBuckets, age)
+		observeHistogram(accounts, job.Account, pendingAgeBuckets, age)
+	}
+	return partitions, accounts
+}
+
+type TimeLimitMetric struct {
+	nearLimit float64
+	usedRatio *HistogramMetric
+}
+
+// elapsed and remaining walltime of a running job. ok is false for pending and unlimited jobs
+func jobWalltime(job *JobMetric, now time.Time) (limit, elapsed, remaining float64, ok bool) {
+	if job.JobState != "RUNNING" || job.StartTime <= 0 || job.TimeLimit <= 0 {
+		return 0, 0, 0, false
+	}
+	// time limits are in minutes
+	limit = float64(job.TimeLimit) * 60
+	elapsed = max(float64(now.Unix())-float64(job.StartTime), 0)
+	return limit, elapsed, max(limit-elapsed, 0), true
+}
+
+// running jobs close to their time limit and the share of their time limit used, grouped by partition and by account
+func parseTimeLimitMetrics(jobs []JobMetric, now time.Time, warning time.Duration) (partitions map[string]*TimeLimitMetric, accounts map[string]*TimeLimitMetric) {
+	partitions = make(map[string]*TimeLimitMetric)
+	accounts = make(map[string]*TimeLimitMetric)
+	observe := func(metrics map[string]*TimeLimitMetric, key string, ratio float64, nearLimit bool) {
+		metric, ok := metrics[key]
+		if !ok {
+			metric = &TimeLimitMetric{usedRatio: NewHistogramMetric(walltimeRatioBuckets)}
+			metrics[key] = metric
+		}
+		metric.usedRatio.observe(ratio)
+		if nearLimit {
+			metric.nearLimit++
+		}
+	}
+	for i := range jobs {
+		limit, elapsed, remaining, ok := jobWalltime(&jobs[i], now)
+		if !ok {
+			continue
+		}
+		nearLimit := remaining <= warning.Seconds()
+		observe(partitions, jobs[i].Partition, elapsed/limit, nearLimit)
+		observe(accounts, jobs[i].Account, elapsed/limit, nearLimit)
+	}
+	return partitions, accounts
+}
+
+type NodeJobMetric struct {
+	jobs     float64
+	allocCpu float64
+	allocMem float64
+}
+
+// states of jobs holding an allocation. squeue --states=all also lists finished jobs with the nodes they ran on
+var nodeAllocStates = map[string]struct{}{
+	"RUNNING":    {},
+	"COMPLETING": {},
+	"SUSPENDED":  {},
+}
+
+// jobs holding an allocation per node. Cpus and memory come from the per node allocations squeue --json reports.
+// The cli fallback only knows job totals, so they're split evenly between the nodes of the job
+func parseNodeJobMetrics(jobs []JobMetric) map[string]*NodeJobMetric {
+	nodeMetrics := make(map[string]*NodeJobMetric)
+	for _, job := range jobs {
+		if _, ok := nodeAllocStates[job.JobState]; !ok || job.Nodes == "" {
+			continue
+		}
+		nodes, err := hostlist.Expand(job.Nodes)
+		if err != nil || len(nodes) == 0 {
+			slog.Error(fmt.Sprintf("job %d node list error %q", int64(job.JobId), err))
+			continue
+		}
+		count := float64(len(nodes))
+		for _, node := range nodes {
+			metric, ok := nodeMetrics[node]
+			if !ok {
+				metric = new(NodeJobMetric)
+				nodeMetrics[node] = metric
+			}
+			metric.jobs++
+			if alloc, ok := job.NodeAllocs[node]; ok {
+				metric.allocCpu += alloc.Cpus
+				metric.allocMem += alloc.Mem
+				continue
+			}
+			metric.allocCpu += job.JobResources.AllocCpus / count
+			metric.allocMem += totalAllocMem(&job.JobResources) / count
+		}
+	}
+	return nodeMetrics
+}
+
+// pending jobs waiting on each unavailable node, from reasons like (ReqNodeNotAvail, UnavailableNodes:cs[100,101])
+func parseUnavailableNodeMetrics(jobs []JobMetric) map[string]float64 {
+	unavailable := make(map[string]float64)
+	for _, job := range jobs {
+		if job.JobState != "PENDING" {
+			continue
+		}
+		_, nodeList, found := strings.Cut(job.StateReason, reqNodeNotAvailReason+":")
+		if !found {
+			continue
+		}
+		nodes, err := hostlist.Expand(strings.TrimRight(nodeList, ")"))
+		if err != nil {
+			slog.Error(fmt.Sprintf("job %d unavailable node list error %q", int64(job.JobId), err))
+			continue
+		}
+		for _, node := range nodes {
+			unavailable[node]++
+		}
+	}
+	return unavailable
+}
+
+// jobs in one of states, capped at max to bound cardinality
+// the oldest job ids are kept so the same jobs are exported from scrape to scrape
+func filterJobMetrics(jobMetrics []JobMetric, states []string, max int) []JobMetric {
+	filtered := make([]JobMetric, 0)
+	for _, job := range jobMetrics {
+		if slices.Contains(states, job.JobState) {
+			filtered = append(filtered, job)
+		}
+	}
+	if len(filtered) <= max {
+		return filtered
+	}
+	slices.SortFunc(filtered, func(a, b JobMetric) int {
+		return cmp.Compare(a.JobId, b.JobId)
+	})
+	slog.Warn(fmt.Sprintf("%d jobs match the per job metric states, only exporting %d", len(filtered), max))
+	return filtered[:max]
+}
+
 type JobsCollector struct {
 	// collector state
-	fetcher      SlurmMetricFetcher[JobMetric]
-	fallback     bool
-	jobAllocCpus *prometheus.Desc
-	jobAllocMem  *prometheus.Desc
+	fetcher  SlurmMetricFetcher[JobMetric]
+	fallback bool
+	// per job metrics, only emitted when enabled
+	jobMetricsEnabled bool
+	jobMetricsMax     int
+	jobMetricsStates  []string
+	jobAllocCpus      *prometheus.Desc
+	jobAllocMem       *prometheus.Desc
+	jobTimeLimit      *prometheus.Desc
+	jobElapsed        *prometheus.Desc
+	jobRemaining      *prometheus.Desc
+	// per node metrics, only emitted when enabled
+	nodeJobsEnabled        bool
+	nodeJobCount           *prometheus.Desc
+	nodeJobCpuAlloc        *prometheus.Desc
+	nodeJobMemAlloc        *prometheus.Desc
+	nodeUnavailablePending *prometheus.Desc
 	// user metrics
 	userJobStateTotal *prometheus.Desc
 	userJobMemAlloc   *prometheus.Desc
@@ -336,15 +891,50 @@ type JobsCollector struct {
 	accountJobStateMemAlloc *prometheus.Desc
 	accountJobStateCpuAlloc *prometheus.Desc
 	accountJobStateTotal    *prometheus.Desc
+	accountQosLabels        bool
+	// qos metrics
+	qosJobStateTotal    *prometheus.Desc
+	qosJobStateCpuAlloc *prometheus.Desc
+	qosJobStateMemAlloc *prometheus.Desc
+	qosGresAlloc        *prometheus.Desc
 	// feature metrics
 	featureJobMemAlloc *prometheus.Desc
 	featureJobCpuAlloc *prometheus.Desc
 	featureJobTotal    *prometheus.Desc
 	// reason metrics
-	pendingReasonTotal *prometheus.Desc
+	pendingReasons              *ReasonNormalizer
+	pendingReasonTotal          *prometheus.Desc
+	partitionPendingReasonTotal *prometheus.Desc
+	accountPendingReasonTotal   *prometheus.Desc
+	// gres metrics
+	userGresAlloc         *prometheus.Desc
+	accountGresAlloc      *prometheus.Desc
+	partitionJobGresAlloc *prometheus.Desc
+	// pending age metrics
+	partitionPendingAge    *prometheus.Desc
+	accountPendingAge      *prometheus.Desc
+	partitionOldestPending *prometheus.Desc
+	// time limit metrics
+	timeLimitWarning       time.Duration
+	partitionNearTimeLimit *prometheus.Desc
+	accountNearTimeLimit   *prometheus.Desc
+	partitionWalltimeRatio *prometheus.Desc
+	accountWalltimeRatio   *prometheus.Desc
+	// job array metrics
+	arrayActive           *prometheus.Desc
+	userArrayActive       *prometheus.Desc
+	userArrayPendingTasks *prometheus.Desc
+	arrayTasks            *ArrayTaskTracker
+	// job state change metrics
+	transitions *JobTransitionTracker
+	// whether the fetcher calls the trackers on every fetch, otherwise they diff the result of each scrape
+	observedByFetcher bool
+	// cardinality limits. The trackers' counters fold their labels on their own, see stickyLabels
+	labelLimits   LabelLimiter
+	foldedValues  *prometheus.Desc
+	counterFolded *prometheus.Desc
 	// exporter metrics
 	jobScrapeDuration *prometheus.Desc
-	jobScrapeError    prometheus.Counter
 }
 
 func (jc *JobsCollector) SetFetcher(fetcher SlurmMetricFetcher[JobMetric]) {
@@ -354,29 +944,80 @@ func (jc *JobsCollector) SetFetcher(fetcher SlurmMetricFetcher[JobMetric]) {
 func NewJobsController(config *Config) *JobsCollector {
 	cliOpts := config.cliOpts
 	fetcher := config.TraceConf.sharedFetcher
-	return &JobsCollector{
-		fetcher:  fetcher,
-		fallback: cliOpts.fallback,
+	accountLabels := []string{"account", "state"}
+	if cliOpts.accountQosLabels {
+		accountLabels = []string{"account", "qos", "state"}
+	}
+	jc := &JobsCollector{
+		fetcher:           fetcher,
+		fallback:          cliOpts.fallback,
+		jobMetricsEnabled: cliOpts.jobMetricsEnabled,
+		jobMetricsMax:     cliOpts.jobMetricsMax,
+		jobMetricsStates:  cliOpts.jobMetricsStates,
+		nodeJobsEnabled:   cliOpts.nodeJobsEnabled,
+		timeLimitWarning:  cliOpts.timeLimitWarning,
+		accountQosLabels:  cliOpts.accountQosLabels,
+		pendingReasons:    cliOpts.pendingReasons,
+		arrayTasks:        NewArrayTaskTracker(config.constLabels(), cliOpts.labelLimits),
+		transitions:       NewJobTransitionTracker(config.constLabels(), cliOpts.labelLimits),
+		labelLimits:       cliOpts.labelLimits,
+		foldedValues:      newFoldedValuesDesc(config, "jobs"),
+		counterFolded:     newFoldedValuesDesc(config, "job_counters"),
 		// individual job metrics
-		jobAllocCpus:            prometheus.NewDesc("slurm_job_alloc_cpus", "amount of cpus allocated per job", []string{"jobid"}, nil),
-		jobAllocMem:             prometheus.NewDesc("slurm_job_alloc_mem", "amount of mem allocated per job", []string{"jobid"}, nil),
-		userJobStateTotal:       prometheus.NewDesc("slurm_user_state_total", "total jobs per state per user", []string{"username", "state"}, nil),
-		userJobMemAlloc:         prometheus.NewDesc("slurm_user_mem_alloc", "total mem alloc per user", []string{"username", "state"}, nil),
-		userJobCpuAlloc:         prometheus.NewDesc("slurm_user_cpu_alloc", "total cpu alloc per user", []string{"username", "state"}, nil),
-		partitionJobStateTotal:  prometheus.NewDesc("slurm_partition_job_state_total", "total jobs per partition per state", []string{"partition", "state"}, nil),
-		accountJobStateMemAlloc: prometheus.NewDesc("slurm_account_job_state_mem_alloc", "alloc mem consumed per account per job state", []string{"account", "state"}, nil),
-		accountJobStateCpuAlloc: prometheus.NewDesc("slurm_account_job_state_cpu_alloc", "alloc cpu consumed per account per job state", []string{"account", "state"}, nil),
-		accountJobStateTotal:    prometheus.NewDesc("slurm_account_job_state_total", "total jobs per account per job state", []string{"account", "state"}, nil),
-		featureJobMemAlloc:      prometheus.NewDesc("slurm_feature_mem_alloc", "alloc mem consumed per feature", []string{"feature"}, nil),
-		featureJobCpuAlloc:      prometheus.NewDesc("slurm_feature_cpu_alloc", "alloc cpu consumed per feature", []string{"feature"}, nil),
-		featureJobTotal:         prometheus.NewDesc("slurm_feature_total", "alloc cpu consumed per feature", []string{"feature"}, nil),
-		pendingReasonTotal:      prometheus.NewDesc("slurm_pending_reason_total", "count of the reason jobs are pending", []string{"reason"}, nil),
-		jobScrapeDuration:       prometheus.NewDesc("slurm_job_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.squeue), nil, nil),
-		jobScrapeError: prometheus.NewCounter(prometheus.CounterOpts{
-			Name: "slurm_job_scrape_error",
-			Help: "slurm job scrape error",
-		}),
+		jobAllocCpus:                prometheus.NewDesc("slurm_job_alloc_cpus", "amount of cpus allocated per job", []string{"jobid", "user", "account", "partition"}, config.constLabels()),
+		jobAllocMem:                 prometheus.NewDesc("slurm_job_alloc_mem", "amount of mem allocated per job", []string{"jobid", "user", "account", "partition"}, config.constLabels()),
+		jobTimeLimit:                prometheus.NewDesc("slurm_job_time_limit_seconds", "time limit per running job", []string{"jobid", "user", "account", "partition"}, config.constLabels()),
+		jobElapsed:                  prometheus.NewDesc("slurm_job_elapsed_seconds", "walltime used per running job", []string{"jobid", "user", "account", "partition"}, config.constLabels()),
+		jobRemaining:                prometheus.NewDesc("slurm_job_remaining_seconds", "walltime left before the time limit per running job", []string{"jobid", "user", "account", "partition"}, config.constLabels()),
+		nodeJobCount:                prometheus.NewDesc("slurm_node_job_count", "jobs holding an allocation per node", []string{"hostname"}, config.constLabels()),
+		nodeJobCpuAlloc:             prometheus.NewDesc("slurm_node_job_cpu_alloc", "cpus allocated to jobs per node", []string{"hostname"}, config.constLabels()),
+		nodeJobMemAlloc:             prometheus.NewDesc("slurm_node_job_mem_alloc", "mem allocated to jobs per node", []string{"hostname"}, config.constLabels()),
+		nodeUnavailablePending:      prometheus.NewDesc("slurm_node_unavailable_pending_jobs", "pending jobs waiting on an unavailable node", []string{"hostname"}, config.constLabels()),
+		userJobStateTotal:           prometheus.NewDesc("slurm_user_state_total", "total jobs per state per user", []string{"username", "state"}, config.constLabels()),
+		userJobMemAlloc:             prometheus.NewDesc("slurm_user_mem_alloc", "total mem alloc per user", []string{"username", "state"}, config.constLabels()),
+		userJobCpuAlloc:             prometheus.NewDesc("slurm_user_cpu_alloc", "total cpu alloc per user", []string{"username", "state"}, config.constLabels()),
+		partitionJobStateTotal:      prometheus.NewDesc("slurm_partition_job_state_total", "total jobs per partition per state", []string{"partition", "state"}, config.constLabels()),
+		accountJobStateMemAlloc:     prometheus.NewDesc("slurm_account_job_state_mem_alloc", "alloc mem consumed per account per job state", accountLabels, config.constLabels()),
+		accountJobStateCpuAlloc:     prometheus.NewDesc("slurm_account_job_state_cpu_alloc", "alloc cpu consumed per account per job state", accountLabels, config.constLabels()),
+		accountJobStateTotal:        prometheus.NewDesc("slurm_account_job_state_total", "total jobs per account per job state", accountLabels, config.constLabels()),
+		qosJobStateTotal:            prometheus.NewDesc("slurm_qos_job_state_total", "total jobs per qos per job state", []string{"qos", "state"}, config.constLabels()),
+		qosJobStateCpuAlloc:         prometheus.NewDesc("slurm_qos_job_state_cpu_alloc", "alloc cpu consumed per qos per job state", []string{"qos", "state"}, config.constLabels()),
+		qosJobStateMemAlloc:         prometheus.NewDesc("slurm_qos_job_state_mem_alloc", "alloc mem consumed per qos per job state", []string{"qos", "state"}, config.constLabels()),
+		qosGresAlloc:                prometheus.NewDesc("slurm_qos_gres_alloc", "gres allocated to running jobs per qos", []string{"qos", "gres", "model"}, config.constLabels()),
+		featureJobMemAlloc:          prometheus.NewDesc("slurm_feature_mem_alloc", "alloc mem consumed per feature", []string{"feature"}, config.constLabels()),
+		featureJobCpuAlloc:          prometheus.NewDesc("slurm_feature_cpu_alloc", "alloc cpu consumed per feature", []string{"feature"}, config.constLabels()),
+		featureJobTotal:             prometheus.NewDesc("slurm_feature_total", "alloc cpu consumed per feature", []string{"feature"}, config.constLabels()),
+		pendingReasonTotal:          prometheus.NewDesc("slurm_pending_reason_total", "count of the reason jobs are pending", []string{"reason"}, config.constLabels()),
+		partitionPendingReasonTotal: prometheus.NewDesc("slurm_partition_pending_reason_total", "count of the reason jobs are pending per partition", []string{"partition", "reason"}, config.constLabels()),
+		accountPendingReasonTotal:   prometheus.NewDesc("slurm_account_pending_reason_total", "count of the reason jobs are pending per account", []string{"account", "reason"}, config.constLabels()),
+		userGresAlloc:               prometheus.NewDesc("slurm_user_gres_alloc", "gres allocated to running jobs per user", []string{"username", "gres", "model"}, config.constLabels()),
+		accountGresAlloc:            prometheus.NewDesc("slurm_account_gres_alloc", "gres allocated to running jobs per account", []string{"account", "gres", "model"}, config.constLabels()),
+		partitionJobGresAlloc:       prometheus.NewDesc("slurm_partition_job_gres_alloc", "gres allocated to running jobs per partition", []string{"partition", "gres", "model"}, config.constLabels()),
+		partitionPendingAge:         prometheus.NewDesc("slurm_partition_pending_age_seconds", "seconds pending jobs have waited since submission per partition", []string{"partition"}, config.constLabels()),
+		accountPendingAge:           prometheus.NewDesc("slurm_account_pending_age_seconds", "seconds pending jobs have waited since submission per account", []string{"account"}, config.constLabels()),
+		partitionOldestPending:      prometheus.NewDesc("slurm_partition_oldest_pending_age_seconds", "seconds the oldest pending job has waited since submission per partition", []string{"partition"}, config.constLabels()),
+		partitionNearTimeLimit:      prometheus.NewDesc("slurm_partition_jobs_near_time_limit", "running jobs within the time limit warning of their time limit per partition", []string{"partition"}, config.constLabels()),
+		accountNearTimeLimit:        prometheus.NewDesc("slurm_account_jobs_near_time_limit", "running jobs within the time limit warning of their time limit per account", []string{"account"}, config.constLabels()),
+		partitionWalltimeRatio:      prometheus.NewDesc("slurm_partition_walltime_used_ratio", "share of the requested time limit used by running jobs per partition", []string{"partition"}, config.constLabels()),
+		accountWalltimeRatio:        prometheus.NewDesc("slurm_account_walltime_used_ratio", "share of the requested time limit used by running jobs per account", []string{"account"}, config.constLabels()),
+		arrayActive:                 prometheus.NewDesc("slurm_array_active_count", "job arrays with unfinished tasks", nil, config.constLabels()),
+		userArrayActive:             prometheus.NewDesc("slurm_user_array_active_count", "job arrays with unfinished tasks per owner", []string{"username"}, config.constLabels()),
+		userArrayPendingTasks:       prometheus.NewDesc("slurm_user_array_pending_tasks", "pending array tasks per owner", []string{"username"}, config.constLabels()),
+		jobScrapeDuration:           prometheus.NewDesc("slurm_job_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.squeue), nil, config.constLabels()),
+	}
+	// wrapping the fetcher, i.e with a background poller, must come after so the trackers see every fetch
+	if observable, ok := fetcher.(observableJobFetcher); ok {
+		observable.setObserver(jc.observeJobs)
+		jc.observedByFetcher = true
 	}
+	return jc
+}
+
+// the trackers diff the real users and accounts of every fetch and fold them with their own sticky labels,
+// since folding by the top users of each scrape would move their counters between series
+func (jc *JobsCollector) observeJobs(jobs []JobMetric) {
+	jc.arrayTasks.observe(jobs)
+	jc.transitions.observe(jobs)
 }
 
 func (jc *JobsCollector) Describe(ch chan<- *prometheus.Desc) {
@@ -389,24 +1030,64 @@ func (jc *JobsCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- jc.accountJobStateMemAlloc
 	ch <- jc.accountJobStateCpuAlloc
 	ch <- jc.accountJobStateTotal
+	ch <- jc.qosJobStateTotal
+	ch <- jc.qosJobStateCpuAlloc
+	ch <- jc.qosJobStateMemAlloc
+	ch <- jc.qosGresAlloc
 	ch <- jc.featureJobMemAlloc
 	ch <- jc.featureJobCpuAlloc
 	ch <- jc.featureJobTotal
 	ch <- jc.pendingReasonTotal
+	ch <- jc.partitionPendingReasonTotal
+	ch <- jc.accountPendingReasonTotal
+	ch <- jc.userGresAlloc
+	ch <- jc.accountGresAlloc
+	ch <- jc.partitionJobGresAlloc
+	ch <- jc.partitionPendingAge
+	ch <- jc.accountPendingAge
+	ch <- jc.partitionOldestPending
+	ch <- jc.partitionNearTimeLimit
+	ch <- jc.accountNearTimeLimit
+	ch <- jc.partitionWalltimeRatio
+	ch <- jc.accountWalltimeRatio
+	ch <- jc.jobTimeLimit
+	ch <- jc.jobElapsed
+	ch <- jc.jobRemaining
+	ch <- jc.nodeJobCount
+	ch <- jc.nodeJobCpuAlloc
+	ch <- jc.nodeJobMemAlloc
+	ch <- jc.nodeUnavailablePending
+	ch <- jc.arrayActive
+	ch <- jc.userArrayActive
+	ch <- jc.userArrayPendingTasks
+	jc.arrayTasks.finished.Describe(ch)
+	jc.transitions.transitions.Describe(ch)
+	ch <- jc.foldedValues
+	ch <- jc.counterFolded
 	ch <- jc.jobScrapeDuration
-	ch <- jc.jobScrapeError.Desc()
 }
 
 func (jc *JobsCollector) Collect(ch chan<- prometheus.Metric) {
-	defer func() {
-		ch <- jc.fetcher.ScrapeError()
-	}()
-	jobMetrics, err := jc.fetcher.FetchMetrics()
+	jc.CollectWithContext(context.Background(), ch)
+}
+
+func (jc *JobsCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	jobMetrics, err := jc.fetcher.FetchMetrics(ctx)
 	ch <- prometheus.MustNewConstMetric(jc.jobScrapeDuration, prometheus.GaugeValue, float64(jc.fetcher.ScrapeDuration().Milliseconds()))
 	if err != nil {
 		slog.Error(fmt.Sprintf("fetcher failure %q", err))
 		return
 	}
+	// per job metrics keep the real user and account, they're capped by jobMetricsMax instead
+	perJobMetrics := jobMetrics
+	jobMetrics, folded := jc.labelLimits.foldJobs(jobMetrics)
+	if jc.labelLimits.enabled() {
+		for label, count := range folded {
+			ch <- prometheus.MustNewConstMetric(jc.foldedValues, prometheus.GaugeValue, count, label)
+		}
+		ch <- prometheus.MustNewConstMetric(jc.counterFolded, prometheus.GaugeValue, jc.arrayTasks.users.foldedCount(), "username")
+		ch <- prometheus.MustNewConstMetric(jc.counterFolded, prometheus.GaugeValue, jc.transitions.accounts.foldedCount(), "account")
+	}
 	userMetrics := parseUserJobMetrics(jobMetrics)
 	for user, metric := range userMetrics {
 		for state, allocCpu := range metric.allocCpu {
@@ -426,19 +1107,35 @@ func (jc *JobsCollector) Collect(ch chan<- prometheus.Metric) {
 		}
 	}
 
-	emitNonZeroStateConstGuage := func(desc *prometheus.Desc, metricMap map[string]float64, account string) {
+	emitNonZeroStateConstGuage := func(desc *prometheus.Desc, metricMap map[string]float64, labels ...string) {
 		for state, val := range metricMap {
 			if val > 0 {
-				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, val, account, state)
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, val, append(labels, state)...)
 			}
 		}
 	}
 
-	accountMetrics := parseAccountMetrics(jobMetrics)
-	for account, metric := range accountMetrics {
-		emitNonZeroStateConstGuage(jc.accountJobStateCpuAlloc, metric.stateAllocCpu, account)
-		emitNonZeroStateConstGuage(jc.accountJobStateMemAlloc, metric.stateAllocMem, account)
-		emitNonZeroStateConstGuage(jc.accountJobStateTotal, metric.stateJobCount, account)
+	if jc.accountQosLabels {
+		for account, qosMetrics := range parseAccountQosMetrics(jobMetrics) {
+			for qos, metric := range qosMetrics {
+				emitNonZeroStateConstGuage(jc.accountJobStateCpuAlloc, metric.stateAllocCpu, account, qos)
+				emitNonZeroStateConstGuage(jc.accountJobStateMemAlloc, metric.stateAllocMem, account, qos)
+				emitNonZeroStateConstGuage(jc.accountJobStateTotal, metric.stateJobCount, account, qos)
+			}
+		}
+	} else {
+		accountMetrics := parseAccountMetrics(jobMetrics)
+		for account, metric := range accountMetrics {
+			emitNonZeroStateConstGuage(jc.accountJobStateCpuAlloc, metric.stateAllocCpu, account)
+			emitNonZeroStateConstGuage(jc.accountJobStateMemAlloc, metric.stateAllocMem, account)
+			emitNonZeroStateConstGuage(jc.accountJobStateTotal, metric.stateJobCount, account)
+		}
+	}
+
+	for qos, metric := range parseQosMetrics(jobMetrics) {
+		emitNonZeroStateConstGuage(jc.qosJobStateCpuAlloc, metric.stateAllocCpu, qos)
+		emitNonZeroStateConstGuage(jc.qosJobStateMemAlloc, metric.stateAllocMem, qos)
+		emitNonZeroStateConstGuage(jc.qosJobStateTotal, metric.stateJobCount, qos)
 	}
 
 	partitionJobMetrics := parsePartitionJobMetrics(jobMetrics)
@@ -461,8 +1158,93 @@ func (jc *JobsCollector) Collect(ch chan<- prometheus.Metric) {
 		}
 	}
 
-	stateReasonMetric := parseStateReasonMetric(jobMetrics)
+	stateReasonMetric := parseStateReasonMetric(jobMetrics, jc.pendingReasons)
 	for pendingReason, pendingCount := range stateReasonMetric.pendingStateCount {
 		ch <- prometheus.MustNewConstMetric(jc.pendingReasonTotal, prometheus.GaugeValue, pendingCount, pendingReason)
 	}
+	for partition, reasons := range stateReasonMetric.partition {
+		for pendingReason, pendingCount := range reasons {
+			ch <- prometheus.MustNewConstMetric(jc.partitionPendingReasonTotal, prometheus.GaugeValue, pendingCount, partition, pendingReason)
+		}
+	}
+	for account, reasons := range stateReasonMetric.account {
+		for pendingReason, pendingCount := range reasons {
+			ch <- prometheus.MustNewConstMetric(jc.accountPendingReasonTotal, prometheus.GaugeValue, pendingCount, account, pendingReason)
+		}
+	}
+
+	emitGres := func(desc *prometheus.Desc, gresMetrics map[string]map[string]float64) {
+		for gres, counts := range gresMetrics {
+			name, model := splitGresKey(gres)
+			for key, count := range counts {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, count, key, name, model)
+			}
+		}
+	}
+	jobGresMetric := parseJobGresMetrics(jobMetrics)
+	emitGres(jc.userGresAlloc, jobGresMetric.user)
+	emitGres(jc.accountGresAlloc, jobGresMetric.account)
+	emitGres(jc.partitionJobGresAlloc, jobGresMetric.partition)
+	emitGres(jc.qosGresAlloc, jobGresMetric.qos)
+
+	now := time.Now()
+	partitionPendingAges, accountPendingAges := parsePendingAgeMetrics(jobMetrics, now)
+	for partition, metric := range partitionPendingAges {
+		ch <- metric.constHistogram(jc.partitionPendingAge, partition)
+		ch <- prometheus.MustNewConstMetric(jc.partitionOldestPending, prometheus.GaugeValue, metric.max, partition)
+	}
+	for account, metric := range accountPendingAges {
+		ch <- metric.constHistogram(jc.accountPendingAge, account)
+	}
+
+	partitionTimeLimits, accountTimeLimits := parseTimeLimitMetrics(jobMetrics, now, jc.timeLimitWarning)
+	for partition, metric := range partitionTimeLimits {
+		ch <- prometheus.MustNewConstMetric(jc.partitionNearTimeLimit, prometheus.GaugeValue, metric.nearLimit, partition)
+		ch <- metric.usedRatio.constHistogram(jc.partitionWalltimeRatio, partition)
+	}
+	for account, metric := range accountTimeLimits {
+		ch <- prometheus.MustNewConstMetric(jc.accountNearTimeLimit, prometheus.GaugeValue, metric.nearLimit, account)
+		ch <- metric.usedRatio.constHistogram(jc.accountWalltimeRatio, account)
+	}
+
+	arrayMetric := parseArrayMetrics(jobMetrics)
+	ch <- prometheus.MustNewConstMetric(jc.arrayActive, prometheus.GaugeValue, arrayMetric.active)
+	for user, count := range arrayMetric.userActive {
+		ch <- prometheus.MustNewConstMetric(jc.userArrayActive, prometheus.GaugeValue, count, user)
+	}
+	for user, count := range arrayMetric.userPendingTasks {
+		if count > 0 {
+			ch <- prometheus.MustNewConstMetric(jc.userArrayPendingTasks, prometheus.GaugeValue, count, user)
+		}
+	}
+	if !jc.observedByFetcher {
+		jc.observeJobs(perJobMetrics)
+	}
+	jc.arrayTasks.finished.Collect(ch)
+	jc.transitions.transitions.Collect(ch)
+
+	if jc.nodeJobsEnabled {
+		for node, metric := range parseNodeJobMetrics(jobMetrics) {
+			ch <- prometheus.MustNewConstMetric(jc.nodeJobCount, prometheus.GaugeValue, metric.jobs, node)
+			ch <- prometheus.MustNewConstMetric(jc.nodeJobCpuAlloc, prometheus.GaugeValue, metric.allocCpu, node)
+			ch <- prometheus.MustNewConstMetric(jc.nodeJobMemAlloc, prometheus.GaugeValue, metric.allocMem, node)
+		}
+		for node, count := range parseUnavailableNodeMetrics(jobMetrics) {
+			ch <- prometheus.MustNewConstMetric(jc.nodeUnavailablePending, prometheus.GaugeValue, count, node)
+		}
+	}
+
+	if !jc.jobMetricsEnabled {
+		return
+	}
+	for _, job := range filterJobMetrics(perJobMetrics, jc.jobMetricsStates, jc.jobMetricsMax) {
+		jobid := fmt.Sprint(int64(job.JobId))
+		ch <- prometheus.MustNewConstMetric(jc.jobAllocCpus, prometheus.GaugeValue, job.JobResources.AllocCpus, jobid, job.UserName, job.Account, job.Partition)
+		ch <- prometheus.MustNewConstMetric(jc.jobAllocMem, prometheus.GaugeValue, totalAllocMem(&job.JobResources), jobid, job.UserName, job.Account, job.Partition)
+		if limit, elapsed, remaining, ok := jobWalltime(&job, now); ok {
+			ch <- prometheus.MustNewConstMetric(jc.jobTimeLimit, prometheus.GaugeValue, limit, jobid, job.UserName, job.Account, job.Partition)
+			ch <- prometheus.MustNewConstMetric(jc.jobElapsed, prometheus.GaugeValue, elapsed, jobid, job.UserName, job.Account, job.Partition)
+			ch <- prometheus.MustNewConstMetric(jc.jobRemaining, prometheus.GaugeValue, remaining, jobid, job.UserName, job.Account, job.Partition)
+		}
+	}
 }
@@ -5,6 +5,10 @@
 package exporter
 
 import (
+	"context"
+	"encoding/json"
+	"os"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -32,7 +36,7 @@ func TestNewJobsController(t *testing.T) {
 			sharedFetcher: &JobCliFallbackFetcher{
 				scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
 				cache:      NewAtomicThrottledCache[JobMetric](1),
-				errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+				errCounter: newMockErrorCounter(),
 			},
 		},
 		cliOpts: &CliOpts{
@@ -49,9 +53,9 @@ func TestParseJobMetrics(t *testing.T) {
 	fetcher := &JobJsonFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+		errCounter: newMockErrorCounter(),
 	}
-	jms, err := fetcher.fetch()
+	jms, err := fetcher.fetch(context.Background())
 	assert.NoError(err)
 	// test parse of single job
 	var job *JobMetric
@@ -70,9 +74,9 @@ func TestParseCliFallback(t *testing.T) {
 	cliFallbackFetcher := &JobCliFallbackFetcher{
 		scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: newMockErrorCounter(),
 	}
-	metrics, err := cliFallbackFetcher.fetch()
+	metrics, err := cliFallbackFetcher.fetch(context.Background())
 	assert.Nil(err)
 	assert.NotEmpty(metrics)
 	nodeAvailMetricsCount := 0
@@ -82,7 +86,7 @@ func TestParseCliFallback(t *testing.T) {
 		}
 	}
 	assert.Equal(1, nodeAvailMetricsCount)
-	assert.Equal(2., CollectCounterValue(cliFallbackFetcher.errCounter))
+	assert.Equal(2., CollectCounterValue(cliFallbackFetcher.errCounter.WithLabelValues(reasonParse)))
 }
 
 func TestUserJobMetric(t *testing.T) {
@@ -92,9 +96,9 @@ func TestUserJobMetric(t *testing.T) {
 	fetcher := &JobJsonFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+		errCounter: newMockErrorCounter(),
 	}
-	jms, err := fetcher.fetch()
+	jms, err := fetcher.fetch(context.Background())
 	assert.Nil(err)
 
 	//test
@@ -121,7 +125,7 @@ func TestJobCollect(t *testing.T) {
 			sharedFetcher: &JobJsonFetcher{
 				scraper:    &MockScraper{fixture: "fixtures/squeue_out.json"},
 				cache:      NewAtomicThrottledCache[JobMetric](1),
-				errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+				errCounter: newMockErrorCounter(),
 			},
 			rate: 10,
 		},
@@ -149,7 +153,7 @@ func TestJobCollect_Fallback(t *testing.T) {
 			sharedFetcher: &JobCliFallbackFetcher{
 				scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
 				cache:      NewAtomicThrottledCache[JobMetric](1),
-				errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+				errCounter: newMockErrorCounter(),
 			},
 			rate: 10,
 		},
@@ -172,15 +176,409 @@ func TestJobCollect_Fallback(t *testing.T) {
 
 }
 
+func TestParsePendingAgeMetrics(t *testing.T) {
+	assert := assert.New(t)
+	cliFallbackFetcher := &JobCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](100),
+		errCounter: newMockErrorCounter(),
+	}
+	jobs, err := cliFallbackFetcher.fetch(context.Background())
+	assert.NoError(err)
+	now := time.Date(2023, 9, 21, 12, 0, 0, 0, time.Local)
+	partitions, accounts := parsePendingAgeMetrics(jobs, now)
+	// running jobs are excluded
+	assert.NotContains(partitions, "hw-l")
+	hwh := partitions["hw-h"]
+	assert.Equal(uint64(3), hwh.count)
+	assert.Equal(2*3600.+3600+1800, hwh.sum)
+	assert.Equal(7200., hwh.max)
+	assert.Equal(uint64(0), hwh.buckets[900])
+	assert.Equal(uint64(1), hwh.buckets[1800])
+	assert.Equal(uint64(3), hwh.buckets[3*3600])
+	assert.Equal(48*3600., partitions["magma"].max)
+	assert.Equal(uint64(4), accounts["account1"].count)
+}
+
+func TestParsePendingAgeMetrics_Json(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_2405.json"},
+		cache:      NewAtomicThrottledCache[JobMetric](100),
+		errCounter: newMockErrorCounter(),
+	}
+	jobs, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	partitions, _ := parsePendingAgeMetrics(jobs, time.Unix(1718200590, 0))
+	assert.Equal(600., partitions["magma"].max)
+	// submit times in the future are clamped to 0
+	partitions, _ = parsePendingAgeMetrics(jobs, time.Unix(0, 0))
+	assert.Equal(0., partitions["magma"].max)
+}
+
+func TestNAbleDurationJson(t *testing.T) {
+	assert := assert.New(t)
+	for data, expected := range map[string]time.Duration{
+		`"1-00:00:00"`: 24 * time.Hour,
+		`"2-03:04:05"`: 51*time.Hour + 4*time.Minute + 5*time.Second,
+		`"21:45:00"`:   21*time.Hour + 45*time.Minute,
+		`"30:00"`:      30 * time.Minute,
+		`"60"`:         time.Hour,
+		`"UNLIMITED"`:  0,
+		`"N/A"`:        0,
+	} {
+		var nad NAbleDuration
+		assert.NoError(nad.UnmarshalJSON([]byte(data)), data)
+		assert.Equal(expected, nad.Duration, data)
+	}
+	var nad NAbleDuration
+	assert.Error(nad.UnmarshalJSON([]byte(`"1:2:3:4"`)))
+	assert.Error(nad.UnmarshalJSON([]byte(`"x-01:00:00"`)))
+}
+
+func TestParseTimeLimitMetrics(t *testing.T) {
+	assert := assert.New(t)
+	cliFallbackFetcher := &JobCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](100),
+		errCounter: newMockErrorCounter(),
+	}
+	jobs, err := cliFallbackFetcher.fetch(context.Background())
+	assert.NoError(err)
+	now := time.Date(2023, 9, 21, 12, 0, 0, 0, time.Local)
+	partitions, accounts := parseTimeLimitMetrics(jobs, now, 30*time.Minute)
+	// pending jobs have no walltime
+	assert.NotContains(partitions, "magma")
+	assert.Zero(partitions["hw-h"].nearLimit)
+	assert.Equal(uint64(1), partitions["hw-h"].usedRatio.buckets[.75])
+	assert.Equal(uint64(0), partitions["hw-h"].usedRatio.buckets[.5])
+	// 16 minutes left of 21h45m
+	assert.Equal(1., partitions["hw-l"].nearLimit)
+	assert.Equal(uint64(0), partitions["hw-l"].usedRatio.buckets[.95])
+	assert.Equal(uint64(1), partitions["hw-l"].usedRatio.buckets[1])
+	assert.Equal(uint64(2), accounts["account1"].usedRatio.count)
+	assert.Equal(1., accounts["account1"].nearLimit)
+
+	limit, elapsed, remaining, ok := jobWalltime(&jobs[1], now)
+	assert.True(ok)
+	assert.Equal(78300., limit)
+	assert.Equal(77329., elapsed)
+	assert.Equal(971., remaining)
+}
+
+func TestParseTimeLimitMetrics_Json(t *testing.T) {
+	assert := assert.New(t)
+	for _, fixture := range []string{"fixtures/squeue_out.json", "fixtures/squeue_2311.json"} {
+		fetcher := &JobJsonFetcher{
+			scraper:    &MockScraper{fixture: fixture},
+			cache:      NewAtomicThrottledCache[JobMetric](100),
+			errCounter: newMockErrorCounter(),
+		}
+		jobs, err := fetcher.fetch(context.Background())
+		assert.NoError(err)
+		var running JobMetric
+		for _, job := range jobs {
+			if job.JobId == 26515966 {
+				running = job
+			}
+		}
+		assert.Positive(float64(running.TimeLimit), fixture)
+		assert.Positive(float64(running.StartTime), fixture)
+		partitions, _ := parseTimeLimitMetrics(jobs, time.Unix(int64(running.StartTime), 0), time.Minute)
+		assert.Positive(partitions[running.Partition].usedRatio.count, fixture)
+		assert.Zero(partitions[running.Partition].usedRatio.max, fixture)
+	}
+}
+
+func TestParseJobGresMetrics(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_2311.json"},
+		cache:      NewAtomicThrottledCache[JobMetric](100),
+		errCounter: newMockErrorCounter(),
+	}
+	jobs, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	metric := parseJobGresMetrics(jobs)
+	// the pending job's gres request is ignored
+	assert.Equal(map[string]map[string]float64{"gpu:a100": {"user1": 2}}, metric.user)
+	assert.Equal(map[string]map[string]float64{"gpu:a100": {"account1": 2}}, metric.account)
+	assert.Equal(map[string]map[string]float64{"gpu:a100": {"hw": 2}}, metric.partition)
+}
+
+func TestParseJobGresMetrics_Fallback(t *testing.T) {
+	assert := assert.New(t)
+	cliFallbackFetcher := &JobCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](100),
+		errCounter: newMockErrorCounter(),
+	}
+	jobs, err := cliFallbackFetcher.fetch(context.Background())
+	assert.NoError(err)
+	metric := parseJobGresMetrics(jobs)
+	// gres per node is scaled by the node count
+	assert.Equal(map[string]map[string]float64{"gpu:a100": {"hw-h": 4}}, metric.partition)
+	assert.Equal(map[string]map[string]float64{"gpu:a100": {"account1": 4}}, metric.account)
+}
+
+func TestParseQosMetrics_Fallback(t *testing.T) {
+	assert := assert.New(t)
+	cliFallbackFetcher := &JobCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](100),
+		errCounter: newMockErrorCounter(),
+	}
+	jobs, err := cliFallbackFetcher.fetch(context.Background())
+	assert.NoError(err)
+	qosMetrics := parseQosMetrics(jobs)
+	assert.Equal(map[string]float64{"RUNNING": 1, "PENDING": 3}, qosMetrics["high"].stateJobCount)
+	// the 2 pending tasks of array 60000 count as one job
+	assert.Equal(map[string]float64{"RUNNING": 2, "PENDING": 2}, qosMetrics["normal"].stateJobCount)
+	assert.Equal(map[string]map[string]float64{"gpu:a100": {"high": 4}}, parseJobGresMetrics(jobs).qos)
+	accountQosMetrics := parseAccountQosMetrics(jobs)
+	assert.Equal(map[string]float64{"RUNNING": 1, "PENDING": 1}, accountQosMetrics["account1"]["normal"].stateJobCount)
+	assert.Equal(map[string]float64{"RUNNING": 1, "PENDING": 1}, accountQosMetrics["account2"]["normal"].stateJobCount)
+}
+
+func TestJobCollect_AccountQosLabels(t *testing.T) {
+	assert := assert.New(t)
+	config := &Config{
+		TraceConf: &TraceConfig{
+			sharedFetcher: &JobCliFallbackFetcher{
+				scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
+				cache:      NewAtomicThrottledCache[JobMetric](1),
+				errCounter: newMockErrorCounter(),
+			},
+		},
+		cliOpts: &CliOpts{fallback: true, accountQosLabels: true},
+	}
+	jc := NewJobsController(config)
+	jobChan := make(chan prometheus.Metric)
+	go func() {
+		jc.Collect(jobChan)
+		close(jobChan)
+	}()
+	accountTotals := make(map[string]float64)
+	for metric := range jobChan {
+		if !strings.Contains(metric.Desc().String(), "slurm_account_job_state_total") {
+			continue
+		}
+		dtoMetric := new(dto.Metric)
+		assert.NoError(metric.Write(dtoMetric))
+		labels := make(map[string]string)
+		for _, label := range dtoMetric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		accountTotals[labels["account"]+"/"+labels["qos"]+"/"+labels["state"]] = dtoMetric.GetGauge().GetValue()
+	}
+	assert.Equal(3., accountTotals["account1/high/PENDING"])
+	assert.Equal(1., accountTotals["account1/normal/PENDING"])
+}
+
+func TestFilterJobMetrics(t *testing.T) {
+	assert := assert.New(t)
+	jobs := []JobMetric{
+		{JobId: 3, JobState: "RUNNING"},
+		{JobId: 1, JobState: "RUNNING"},
+		{JobId: 2, JobState: "PENDING"},
+		{JobId: 4, JobState: "RUNNING"},
+	}
+	assert.Len(filterJobMetrics(jobs, []string{"RUNNING"}, 10), 3)
+	assert.Len(filterJobMetrics(jobs, []string{"RUNNING", "PENDING"}, 10), 4)
+	// the lowest job ids are kept when capped
+	capped := filterJobMetrics(jobs, []string{"RUNNING"}, 2)
+	assert.Equal([]float64{1, 3}, []float64{capped[0].JobId, capped[1].JobId})
+}
+
+func TestJobCollect_JobMetrics(t *testing.T) {
+	assert := assert.New(t)
+	newCollector := func(enabled bool) *JobsCollector {
+		config := &Config{
+			TraceConf: &TraceConfig{
+				sharedFetcher: &JobCliFallbackFetcher{
+					scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
+					cache:      NewAtomicThrottledCache[JobMetric](1),
+					errCounter: newMockErrorCounter(),
+				},
+			},
+			cliOpts: &CliOpts{
+				fallback:          true,
+				jobMetricsEnabled: enabled,
+				jobMetricsMax:     5000,
+				jobMetricsStates:  []string{"RUNNING"},
+			},
+		}
+		return NewJobsController(config)
+	}
+	collect := func(jc *JobsCollector) map[string]*dto.Metric {
+		jobChan := make(chan prometheus.Metric)
+		go func() {
+			jc.Collect(jobChan)
+			close(jobChan)
+		}()
+		jobMetrics := make(map[string]*dto.Metric)
+		for metric := range jobChan {
+			if !strings.Contains(metric.Desc().String(), "slurm_job_alloc_cpus") {
+				continue
+			}
+			dtoMetric := new(dto.Metric)
+			assert.NoError(metric.Write(dtoMetric))
+			for _, label := range dtoMetric.GetLabel() {
+				if label.GetName() == "jobid" {
+					jobMetrics[label.GetValue()] = dtoMetric
+				}
+			}
+		}
+		return jobMetrics
+	}
+	assert.Empty(collect(newCollector(false)))
+	jobMetrics := collect(newCollector(true))
+	assert.Contains(jobMetrics, "26515966")
+	// pending jobs are filtered out by default
+	assert.NotContains(jobMetrics, "51447051")
+	labels := make(map[string]string)
+	for _, label := range jobMetrics["26515966"].GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	assert.Equal(map[string]string{"jobid": "26515966", "user": "", "account": "account1", "partition": "hw-h"}, labels)
+	assert.Equal(1., jobMetrics["26515966"].GetGauge().GetValue())
+}
+
+func TestParseNodeJobMetrics(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: newMockErrorCounter(),
+	}
+	jobMetrics, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	nodeMetrics := parseNodeJobMetrics(jobMetrics)
+	assert.Len(nodeMetrics, 2)
+	// job 26515966 is split between cs10 and cs11
+	assert.Equal(2., nodeMetrics["cs10"].jobs)
+	assert.Equal(1.5, nodeMetrics["cs10"].allocCpu)
+	assert.Equal(2., nodeMetrics["cs11"].jobs)
+	assert.Equal(1.5, nodeMetrics["cs11"].allocCpu)
+	jobMem := totalAllocMem(&jobMetrics[0].JobResources)
+	assert.Equal(jobMem/2+totalAllocMem(&jobMetrics[1].JobResources), nodeMetrics["cs10"].allocMem)
+}
+
+func TestParseNodeJobMetrics_States(t *testing.T) {
+	assert := assert.New(t)
+	jobs := []JobMetric{
+		{JobId: 1, JobState: "RUNNING", Nodes: "cs10", JobResources: JobResource{AllocCpus: 2}},
+		{JobId: 2, JobState: "COMPLETED", Nodes: "cs10", JobResources: JobResource{AllocCpus: 4}},
+		{JobId: 3, JobState: "CANCELLED", Nodes: "cs11", JobResources: JobResource{AllocCpus: 4}},
+		{JobId: 4, JobState: "SUSPENDED", Nodes: "cs10", JobResources: JobResource{AllocCpus: 1}},
+	}
+	nodeMetrics := parseNodeJobMetrics(jobs)
+	// finished jobs have released their nodes
+	assert.Len(nodeMetrics, 1)
+	assert.Equal(2., nodeMetrics["cs10"].jobs)
+	assert.Equal(3., nodeMetrics["cs10"].allocCpu)
+}
+
+func TestParseNodeJobMetrics_NodeAllocs(t *testing.T) {
+	assert := assert.New(t)
+	jobs := []JobMetric{{
+		JobId:        1,
+		JobState:     "RUNNING",
+		Nodes:        "cs[10-11]",
+		JobResources: JobResource{AllocCpus: 4},
+		NodeAllocs:   map[string]NodeResource{"cs10": {Cpus: 3, Mem: 3e9}, "cs11": {Cpus: 1, Mem: 1e9}},
+	}}
+	nodeMetrics := parseNodeJobMetrics(jobs)
+	assert.Equal(3., nodeMetrics["cs10"].allocCpu)
+	assert.Equal(3e9, nodeMetrics["cs10"].allocMem)
+	assert.Equal(1., nodeMetrics["cs11"].allocCpu)
+	assert.Equal(1e9, nodeMetrics["cs11"].allocMem)
+}
+
+func TestParseJobMetrics_NodeAllocs(t *testing.T) {
+	for fixture, expected := range map[string]NodeResource{
+		"fixtures/squeue_out.json":  {Cpus: 1, Mem: 6.4e13},
+		"fixtures/squeue_2311.json": {Cpus: 4, Mem: 6.4e10},
+		"fixtures/squeue_2405.json": {Cpus: 4, Mem: 6.4e10},
+	} {
+		t.Run(fixture, func(t *testing.T) {
+			assert := assert.New(t)
+			data, err := os.ReadFile(fixture)
+			assert.NoError(err)
+			jobs, err := parseJobMetrics(data)
+			assert.NoError(err)
+			idx := slices.IndexFunc(jobs, func(job JobMetric) bool { return job.Nodes == "cs75" && job.JobState == "RUNNING" })
+			assert.GreaterOrEqual(idx, 0)
+			assert.Equal(map[string]NodeResource{"cs75": expected}, jobs[idx].NodeAllocs)
+		})
+	}
+}
+
+func TestDataParserJobResources_UnknownShape(t *testing.T) {
+	assert := assert.New(t)
+	var resources dataParserJobResources
+	assert.NoError(json.Unmarshal([]byte(`{"nodes": 5, "allocated_nodes": "cs75"}`), &resources))
+	assert.Nil(resources.nodeAllocs)
+}
+
+func TestParseUnavailableNodeMetrics(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: newMockErrorCounter(),
+	}
+	jobMetrics, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	assert.Equal(map[string]float64{"cs100": 1, "cs101": 1, "cs102": 1}, parseUnavailableNodeMetrics(jobMetrics))
+	// squeue json reports the reason without parentheses
+	jobMetrics = []JobMetric{{JobState: "PENDING", StateReason: "ReqNodeNotAvail, UnavailableNodes:gpu[01-02]"}}
+	assert.Equal(map[string]float64{"gpu01": 1, "gpu02": 1}, parseUnavailableNodeMetrics(jobMetrics))
+}
+
+func TestJobCollect_NodeJobs(t *testing.T) {
+	assert := assert.New(t)
+	collect := func(enabled bool) map[string]float64 {
+		config := &Config{
+			TraceConf: &TraceConfig{
+				sharedFetcher: &JobCliFallbackFetcher{
+					scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
+					cache:      NewAtomicThrottledCache[JobMetric](1),
+					errCounter: newMockErrorCounter(),
+				},
+			},
+			cliOpts: &CliOpts{fallback: true, nodeJobsEnabled: enabled},
+		}
+		jc := NewJobsController(config)
+		jobChan := make(chan prometheus.Metric)
+		go func() {
+			jc.Collect(jobChan)
+			close(jobChan)
+		}()
+		nodeJobs := make(map[string]float64)
+		for metric := range jobChan {
+			if !strings.Contains(metric.Desc().String(), "slurm_node_job_count") {
+				continue
+			}
+			dtoMetric := new(dto.Metric)
+			assert.NoError(metric.Write(dtoMetric))
+			nodeJobs[dtoMetric.GetLabel()[0].GetValue()] = dtoMetric.GetGauge().GetValue()
+		}
+		return nodeJobs
+	}
+	assert.Empty(collect(false))
+	assert.Equal(map[string]float64{"cs10": 2, "cs11": 2}, collect(true))
+}
+
 func TestParsePartitionJobMetrics(t *testing.T) {
 	assert := assert.New(t)
 	scraper := &MockScraper{fixture: "fixtures/squeue_out.json"}
 	fetcher := &JobJsonFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+		errCounter: newMockErrorCounter(),
 	}
-	jms, err := fetcher.fetch()
+	jms, err := fetcher.fetch(context.Background())
 	assert.Nil(err)
 
 	partitionJobMetrics := parsePartitionJobMetrics(jms)
@@ -193,9 +591,9 @@ func TestParsePartMetrics(t *testing.T) {
 	fetcher := &JobJsonFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+		errCounter: newMockErrorCounter(),
 	}
-	jms, err := fetcher.fetch()
+	jms, err := fetcher.fetch(context.Background())
 	assert.Nil(err)
 
 	featureMetrics := parseFeatureMetric(jms)
@@ -211,7 +609,7 @@ func TestJobDescribe(t *testing.T) {
 	config.TraceConf.sharedFetcher = &JobJsonFetcher{
 		scraper:    MockJobInfoScraper,
 		cache:      NewAtomicThrottledCache[JobMetric](1),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+		errCounter: newMockErrorCounter(),
 	}
 	config.TraceConf.rate = 10
 	jc := NewJobsController(config)
@@ -232,7 +630,18 @@ func TestNAbleTimeJson(t *testing.T) {
 	var nat NAbleTime
 	err := nat.UnmarshalJSON([]byte(data))
 	assert.Nil(err)
-	assert.True(nat.Equal(time.Date(2023, 9, 21, 14, 31, 11, 0, time.UTC)))
+	assert.True(nat.Equal(time.Date(2023, 9, 21, 14, 31, 11, 0, time.Local)))
+}
+
+func TestNAbleTimeJson_Local(t *testing.T) {
+	assert := assert.New(t)
+	local := time.Local
+	t.Cleanup(func() { time.Local = local })
+	time.Local = time.FixedZone("PDT", -7*3600)
+	var nat NAbleTime
+	err := nat.UnmarshalJSON([]byte(`"2023-09-21T14:31:11"`))
+	assert.NoError(err)
+	assert.Equal(time.Date(2023, 9, 21, 21, 31, 11, 0, time.UTC).Unix(), nat.Unix())
 }
 
 func TestNAbleTimeJson_NA(t *testing.T) {
@@ -250,18 +659,18 @@ func TestParseCliFallbackEmpty(t *testing.T) {
 	cliFallbackFetcher := &JobCliFallbackFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: newMockErrorCounter(),
 	}
-	metrics, err := cliFallbackFetcher.fetch()
+	metrics, err := cliFallbackFetcher.fetch(context.Background())
 	assert.NoError(err)
 	assert.Empty(metrics)
-	assert.Zero(CollectCounterValue(cliFallbackFetcher.errCounter))
+	assert.Zero(CollectCounterValue(cliFallbackFetcher.errCounter.WithLabelValues(reasonParse)))
 	assert.Equal(1, scraper.Callcount)
 	scraper.msg = "\n"
-	metrics, err = cliFallbackFetcher.fetch()
+	metrics, err = cliFallbackFetcher.fetch(context.Background())
 	assert.NoError(err)
 	assert.Empty(metrics)
-	assert.Zero(CollectCounterValue(cliFallbackFetcher.errCounter))
+	assert.Zero(CollectCounterValue(cliFallbackFetcher.errCounter.WithLabelValues(reasonParse)))
 	assert.Equal(2, scraper.Callcount)
 }
 
@@ -271,13 +680,13 @@ func TestCliJobFetcherCacheHit(t *testing.T) {
 	cliFallbackFetcher := &JobCliFallbackFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: newMockErrorCounter(),
 	}
-	metrics, err := cliFallbackFetcher.FetchMetrics()
+	metrics, err := cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	assert.Equal(1, scraper.CallCount)
-	metrics, err = cliFallbackFetcher.FetchMetrics()
+	metrics, err = cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	// assert cache hit
@@ -290,13 +699,13 @@ func TestCliJobFetcherCacheMiss(t *testing.T) {
 	cliFallbackFetcher := &JobCliFallbackFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](0),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: newMockErrorCounter(),
 	}
-	metrics, err := cliFallbackFetcher.FetchMetrics()
+	metrics, err := cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	assert.Equal(1, scraper.CallCount)
-	metrics, err = cliFallbackFetcher.FetchMetrics()
+	metrics, err = cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	// assert cache hit
@@ -309,13 +718,13 @@ func TestJsonJobFetcherCacheHit(t *testing.T) {
 	cliFallbackFetcher := &JobJsonFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: newMockErrorCounter(),
 	}
-	metrics, err := cliFallbackFetcher.FetchMetrics()
+	metrics, err := cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	assert.Equal(1, scraper.CallCount)
-	metrics, err = cliFallbackFetcher.FetchMetrics()
+	metrics, err = cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	// assert cache hit
@@ -328,13 +737,13 @@ func TestJsonJobFetcherCacheMiss(t *testing.T) {
 	cliFallbackFetcher := &JobJsonFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](0),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: newMockErrorCounter(),
 	}
-	metrics, err := cliFallbackFetcher.FetchMetrics()
+	metrics, err := cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	assert.Equal(1, scraper.CallCount)
-	metrics, err = cliFallbackFetcher.FetchMetrics()
+	metrics, err = cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	// assert cache hit
@@ -347,15 +756,24 @@ func TestParseStateReasonMetric_Fallback(t *testing.T) {
 	cliFallbackFetcher := &JobCliFallbackFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](0),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: newMockErrorCounter(),
 	}
-	jobMetrics, err := cliFallbackFetcher.FetchMetrics()
+	jobMetrics, err := cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(jobMetrics)
 	assert.NoError(err)
-	m := parseStateReasonMetric(jobMetrics)
+	normalizer, err := NewReasonNormalizer(nil)
+	assert.NoError(err)
+	m := parseStateReasonMetric(jobMetrics, normalizer)
 	assert.NotEmpty(m.pendingStateCount)
 	assert.Equal(m.pendingStateCount["Dependency"], 1.)
 	assert.Equal(m.pendingStateCount["Priority"], 1.)
+	assert.Equal(1., m.pendingStateCount["(ReqNodeNotAvail, UnavailableNodes)"])
+	assert.Equal(map[string]float64{
+		"Dependency":                          1,
+		"(ReqNodeNotAvail, UnavailableNodes)": 1,
+		"Nodes required for job are DOWN, DRAINED or reserved for jobs in higher priority partitions": 1,
+	}, m.partition["hw-h"])
+	assert.Equal(map[string]float64{"JobArrayTaskLimit": 2}, m.account["account2"])
 }
 func TestParseStateReasonMetric_Json(t *testing.T) {
 	assert := assert.New(t)
@@ -363,12 +781,51 @@ func TestParseStateReasonMetric_Json(t *testing.T) {
 	JsonFetcher := &JobJsonFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](0),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: newMockErrorCounter(),
 	}
-	jobMetrics, err := JsonFetcher.FetchMetrics()
+	jobMetrics, err := JsonFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(jobMetrics)
 	assert.NoError(err)
-	m := parseStateReasonMetric(jobMetrics)
+	normalizer, err := NewReasonNormalizer(nil)
+	assert.NoError(err)
+	m := parseStateReasonMetric(jobMetrics, normalizer)
 	assert.NotEmpty(m.pendingStateCount)
 	assert.Equal(m.pendingStateCount["Dependency"], 1.)
 }
+
+func TestParseJobMetrics_DataParser(t *testing.T) {
+	for _, fixture := range []string{"fixtures/squeue_2311.json", "fixtures/squeue_2405.json"} {
+		t.Run(fixture, func(t *testing.T) {
+			assert := assert.New(t)
+			fetcher := &JobJsonFetcher{
+				scraper:    &MockScraper{fixture: fixture},
+				cache:      NewAtomicThrottledCache[JobMetric](100),
+				errCounter: newMockErrorCounter(),
+			}
+			jms, err := fetcher.fetch(context.Background())
+			assert.NoError(err)
+			assert.Len(jms, 3)
+			jobs := make(map[float64]JobMetric)
+			for _, jm := range jms {
+				jobs[jm.JobId] = jm
+			}
+			running := jobs[26515966]
+			assert.Equal("RUNNING", running.JobState)
+			assert.Equal("user1", running.UserName)
+			assert.Equal("normal", running.Qos)
+			assert.Equal(4., running.JobResources.AllocCpus)
+			assert.Equal(6.4e10, totalAllocMem(&running.JobResources))
+			assert.Equal(1718203610., running.EndTime)
+			assert.Equal("cs75", running.Nodes)
+			// state flags are dropped and memory per cpu is scaled by cpus
+			completing := jobs[26515967]
+			assert.Equal("RUNNING", completing.JobState)
+			assert.Equal(2e9, totalAllocMem(&completing.JobResources))
+			pending := jobs[26515968]
+			assert.Equal("PENDING", pending.JobState)
+			assert.Equal("Priority", pending.StateReason)
+			assert.Equal(3.2e10, totalAllocMem(&pending.JobResources))
+			assert.Zero(CollectCounterValue(fetcher.errCounter.WithLabelValues(reasonParse)))
+		})
+	}
+}
@@ -4,6 +4,7 @@
 package exporter
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -33,36 +34,33 @@ type scontrolLicResponse struct {
 type CliJsonLicMetricFetcher struct {
 	scraper      SlurmByteScraper
 	cache        *AtomicThrottledCache[LicenseMetric]
-	errorCounter prometheus.Counter
+	errorCounter *prometheus.CounterVec
 }
 
-func (cjl *CliJsonLicMetricFetcher) fetch() ([]LicenseMetric, error) {
-	licBytes, err := cjl.scraper.FetchRawBytes()
+func (cjl *CliJsonLicMetricFetcher) fetch(ctx context.Context) ([]LicenseMetric, error) {
+	licBytes, err := cjl.scraper.FetchRawBytes(ctx)
 	if err != nil {
 		slog.Error(fmt.Sprintf("fetch error %q", err))
-		cjl.errorCounter.Inc()
+		observeCommandError(cjl.errorCounter, err)
 		return nil, err
 	}
 	lic := new(scontrolLicResponse)
 	if err := json.Unmarshal(licBytes, lic); err != nil {
 		slog.Error(fmt.Sprintf("Unmarshaling license metrics %q", err))
+		cjl.errorCounter.WithLabelValues(reasonParse).Inc()
 		return nil, err
 	}
 	return lic.Licenses, nil
 }
 
-func (cjl *CliJsonLicMetricFetcher) FetchMetrics() ([]LicenseMetric, error) {
-	return cjl.cache.FetchOrThrottle(cjl.fetch)
+func (cjl *CliJsonLicMetricFetcher) FetchMetrics(ctx context.Context) ([]LicenseMetric, error) {
+	return cjl.cache.FetchOrThrottle(func() ([]LicenseMetric, error) { return cjl.fetch(ctx) })
 }
 
 func (cjl *CliJsonLicMetricFetcher) ScrapeDuration() time.Duration {
 	return cjl.cache.duration
 }
 
-func (cjl *CliJsonLicMetricFetcher) ScrapeError() prometheus.Counter {
-	return cjl.errorCounter
-}
-
 type LicCollector struct {
 	fetcher         SlurmMetricFetcher[LicenseMetric]
 	licTotal        *prometheus.Desc
@@ -71,31 +69,23 @@ type LicCollector struct {
 	licReserved     *prometheus.Desc
 	licLastConsumed *prometheus.Desc
 	licLastDeficit  *prometheus.Desc
-	licScrapeError  prometheus.Counter
 }
 
 func NewLicCollector(config *Config) *LicCollector {
 	cliOpts := config.cliOpts
 	fetcher := &CliJsonLicMetricFetcher{
-		scraper: NewCliScraper(cliOpts.lic...),
-		cache:   NewAtomicThrottledCache[LicenseMetric](config.PollLimit),
-		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
-			Name: "slurm_lic_scrape_error",
-			Help: "slurm license scrape error",
-		}),
+		scraper:      cliOpts.newScraper(restLicenses, cliOpts.lic),
+		cache:        newConfiguredCache[LicenseMetric](config, "licenses"),
+		errorCounter: config.commandErrorCounter("scontrol"),
 	}
 	return &LicCollector{
 		fetcher:         fetcher,
-		licTotal:        prometheus.NewDesc("slurm_lic_total", "slurm license total", []string{"name"}, nil),
-		licUsed:         prometheus.NewDesc("slurm_lic_used", "slurm license used", []string{"name"}, nil),
-		licFree:         prometheus.NewDesc("slurm_lic_free", "slurm license free", []string{"name"}, nil),
-		licLastConsumed: prometheus.NewDesc("slurm_lic_last_consumed", "slurm license last_consumed", []string{"name"}, nil),
-		licLastDeficit:  prometheus.NewDesc("slurm_lic_last_deficit", "slurm license last_deficit", []string{"name"}, nil),
-		licReserved:     prometheus.NewDesc("slurm_lic_reserved", "slurm license reserved", []string{"name"}, nil),
-		licScrapeError: prometheus.NewCounter(prometheus.CounterOpts{
-			Name: "slurm_lic_scrape_error",
-			Help: "slurm license scrape error",
-		}),
+		licTotal:        prometheus.NewDesc("slurm_lic_total", "slurm license total", []string{"name"}, config.constLabels()),
+		licUsed:         prometheus.NewDesc("slurm_lic_used", "slurm license used", []string{"name"}, config.constLabels()),
+		licFree:         prometheus.NewDesc("slurm_lic_free", "slurm license free", []string{"name"}, config.constLabels()),
+		licLastConsumed: prometheus.NewDesc("slurm_lic_last_consumed", "slurm license last_consumed", []string{"name"}, config.constLabels()),
+		licLastDeficit:  prometheus.NewDesc("slurm_lic_last_deficit", "slurm license last_deficit", []string{"name"}, config.constLabels()),
+		licReserved:     prometheus.NewDesc("slurm_lic_reserved", "slurm license reserved", []string{"name"}, config.constLabels()),
 	}
 }
 
@@ -106,16 +96,15 @@ func (lc *LicCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- lc.licReserved
 	ch <- lc.licLastConsumed
 	ch <- lc.licLastDeficit
-	ch <- lc.licScrapeError.Desc()
 }
 
 func (lc *LicCollector) Collect(ch chan<- prometheus.Metric) {
-	defer func() {
-		ch <- lc.licScrapeError
-	}()
-	licMetrics, err := lc.fetcher.FetchMetrics()
+	lc.CollectWithContext(context.Background(), ch)
+}
+
+func (lc *LicCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	licMetrics, err := lc.fetcher.FetchMetrics(ctx)
 	if err != nil {
-		lc.licScrapeError.Inc()
 		slog.Error(fmt.Sprintf("lic parse error %q", err))
 		return
 	}
@@ -5,6 +5,7 @@ package exporter
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
@@ -28,14 +29,14 @@ type AccountLimitMetric struct {
 
 type AccountCsvFetcher struct {
 	scraper      SlurmByteScraper
-	errorCounter prometheus.Counter
+	errorCounter *prometheus.CounterVec
 	cache        *AtomicThrottledCache[AccountLimitMetric]
 }
 
-func (acf *AccountCsvFetcher) fetchFromCli() ([]AccountLimitMetric, error) {
-	cliCsv, err := acf.scraper.FetchRawBytes()
+func (acf *AccountCsvFetcher) fetchFromCli(ctx context.Context) ([]AccountLimitMetric, error) {
+	cliCsv, err := acf.scraper.FetchRawBytes(ctx)
 	if err != nil {
-		acf.errorCounter.Inc()
+		observeCommandError(acf.errorCounter, err)
 		slog.Error(fmt.Sprintf("failed to scrape account metrics with %q", err))
 		return nil, err
 	}
@@ -45,12 +46,12 @@ func (acf *AccountCsvFetcher) fetchFromCli() ([]AccountLimitMetric, error) {
 	accountMetrics := make([]AccountLimitMetric, 0)
 	for records, err := reader.Read(); err != io.EOF; records, err = reader.Read() {
 		if err != nil {
-			acf.errorCounter.Inc()
+			acf.errorCounter.WithLabelValues(reasonParse).Inc()
 			slog.Error(fmt.Sprintf("failed to scrape account metric row %v", records))
 			continue
 		}
 		if len(records) != 6 {
-			acf.errorCounter.Inc()
+			acf.errorCounter.WithLabelValues(reasonParse).Inc()
 			slog.Error(fmt.Sprintf("failed to scrape account metric row %v", records))
 			continue
 		}
@@ -66,7 +67,7 @@ func (acf *AccountCsvFetcher) fetchFromCli() ([]AccountLimitMetric, error) {
 		if mem != "" {
 			if memMb, err := strconv.ParseFloat(mem, 64); err != nil {
 				slog.Error(fmt.Sprintf("failed to scrape account metric mem string %s", mem))
-				acf.errorCounter.Inc()
+				acf.errorCounter.WithLabelValues(reasonParse).Inc()
 			} else {
 				metric.AllocatedMem = memMb * 1e6
 			}
@@ -74,7 +75,7 @@ func (acf *AccountCsvFetcher) fetchFromCli() ([]AccountLimitMetric, error) {
 		if cpu != "" {
 			if cpuCount, err := strconv.ParseFloat(cpu, 64); err != nil {
 				slog.Error(fmt.Sprintf("failed to scrape account metric cpu string %s", cpu))
-				acf.errorCounter.Inc()
+				acf.errorCounter.WithLabelValues(reasonParse).Inc()
 			} else {
 				metric.AllocatedCPU = cpuCount
 			}
@@ -82,7 +83,7 @@ func (acf *AccountCsvFetcher) fetchFromCli() ([]AccountLimitMetric, error) {
 		if runningJobs != "" {
 			if runnableJobs, err := strconv.ParseFloat(runningJobs, 64); err != nil {
 				slog.Error(fmt.Sprintf("failed to scrape account metric AllocatableJobs (jobs in RUNNING state) with err: %q", err))
-				acf.errorCounter.Inc()
+				acf.errorCounter.WithLabelValues(reasonParse).Inc()
 			} else {
 				metric.AllocatedJobs = runnableJobs
 			}
@@ -90,7 +91,7 @@ func (acf *AccountCsvFetcher) fetchFromCli() ([]AccountLimitMetric, error) {
 		if totalJobs != "" {
 			if allJobs, err := strconv.ParseFloat(totalJobs, 64); err != nil {
 				slog.Error(fmt.Sprintf("failed to scrape account metric TotalJobs (jobs in RUNNING or PENDING state) with err: %q", err))
-				acf.errorCounter.Inc()
+				acf.errorCounter.WithLabelValues(reasonParse).Inc()
 			} else {
 				metric.TotalJobs = allJobs
 			}
@@ -100,12 +101,8 @@ func (acf *AccountCsvFetcher) fetchFromCli() ([]AccountLimitMetric, error) {
 	return accountMetrics, nil
 }
 
-func (acf *AccountCsvFetcher) FetchMetrics() ([]AccountLimitMetric, error) {
-	return acf.cache.FetchOrThrottle(acf.fetchFromCli)
-}
-
-func (acf *AccountCsvFetcher) ScrapeError() prometheus.Counter {
-	return acf.errorCounter
+func (acf *AccountCsvFetcher) FetchMetrics(ctx context.Context) ([]AccountLimitMetric, error) {
+	return acf.cache.FetchOrThrottle(func() ([]AccountLimitMetric, error) { return acf.fetchFromCli(ctx) })
 }
 
 func (acf *AccountCsvFetcher) ScrapeDuration() time.Duration {
@@ -119,7 +116,8 @@ type LimitCollector struct {
 	accountJobAllocCountLimit *prometheus.Desc
 	accountJobCountLimit      *prometheus.Desc
 	limitScrapeDuration       *prometheus.Desc
-	limitScrapeError          prometheus.Counter
+	labelLimits               LabelLimiter
+	foldedValues              *prometheus.Desc
 }
 
 func NewLimitCollector(config *Config) *LimitCollector {
@@ -129,22 +127,17 @@ func NewLimitCollector(config *Config) *LimitCollector {
 	}
 	return &LimitCollector{
 		fetcher: &AccountCsvFetcher{
-			scraper: NewCliScraper(cliOpts.sacctmgr...),
-			cache:   NewAtomicThrottledCache[AccountLimitMetric](config.PollLimit),
-			errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
-				Name: "slurm_account_scrape_error",
-				Help: "Slurm sacct scrape error",
-			}),
+			scraper:      cliOpts.newCliScraper(cliOpts.sacctmgr),
+			cache:        newConfiguredCache[AccountLimitMetric](config, "limits"),
+			errorCounter: config.commandErrorCounter("sacctmgr"),
 		},
-		accountCpuLimit:           prometheus.NewDesc("slurm_account_cpu_limit", "slurm account cpu limit", []string{"account"}, nil),
-		accountMemLimit:           prometheus.NewDesc("slurm_account_mem_limit", "slurm account mem limit (in bytes)", []string{"account"}, nil),
-		accountJobAllocCountLimit: prometheus.NewDesc("slurm_account_job_alloc_limit", "slurm account limit on the # of jobs allowed to be RUNNING state", []string{"account"}, nil),
-		accountJobCountLimit:      prometheus.NewDesc("slurm_account_job_limit", "slurm account limit on the # of jobs allowed to be RUNNING or PENDING state", []string{"account"}, nil),
-		limitScrapeDuration:       prometheus.NewDesc("slurm_limit_scrape_duration", "slurm sacctmgr scrape duration", nil, nil),
-		limitScrapeError: prometheus.NewCounter(prometheus.CounterOpts{
-			Name: "slurm_account_collect_error",
-			Help: "Slurm sacct collect error",
-		}),
+		accountCpuLimit:           prometheus.NewDesc("slurm_account_cpu_limit", "slurm account cpu limit", []string{"account"}, config.constLabels()),
+		accountMemLimit:           prometheus.NewDesc("slurm_account_mem_limit", "slurm account mem limit (in bytes)", []string{"account"}, config.constLabels()),
+		accountJobAllocCountLimit: prometheus.NewDesc("slurm_account_job_alloc_limit", "slurm account limit on the # of jobs allowed to be RUNNING state", []string{"account"}, config.constLabels()),
+		accountJobCountLimit:      prometheus.NewDesc("slurm_account_job_limit", "slurm account limit on the # of jobs allowed to be RUNNING or PENDING state", []string{"account"}, config.constLabels()),
+		limitScrapeDuration:       prometheus.NewDesc("slurm_limit_scrape_duration", "slurm sacctmgr scrape duration", nil, config.constLabels()),
+		labelLimits:               cliOpts.labelLimits,
+		foldedValues:              newFoldedValuesDesc(config, "limits"),
 	}
 }
 
@@ -152,16 +145,40 @@ func (lc *LimitCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- lc.accountCpuLimit
 	ch <- lc.accountMemLimit
 	ch <- lc.limitScrapeDuration
-	ch <- lc.limitScrapeError.Desc()
+	ch <- lc.foldedValues
+}
+
+// limits can't be added up into other, so accounts past the account limit are dropped.
+// Accounts are ranked by their cpu, mem or job limit. Also returns how many accounts were dropped
+func (lc *LimitCollector) limitAccounts(accounts []AccountLimitMetric) ([]AccountLimitMetric, float64) {
+	weights := make(map[string]float64)
+	for _, account := range accounts {
+		weight := lc.labelLimits.weight(account.AllocatedCPU, account.AllocatedMem)
+		if lc.labelLimits.by == limitByJobs {
+			weight = account.TotalJobs
+		}
+		weights[account.Account] = max(weights[account.Account], weight)
+	}
+	kept := topLabelValues(weights, lc.labelLimits.maxAccounts)
+	if kept == nil {
+		return accounts, 0
+	}
+	limited := make([]AccountLimitMetric, 0, len(kept))
+	for _, account := range accounts {
+		if _, ok := kept[account.Account]; ok {
+			limited = append(limited, account)
+		}
+	}
+	return limited, float64(len(weights) - len(kept))
 }
 
 func (lc *LimitCollector) Collect(ch chan<- prometheus.Metric) {
-	defer func() {
-		ch <- lc.limitScrapeError
-	}()
-	limitMetrics, err := lc.fetcher.FetchMetrics()
+	lc.CollectWithContext(context.Background(), ch)
+}
+
+func (lc *LimitCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	limitMetrics, err := lc.fetcher.FetchMetrics(ctx)
 	if err != nil {
-		lc.limitScrapeError.Inc()
 		slog.Error(fmt.Sprintf("lic parse error %q", err))
 		return
 	}
@@ -172,6 +189,10 @@ func (lc *LimitCollector) Collect(ch chan<- prometheus.Metric) {
 		}
 	}
 	ch <- prometheus.MustNewConstMetric(lc.limitScrapeDuration, prometheus.GaugeValue, float64(lc.fetcher.ScrapeDuration().Milliseconds()))
+	limitMetrics, dropped := lc.limitAccounts(limitMetrics)
+	if lc.labelLimits.maxAccounts > 0 {
+		ch <- prometheus.MustNewConstMetric(lc.foldedValues, prometheus.GaugeValue, dropped, "account")
+	}
 	for _, account := range limitMetrics {
 		emitNonZeroVal(lc.accountMemLimit, account.AllocatedMem, account.Account)
 		emitNonZeroVal(lc.accountCpuLimit, account.AllocatedCPU, account.Account)
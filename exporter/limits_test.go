@@ -4,6 +4,7 @@
 package exporter
 
 import (
+	"context"
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -16,10 +17,10 @@ func TestAccountLimitFetch(t *testing.T) {
 	assert := assert.New(t)
 	fetcher := AccountCsvFetcher{
 		scraper:      MockSacctFetcher,
-		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+		errorCounter: newMockErrorCounter(),
 		cache:        NewAtomicThrottledCache[AccountLimitMetric](10),
 	}
-	accountLimits, err := fetcher.fetchFromCli()
+	accountLimits, err := fetcher.fetchFromCli(context.Background())
 	assert.NoError(err)
 	assert.Len(accountLimits, 6)
 	var account5Limits AccountLimitMetric
@@ -35,6 +36,26 @@ func TestAccountLimitFetch(t *testing.T) {
 	assert.Equal(account5Limits.TotalJobs, 3.e4)
 }
 
+func TestLimitAccounts(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := AccountCsvFetcher{
+		scraper:      MockSacctFetcher,
+		errorCounter: newMockErrorCounter(),
+		cache:        NewAtomicThrottledCache[AccountLimitMetric](10),
+	}
+	accountLimits, err := fetcher.fetchFromCli(context.Background())
+	assert.NoError(err)
+	lc := &LimitCollector{labelLimits: LabelLimiter{maxAccounts: 2, by: limitByCpus}}
+	limited, dropped := lc.limitAccounts(accountLimits)
+	assert.Equal(4., dropped)
+	// accounts with the largest cpu limits
+	for _, account := range limited {
+		assert.Contains([]string{"account4", "account5"}, account.Account)
+	}
+	limited, _ = (&LimitCollector{}).limitAccounts(accountLimits)
+	assert.Equal(accountLimits, limited)
+}
+
 func TestNewLimitCollector(t *testing.T) {
 	assert := assert.New(t)
 	config := Config{
@@ -58,7 +79,7 @@ func TestLimitCollector(t *testing.T) {
 	lc := NewLimitCollector(&config)
 	lc.fetcher = &AccountCsvFetcher{
 		scraper:      MockSacctFetcher,
-		errorCounter: lc.fetcher.ScrapeError(),
+		errorCounter: newMockErrorCounter(),
 		cache:        NewAtomicThrottledCache[AccountLimitMetric](10),
 	}
 	lcChan := make(chan prometheus.Metric)
@@ -84,7 +105,7 @@ func TestLimitDescribe(t *testing.T) {
 	lc := NewLimitCollector(&config)
 	lc.fetcher = &AccountCsvFetcher{
 		scraper:      MockSacctFetcher,
-		errorCounter: lc.fetcher.ScrapeError(),
+		errorCounter: newMockErrorCounter(),
 		cache:        NewAtomicThrottledCache[AccountLimitMetric](10),
 	}
 	lcChan := make(chan *prometheus.Desc)
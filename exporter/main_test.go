@@ -11,10 +11,10 @@ import (
 	"os"
 	"regexp"
 	"testing"
+	"time"
 
 	"log/slog"
 
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -42,12 +42,9 @@ func TestPromServer(t *testing.T) {
 		TraceConf: &TraceConfig{
 			enabled: false,
 			sharedFetcher: &JobJsonFetcher{
-				scraper: NewCliScraper(cliOpts.squeue...),
-				cache:   NewAtomicThrottledCache[JobMetric](1),
-				errCounter: prometheus.NewCounter(prometheus.CounterOpts{
-					Name: "slurm_job_scrape_error",
-					Help: "job scrape error",
-				}),
+				scraper:    NewCliScraper(cliOpts.squeue...),
+				cache:      NewAtomicThrottledCache[JobMetric](1),
+				errCounter: newMockErrorCounter(),
 			},
 		},
 	}
@@ -57,8 +54,45 @@ func TestPromServer(t *testing.T) {
 	server.ServeHTTP(w, r)
 	assert.Equal(200, w.Code)
 	txt := w.Body.String()
-	assert.Contains(txt, "slurm_job_scrape_error 0")
-	assert.Contains(txt, "slurm_node_scrape_error 0")
+	assert.Contains(txt, "slurm_job_scrape_duration")
+	assert.Contains(txt, "slurm_node_scrape_duration")
+	assert.NotContains(txt, "slurm_exporter_command_errors_total")
+}
+
+func TestScrapeContext(t *testing.T) {
+	assert := assert.New(t)
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+	_, ok := ctx.Deadline()
+	assert.False(ok)
+	r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "10")
+	ctx, cancel = scrapeContext(r)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(ok)
+	assert.WithinDuration(time.Now().Add(10*time.Second-scrapeTimeoutOffset), deadline, time.Second)
+}
+
+func TestPromServer_ScrapeTimeout(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	collector := NewNodeCollecter(config)
+	collector.SetFetcher(&NodeJsonFetcher{
+		scraper:      NewCliScraper("sleep", "100"),
+		cache:        NewAtomicThrottledCache[NodeMetric](1),
+		errorCounter: newMockErrorCounter(),
+	})
+	server := NewPromHTTPServer(nil, collector)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.1")
+	start := time.Now()
+	server.ServeHTTP(w, r)
+	// the hung command is killed once the scrape deadline passes
+	assert.Less(time.Since(start), 10*time.Second)
+	assert.Equal(200, w.Code)
 }
 
 func TestNewConfig_Default(t *testing.T) {
@@ -76,7 +110,7 @@ func TestNewConfig_NonDefault(t *testing.T) {
 	cliFlags := CliFlags{SlurmCliFallback: true}
 	config, err := NewConfig(&cliFlags)
 	assert.Nil(err)
-	expected := []string{"squeue", "--states=all", "-h", "-r", "-o", `{"a": "%a", "id": %A, "end_time": "%e", "u": "%u", "state": "%T", "p": "%P", "cpu": %C, "mem": "%m", "array_id": "%K", "r": "%R"}`}
+	expected := []string{"squeue", "--states=all", "-h", "-r", "-o", `{"a": "%a", "id": %A, "end_time": "%e", "submit": "%V", "start": "%S", "limit": "%l", "u": "%u", "state": "%T", "p": "%P", "qos": "%q", "cpu": %C, "mem": "%m", "gres": "%b", "nodes": %D, "array_job_id": %F, "array_id": "%K", "n": "%N", "r": "%R"}`}
 	assert.Equal(expected, config.cliOpts.squeue)
 }
 
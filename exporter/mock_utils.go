@@ -6,14 +6,17 @@ package exporter
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"os"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type MockFetchErrored struct{}
 
-func (f *MockFetchErrored) FetchRawBytes() ([]byte, error) {
+func (f *MockFetchErrored) FetchRawBytes(ctx context.Context) ([]byte, error) {
 	return nil, errors.New("mock fetch error")
 }
 
@@ -29,7 +32,7 @@ type MockScraper struct {
 	CallCount int
 }
 
-func (f *MockScraper) FetchRawBytes() ([]byte, error) {
+func (f *MockScraper) FetchRawBytes(ctx context.Context) ([]byte, error) {
 	defer func(t time.Time) {
 		f.duration = time.Since(t)
 	}(time.Now())
@@ -61,7 +64,7 @@ type StringByteScraper struct {
 	Callcount int
 }
 
-func (es *StringByteScraper) FetchRawBytes() ([]byte, error) {
+func (es *StringByteScraper) FetchRawBytes(ctx context.Context) ([]byte, error) {
 	es.Callcount++
 	return []byte(es.msg), nil
 }
@@ -69,3 +72,8 @@ func (es *StringByteScraper) FetchRawBytes() ([]byte, error) {
 func (es *StringByteScraper) Duration() time.Duration {
 	return time.Duration(1)
 }
+
+// command error counter for fetchers under test
+func newMockErrorCounter() *prometheus.CounterVec {
+	return NewCommandErrorCounter(nil).MustCurryWith(prometheus.Labels{"command": "mock"})
+}
@@ -6,6 +6,7 @@ package exporter
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -30,55 +31,201 @@ type NodeMetric struct {
 	RealMemory  float64  `json:"real_memory"`
 	State       string   `json:"state"`
 	Weight      float64  `json:"weight"`
+	// raw gres strings i.e gpu:a100:4(S:0-1). GresUsed is empty when unknown
+	Gres     string `json:"gres"`
+	GresUsed string `json:"gres_used"`
+	// split from a sinfo record of several nodes, so the counts above are the record's average and maximums
+	grouped bool
 }
 
+// openapi/v0.0.37 schema
 type sinfoResponse struct {
-	Meta struct {
-		SlurmVersion struct {
-			Version struct {
-				Major int `json:"major"`
-				Micro int `json:"micro"`
-				Minor int `json:"minor"`
-			} `json:"version"`
-			Release string `json:"release"`
-		} `json:"Slurm"`
-	} `json:"meta"`
+	Meta   slurmMeta    `json:"meta"`
 	Errors []string     `json:"errors"`
 	Nodes  []NodeMetric `json:"nodes"`
 }
 
+// per node data_parser/v0.0.40+ schema, as served by slurmrestd
+type dataParserNode struct {
+	Hostname      string        `json:"hostname"`
+	State         []string      `json:"state"`
+	Cpus          OptionalFloat `json:"cpus"`
+	AllocCpus     OptionalFloat `json:"alloc_cpus"`
+	AllocIdleCpus OptionalFloat `json:"alloc_idle_cpus"`
+	AllocMemory   OptionalFloat `json:"alloc_memory"`
+	RealMemory    OptionalFloat `json:"real_memory"`
+	FreeMemory    OptionalFloat `json:"free_mem"`
+	CpuLoad       OptionalFloat `json:"cpu_load"`
+	Partitions    []string      `json:"partitions"`
+	Weight        OptionalFloat `json:"weight"`
+	Gres          string        `json:"gres"`
+	GresUsed      string        `json:"gres_used"`
+}
+
+// since slurm 23.11 sinfo groups nodes sharing a partition and state into a single record
+// cpu and allocated memory counts are summed over the record while min/max fields are per node
+type dataParserSinfo struct {
+	Node struct {
+		State []string `json:"state"`
+	} `json:"node"`
+	Nodes struct {
+		Nodes []string `json:"nodes"`
+	} `json:"nodes"`
+	Cpus struct {
+		Allocated OptionalFloat `json:"allocated"`
+		Idle      OptionalFloat `json:"idle"`
+		Total     OptionalFloat `json:"total"`
+		Load      struct {
+			Maximum OptionalFloat `json:"maximum"`
+		} `json:"load"`
+	} `json:"cpus"`
+	Memory struct {
+		Maximum   OptionalFloat `json:"maximum"`
+		Allocated OptionalFloat `json:"allocated"`
+		Free      struct {
+			Maximum OptionalFloat `json:"maximum"`
+		} `json:"free"`
+	} `json:"memory"`
+	Partition struct {
+		Name string `json:"name"`
+	} `json:"partition"`
+	Weight struct {
+		Maximum OptionalFloat `json:"maximum"`
+	} `json:"weight"`
+	// gres is reported per node
+	Gres struct {
+		Total string `json:"total"`
+		Used  string `json:"used"`
+	} `json:"gres"`
+}
+
+type dataParserSinfoResponse struct {
+	Meta   slurmMeta         `json:"meta"`
+	Errors []dataParserError `json:"errors"`
+	Nodes  []dataParserNode  `json:"nodes"`
+	Sinfo  []dataParserSinfo `json:"sinfo"`
+}
+
+// memory is reported in MB, convert to bytes like the cli fallback
+func (dpn *dataParserNode) nodeMetric() NodeMetric {
+	return NodeMetric{
+		Hostname:    dpn.Hostname,
+		State:       joinStates(dpn.State),
+		Cpus:        float64(dpn.Cpus),
+		AllocCpus:   float64(dpn.AllocCpus),
+		IdleCpus:    float64(dpn.AllocIdleCpus),
+		AllocMemory: float64(dpn.AllocMemory) * 1e6,
+		RealMemory:  float64(dpn.RealMemory) * 1e6,
+		FreeMemory:  float64(dpn.FreeMemory) * 1e6,
+		CpuLoad:     float64(dpn.CpuLoad),
+		Partitions:  dpn.Partitions,
+		Weight:      float64(dpn.Weight),
+		Gres:        dpn.Gres,
+		GresUsed:    dpn.GresUsed,
+	}
+}
+
+// split a grouped sinfo record back into nodes. Summed counts are shared evenly between the nodes of the record,
+// which keeps partition and cluster totals exact but isn't the real value of any one node
+func (dps *dataParserSinfo) nodeMetrics() []NodeMetric {
+	count := float64(len(dps.Nodes.Nodes))
+	nodeMetrics := make([]NodeMetric, 0, len(dps.Nodes.Nodes))
+	for _, hostname := range dps.Nodes.Nodes {
+		nodeMetrics = append(nodeMetrics, NodeMetric{
+			Hostname:    hostname,
+			State:       joinStates(dps.Node.State),
+			Cpus:        float64(dps.Cpus.Total) / count,
+			AllocCpus:   float64(dps.Cpus.Allocated) / count,
+			IdleCpus:    float64(dps.Cpus.Idle) / count,
+			AllocMemory: float64(dps.Memory.Allocated) / count * 1e6,
+			RealMemory:  float64(dps.Memory.Maximum) * 1e6,
+			FreeMemory:  float64(dps.Memory.Free.Maximum) * 1e6,
+			CpuLoad:     float64(dps.Cpus.Load.Maximum),
+			Partitions:  []string{dps.Partition.Name},
+			Weight:      float64(dps.Weight.Maximum),
+			Gres:        dps.Gres.Total,
+			GresUsed:    dps.Gres.Used,
+			grouped:     count > 1,
+		})
+	}
+	return nodeMetrics
+}
+
+// decode sinfo json with the schema reported by its meta block. Api errors are returned separately from decode errors
+func parseNodeMetrics(data []byte) ([]NodeMetric, []string, error) {
+	meta, err := parseSlurmMeta(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !meta.isDataParser() {
+		sinfo := new(sinfoResponse)
+		if err := json.Unmarshal(data, sinfo); err != nil {
+			return nil, nil, err
+		}
+		return sinfo.Nodes, sinfo.Errors, nil
+	}
+	sinfo := new(dataParserSinfoResponse)
+	if err := json.Unmarshal(data, sinfo); err != nil {
+		return nil, nil, err
+	}
+	apiErrors := make([]string, 0, len(sinfo.Errors))
+	for _, e := range sinfo.Errors {
+		apiErrors = append(apiErrors, e.String())
+	}
+	nodeMetrics := make([]NodeMetric, 0, len(sinfo.Nodes))
+	for i := range sinfo.Nodes {
+		nodeMetrics = append(nodeMetrics, sinfo.Nodes[i].nodeMetric())
+	}
+	// nodes in multiple partitions are listed once per partition
+	nodeIndex := make(map[string]int)
+	for i := range sinfo.Sinfo {
+		for _, node := range sinfo.Sinfo[i].nodeMetrics() {
+			if idx, ok := nodeIndex[node.Hostname]; ok {
+				partitions := append(nodeMetrics[idx].Partitions, node.Partitions...)
+				// prefer a record of the node alone, its values are exact
+				if nodeMetrics[idx].grouped && !node.grouped {
+					nodeMetrics[idx] = node
+				}
+				nodeMetrics[idx].Partitions = partitions
+				continue
+			}
+			nodeIndex[node.Hostname] = len(nodeMetrics)
+			nodeMetrics = append(nodeMetrics, node)
+		}
+	}
+	return nodeMetrics, apiErrors, nil
+}
+
 type NodeJsonFetcher struct {
 	scraper      SlurmByteScraper
-	errorCounter prometheus.Counter
+	errorCounter *prometheus.CounterVec
 	cache        *AtomicThrottledCache[NodeMetric]
 }
 
-func (cmf *NodeJsonFetcher) fetch() ([]NodeMetric, error) {
-	squeue := new(sinfoResponse)
-	cliJson, err := cmf.scraper.FetchRawBytes()
+func (cmf *NodeJsonFetcher) fetch(ctx context.Context) ([]NodeMetric, error) {
+	cliJson, err := cmf.scraper.FetchRawBytes(ctx)
 	if err != nil {
+		observeCommandError(cmf.errorCounter, err)
 		return nil, err
 	}
-	if err := json.Unmarshal(cliJson, squeue); err != nil {
+	nodeMetrics, apiErrors, err := parseNodeMetrics(cliJson)
+	if err != nil {
 		slog.Error(fmt.Sprintf("Unmarshaling node metrics %q", err))
+		cmf.errorCounter.WithLabelValues(reasonParse).Inc()
 		return nil, err
 	}
-	if len(squeue.Errors) > 0 {
-		for _, e := range squeue.Errors {
+	if len(apiErrors) > 0 {
+		for _, e := range apiErrors {
 			slog.Error(fmt.Sprintf("Api error response %q", e))
 		}
-		cmf.errorCounter.Add(float64(len(squeue.Errors)))
-		return nil, errors.New(squeue.Errors[0])
+		cmf.errorCounter.WithLabelValues(reasonExit).Add(float64(len(apiErrors)))
+		return nil, errors.New(apiErrors[0])
 	}
-	return squeue.Nodes, nil
-}
-
-func (cmf *NodeJsonFetcher) FetchMetrics() ([]NodeMetric, error) {
-	return cmf.cache.FetchOrThrottle(cmf.fetch)
+	return nodeMetrics, nil
 }
 
-func (cmf *NodeJsonFetcher) ScrapeError() prometheus.Counter {
-	return cmf.errorCounter
+func (cmf *NodeJsonFetcher) FetchMetrics(ctx context.Context) ([]NodeMetric, error) {
+	return cmf.cache.FetchOrThrottle(func() ([]NodeMetric, error) { return cmf.fetch(ctx) })
 }
 
 func (cmf *NodeJsonFetcher) ScrapeDuration() time.Duration {
@@ -106,18 +253,21 @@ func (naf *NAbleFloat) UnmarshalJSON(data []byte) error {
 
 type NodeCliFallbackFetcher struct {
 	scraper      SlurmByteScraper
-	errorCounter prometheus.Counter
+	errorCounter *prometheus.CounterVec
 	cache        *AtomicThrottledCache[NodeMetric]
 }
 
-func (cmf *NodeCliFallbackFetcher) fetch() ([]NodeMetric, error) {
-	sinfo, err := cmf.scraper.FetchRawBytes()
+func (cmf *NodeCliFallbackFetcher) fetch(ctx context.Context) ([]NodeMetric, error) {
+	sinfo, err := cmf.scraper.FetchRawBytes(ctx)
 	if err != nil {
-		cmf.errorCounter.Inc()
+		observeCommandError(cmf.errorCounter, err)
 		return nil, err
 	}
 	nodeMetrics := make(map[string]*NodeMetric, 0)
 	for i, line := range bytes.Split(bytes.Trim(sinfo, "\n"), []byte("\n")) {
+		if isClusterHeader(line) {
+			continue
+		}
 		var metric struct {
 			Hostname   string     `json:"n"`
 			RealMemory float64    `json:"mem"`
@@ -127,9 +277,10 @@ func (cmf *NodeCliFallbackFetcher) fetch() ([]NodeMetric, error) {
 			CpuLoad    NAbleFloat `json:"l"`
 			State      string     `json:"s"`
 			Weight     float64    `json:"w"`
+			Gres       string     `json:"g"`
 		}
 		if err := json.Unmarshal(line, &metric); err != nil {
-			cmf.errorCounter.Inc()
+			cmf.errorCounter.WithLabelValues(reasonParse).Inc()
 			slog.Error(fmt.Sprintf("sinfo failed to parse line %d: %s, got %q", i, line, err))
 			continue
 		}
@@ -138,27 +289,27 @@ func (cmf *NodeCliFallbackFetcher) fetch() ([]NodeMetric, error) {
 		metric.FreeMemory *= 1e6
 		cpuStates := strings.Split(metric.CpuState, "/")
 		if len(cpuStates) != 4 {
-			cmf.errorCounter.Inc()
+			cmf.errorCounter.WithLabelValues(reasonParse).Inc()
 			return nil, fmt.Errorf("unexpected cpu state format. Got %s", metric.CpuState)
 		}
 		allocated, err := strconv.ParseFloat(cpuStates[0], 64)
 		if err != nil {
-			cmf.errorCounter.Inc()
+			cmf.errorCounter.WithLabelValues(reasonParse).Inc()
 			return nil, err
 		}
 		idle, err := strconv.ParseFloat(cpuStates[1], 64)
 		if err != nil {
-			cmf.errorCounter.Inc()
+			cmf.errorCounter.WithLabelValues(reasonParse).Inc()
 			return nil, err
 		}
 		other, err := strconv.ParseFloat(cpuStates[2], 64)
 		if err != nil {
-			cmf.errorCounter.Inc()
+			cmf.errorCounter.WithLabelValues(reasonParse).Inc()
 			return nil, err
 		}
 		total, err := strconv.ParseFloat(cpuStates[3], 64)
 		if err != nil {
-			cmf.errorCounter.Inc()
+			cmf.errorCounter.WithLabelValues(reasonParse).Inc()
 			return nil, err
 		}
 		_ = other
@@ -182,6 +333,8 @@ func (cmf *NodeCliFallbackFetcher) fetch() ([]NodeMetric, error) {
 				IdleCpus:    idle,
 				Weight:      metric.Weight,
 				CpuLoad:     float64(metric.CpuLoad),
+				// sinfo has no format option for gres usage
+				Gres: metric.Gres,
 			}
 		}
 	}
@@ -192,8 +345,8 @@ func (cmf *NodeCliFallbackFetcher) fetch() ([]NodeMetric, error) {
 	return values, nil
 }
 
-func (cmf *NodeCliFallbackFetcher) FetchMetrics() ([]NodeMetric, error) {
-	return cmf.cache.FetchOrThrottle(cmf.fetch)
+func (cmf *NodeCliFallbackFetcher) FetchMetrics(ctx context.Context) ([]NodeMetric, error) {
+	return cmf.cache.FetchOrThrottle(func() ([]NodeMetric, error) { return cmf.fetch(ctx) })
 }
 
 type PartitionMetric struct {
@@ -235,14 +388,47 @@ func fetchNodePartitionMetrics(nodes []NodeMetric) map[string]*PartitionMetric {
 	return partitions
 }
 
-func (cmf *NodeCliFallbackFetcher) ScrapeError() prometheus.Counter {
-	return cmf.errorCounter
-}
-
 func (cmf *NodeCliFallbackFetcher) ScrapeDuration() time.Duration {
 	return cmf.scraper.Duration()
 }
 
+type GresMetric struct {
+	total float64
+	alloc float64
+	// false when none of the nodes report gres usage, i.e with the cli fallback
+	usageKnown bool
+}
+
+// gres capacity and allocations per node state and per partition, keyed by gres then by state or partition
+func parseNodeGresMetrics(nodes []NodeMetric) (states map[string]map[string]*GresMetric, partitions map[string]map[string]*GresMetric) {
+	states = make(map[string]map[string]*GresMetric)
+	partitions = make(map[string]map[string]*GresMetric)
+	observe := func(metrics map[string]map[string]*GresMetric, gres string, key string, total float64, alloc float64, usageKnown bool) {
+		if _, ok := metrics[gres]; !ok {
+			metrics[gres] = make(map[string]*GresMetric)
+		}
+		metric, ok := metrics[gres][key]
+		if !ok {
+			metric = new(GresMetric)
+			metrics[gres][key] = metric
+		}
+		metric.total += total
+		metric.alloc += alloc
+		metric.usageKnown = metric.usageKnown || usageKnown
+	}
+	for _, node := range nodes {
+		used := parseGres(node.GresUsed)
+		usageKnown := node.GresUsed != ""
+		for gres, total := range parseGres(node.Gres) {
+			observe(states, gres, node.State, total, used[gres], usageKnown)
+			for _, partition := range node.Partitions {
+				observe(partitions, gres, partition, total, used[gres], usageKnown)
+			}
+		}
+	}
+	return states, partitions
+}
+
 type PerStateMetric struct {
 	Cpus  float64
 	Count float64
@@ -291,7 +477,8 @@ func fetchNodeTotalMemMetrics(nodes []NodeMetric) *MemSummaryMetric {
 
 type NodesCollector struct {
 	// collector state
-	fetcher SlurmMetricFetcher[NodeMetric]
+	fetcher            SlurmMetricFetcher[NodeMetric]
+	nodeMetricsEnabled bool
 	// partition summary metrics
 	partitionCpus        *prometheus.Desc
 	partitionRealMemory  *prometheus.Desc
@@ -308,53 +495,83 @@ type NodesCollector struct {
 	totalIdleCpus     *prometheus.Desc
 	totalCpuLoad      *prometheus.Desc
 	nodeCountPerState *prometheus.Desc
+	// gres stats
+	partitionGresTotal *prometheus.Desc
+	partitionGresAlloc *prometheus.Desc
+	partitionGresIdle  *prometheus.Desc
+	stateGresTotal     *prometheus.Desc
+	stateGresAlloc     *prometheus.Desc
+	stateGresIdle      *prometheus.Desc
 	// memory summary stats
 	totalRealMemory  *prometheus.Desc
 	totalFreeMemory  *prometheus.Desc
 	totalAllocMemory *prometheus.Desc
+	// per node stats
+	nodeInfo      *prometheus.Desc
+	nodeCpus      *prometheus.Desc
+	nodeAllocCpus *prometheus.Desc
+	nodeIdleCpus  *prometheus.Desc
+	nodeCpuLoad   *prometheus.Desc
+	nodeRealMem   *prometheus.Desc
+	nodeAllocMem  *prometheus.Desc
+	nodeFreeMem   *prometheus.Desc
+	nodeWeight    *prometheus.Desc
 	// exporter metrics
 	nodeScrapeDuration *prometheus.Desc
-	nodeScrapeErrors   prometheus.Counter
 }
 
 func NewNodeCollecter(config *Config) *NodesCollector {
 	cliOpts := config.cliOpts
-	byteScraper := NewCliScraper(cliOpts.sinfo...)
-	errorCounter := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "slurm_node_scrape_error",
-		Help: "slurm node info scrape errors",
-	})
+	byteScraper := cliOpts.newScraper(restNodes, cliOpts.sinfo)
+	errorCounter := config.commandErrorCounter("sinfo")
 	var fetcher SlurmMetricFetcher[NodeMetric]
-	if cliOpts.fallback {
-		fetcher = &NodeCliFallbackFetcher{scraper: byteScraper, errorCounter: errorCounter, cache: NewAtomicThrottledCache[NodeMetric](config.PollLimit)}
+	if cliOpts.fallback && !cliOpts.useRest(restNodes) {
+		fetcher = &NodeCliFallbackFetcher{scraper: byteScraper, errorCounter: errorCounter, cache: newConfiguredCache[NodeMetric](config, "nodes")}
 	} else {
-		fetcher = &NodeJsonFetcher{scraper: byteScraper, errorCounter: errorCounter, cache: NewAtomicThrottledCache[NodeMetric](config.PollLimit)}
+		fetcher = &NodeJsonFetcher{scraper: byteScraper, errorCounter: errorCounter, cache: newConfiguredCache[NodeMetric](config, "nodes")}
 	}
 	return &NodesCollector{
-		fetcher: fetcher,
+		fetcher:            fetcher,
+		nodeMetricsEnabled: cliOpts.nodeMetricsEnabled,
 		// partition stats
-		partitionCpus:        prometheus.NewDesc("slurm_partition_total_cpus", "Total cpus per partition", []string{"partition"}, nil),
-		partitionRealMemory:  prometheus.NewDesc("slurm_partition_real_mem", "Real mem per partition", []string{"partition"}, nil),
-		partitionFreeMemory:  prometheus.NewDesc("slurm_partition_free_mem", "Free mem per partition", []string{"partition"}, nil),
-		partitionAllocMemory: prometheus.NewDesc("slurm_partition_alloc_mem", "Alloc mem per partition per state", []string{"partition", "state"}, nil),
-		partitionAllocCpus:   prometheus.NewDesc("slurm_partition_alloc_cpus", "Alloc cpus per partition per state", []string{"partition", "state"}, nil),
-		partitionNodeCount:   prometheus.NewDesc("slurm_partition_node_count", "Node count per partition per state", []string{"partition", "state"}, nil),
-		partitionIdleCpus:    prometheus.NewDesc("slurm_partition_idle_cpus", "Idle cpus per partition", []string{"partition"}, nil),
-		partitionWeight:      prometheus.NewDesc("slurm_partition_weight", "Total node weight per partition??", []string{"partition"}, nil),
-		partitionCpuLoad:     prometheus.NewDesc("slurm_partition_cpu_load", "Total cpu load per partition", []string{"partition"}, nil),
+		partitionCpus:        prometheus.NewDesc("slurm_partition_total_cpus", "Total cpus per partition", []string{"partition"}, config.constLabels()),
+		partitionRealMemory:  prometheus.NewDesc("slurm_partition_real_mem", "Real mem per partition", []string{"partition"}, config.constLabels()),
+		partitionFreeMemory:  prometheus.NewDesc("slurm_partition_free_mem", "Free mem per partition", []string{"partition"}, config.constLabels()),
+		partitionAllocMemory: prometheus.NewDesc("slurm_partition_alloc_mem", "Alloc mem per partition per state", []string{"partition", "state"}, config.constLabels()),
+		partitionAllocCpus:   prometheus.NewDesc("slurm_partition_alloc_cpus", "Alloc cpus per partition per state", []string{"partition", "state"}, config.constLabels()),
+		partitionNodeCount:   prometheus.NewDesc("slurm_partition_node_count", "Node count per partition per state", []string{"partition", "state"}, config.constLabels()),
+		partitionIdleCpus:    prometheus.NewDesc("slurm_partition_idle_cpus", "Idle cpus per partition", []string{"partition"}, config.constLabels()),
+		partitionWeight:      prometheus.NewDesc("slurm_partition_weight", "Total node weight per partition??", []string{"partition"}, config.constLabels()),
+		partitionCpuLoad:     prometheus.NewDesc("slurm_partition_cpu_load", "Total cpu load per partition", []string{"partition"}, config.constLabels()),
 		// node cpu summary stats
-		totalCpus:         prometheus.NewDesc("slurm_cpus_total", "Total cpus", nil, nil),
-		totalIdleCpus:     prometheus.NewDesc("slurm_cpus_idle", "Total idle cpus", nil, nil),
-		totalCpuLoad:      prometheus.NewDesc("slurm_cpu_load", "Total cpu load", nil, nil),
-		cpusPerState:      prometheus.NewDesc("slurm_cpus_per_state", "Cpus per state i.e alloc, mixed, draining, etc.", []string{"state"}, nil),
-		nodeCountPerState: prometheus.NewDesc("slurm_node_count_per_state", "nodes per state", []string{"state"}, nil),
+		totalCpus:         prometheus.NewDesc("slurm_cpus_total", "Total cpus", nil, config.constLabels()),
+		totalIdleCpus:     prometheus.NewDesc("slurm_cpus_idle", "Total idle cpus", nil, config.constLabels()),
+		totalCpuLoad:      prometheus.NewDesc("slurm_cpu_load", "Total cpu load", nil, config.constLabels()),
+		cpusPerState:      prometheus.NewDesc("slurm_cpus_per_state", "Cpus per state i.e alloc, mixed, draining, etc.", []string{"state"}, config.constLabels()),
+		nodeCountPerState: prometheus.NewDesc("slurm_node_count_per_state", "nodes per state", []string{"state"}, config.constLabels()),
+		// gres stats
+		partitionGresTotal: prometheus.NewDesc("slurm_partition_gres_total", "gres capacity per partition", []string{"partition", "gres", "model"}, config.constLabels()),
+		partitionGresAlloc: prometheus.NewDesc("slurm_partition_gres_alloc", "gres allocated per partition", []string{"partition", "gres", "model"}, config.constLabels()),
+		partitionGresIdle:  prometheus.NewDesc("slurm_partition_gres_idle", "gres idle per partition", []string{"partition", "gres", "model"}, config.constLabels()),
+		stateGresTotal:     prometheus.NewDesc("slurm_gres_total_per_state", "gres capacity per node state", []string{"state", "gres", "model"}, config.constLabels()),
+		stateGresAlloc:     prometheus.NewDesc("slurm_gres_alloc_per_state", "gres allocated per node state", []string{"state", "gres", "model"}, config.constLabels()),
+		stateGresIdle:      prometheus.NewDesc("slurm_gres_idle_per_state", "gres idle per node state", []string{"state", "gres", "model"}, config.constLabels()),
 		// node memory summary stats
-		totalRealMemory:  prometheus.NewDesc("slurm_mem_real", "Total real mem", nil, nil),
-		totalFreeMemory:  prometheus.NewDesc("slurm_mem_free", "Total free mem", nil, nil),
-		totalAllocMemory: prometheus.NewDesc("slurm_mem_alloc", "Total alloc mem", nil, nil),
+		totalRealMemory:  prometheus.NewDesc("slurm_mem_real", "Total real mem", nil, config.constLabels()),
+		totalFreeMemory:  prometheus.NewDesc("slurm_mem_free", "Total free mem", nil, config.constLabels()),
+		totalAllocMemory: prometheus.NewDesc("slurm_mem_alloc", "Total alloc mem", nil, config.constLabels()),
+		// per node stats
+		nodeInfo:      prometheus.NewDesc("slurm_node_info", "node state and partitions, always 1", []string{"hostname", "state", "partitions"}, config.constLabels()),
+		nodeCpus:      prometheus.NewDesc("slurm_node_cpus", "cpus per node", []string{"hostname"}, config.constLabels()),
+		nodeAllocCpus: prometheus.NewDesc("slurm_node_alloc_cpus", "alloc cpus per node", []string{"hostname"}, config.constLabels()),
+		nodeIdleCpus:  prometheus.NewDesc("slurm_node_idle_cpus", "idle cpus per node", []string{"hostname"}, config.constLabels()),
+		nodeCpuLoad:   prometheus.NewDesc("slurm_node_cpu_load", "cpu load per node", []string{"hostname"}, config.constLabels()),
+		nodeRealMem:   prometheus.NewDesc("slurm_node_real_mem", "real mem per node", []string{"hostname"}, config.constLabels()),
+		nodeAllocMem:  prometheus.NewDesc("slurm_node_alloc_mem", "alloc mem per node", []string{"hostname"}, config.constLabels()),
+		nodeFreeMem:   prometheus.NewDesc("slurm_node_free_mem", "free mem per node", []string{"hostname"}, config.constLabels()),
+		nodeWeight:    prometheus.NewDesc("slurm_node_weight", "scheduling weight per node", []string{"hostname"}, config.constLabels()),
 		// exporter stats
-		nodeScrapeDuration: prometheus.NewDesc("slurm_node_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.sinfo), nil, nil),
-		nodeScrapeErrors:   fetcher.ScrapeError(),
+		nodeScrapeDuration: prometheus.NewDesc("slurm_node_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.sinfo), nil, config.constLabels()),
 	}
 }
 
@@ -374,15 +591,30 @@ func (nc *NodesCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- nc.totalRealMemory
 	ch <- nc.totalFreeMemory
 	ch <- nc.totalAllocMemory
+	ch <- nc.partitionGresTotal
+	ch <- nc.partitionGresAlloc
+	ch <- nc.partitionGresIdle
+	ch <- nc.stateGresTotal
+	ch <- nc.stateGresAlloc
+	ch <- nc.stateGresIdle
+	ch <- nc.nodeInfo
+	ch <- nc.nodeCpus
+	ch <- nc.nodeAllocCpus
+	ch <- nc.nodeIdleCpus
+	ch <- nc.nodeCpuLoad
+	ch <- nc.nodeRealMem
+	ch <- nc.nodeAllocMem
+	ch <- nc.nodeFreeMem
+	ch <- nc.nodeWeight
 	ch <- nc.nodeScrapeDuration
-	ch <- nc.nodeScrapeErrors.Desc()
 }
 
 func (nc *NodesCollector) Collect(ch chan<- prometheus.Metric) {
-	defer func() {
-		ch <- nc.fetcher.ScrapeError()
-	}()
-	nodeMetrics, err := nc.fetcher.FetchMetrics()
+	nc.CollectWithContext(context.Background(), ch)
+}
+
+func (nc *NodesCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	nodeMetrics, err := nc.fetcher.FetchMetrics(ctx)
 	ch <- prometheus.MustNewConstMetric(nc.nodeScrapeDuration, prometheus.GaugeValue, float64(nc.fetcher.ScrapeDuration().Milliseconds()))
 	if err != nil {
 		slog.Error("Failed to parse node metrics: " + err.Error())
@@ -434,6 +666,42 @@ func (nc *NodesCollector) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(nc.totalRealMemory, prometheus.GaugeValue, memMetrics.RealMemory)
 	ch <- prometheus.MustNewConstMetric(nc.totalFreeMemory, prometheus.GaugeValue, memMetrics.FreeMemory)
 	ch <- prometheus.MustNewConstMetric(nc.totalAllocMemory, prometheus.GaugeValue, memMetrics.AllocMemory)
+	// gres set
+	emitGres := func(gresMetrics map[string]map[string]*GresMetric, total, alloc, idle *prometheus.Desc) {
+		for gres, metrics := range gresMetrics {
+			name, model := splitGresKey(gres)
+			for key, metric := range metrics {
+				ch <- prometheus.MustNewConstMetric(total, prometheus.GaugeValue, metric.total, key, name, model)
+				if metric.usageKnown {
+					ch <- prometheus.MustNewConstMetric(alloc, prometheus.GaugeValue, metric.alloc, key, name, model)
+					ch <- prometheus.MustNewConstMetric(idle, prometheus.GaugeValue, metric.total-metric.alloc, key, name, model)
+				}
+			}
+		}
+	}
+	stateGres, partitionGres := parseNodeGresMetrics(nodeMetrics)
+	emitGres(stateGres, nc.stateGresTotal, nc.stateGresAlloc, nc.stateGresIdle)
+	emitGres(partitionGres, nc.partitionGresTotal, nc.partitionGresAlloc, nc.partitionGresIdle)
+	if !nc.nodeMetricsEnabled {
+		return
+	}
+	// the fetchers merge the records of nodes listed once per partition, so every hostname is unique
+	for _, node := range nodeMetrics {
+		hostname := node.Hostname
+		ch <- prometheus.MustNewConstMetric(nc.nodeInfo, prometheus.GaugeValue, 1, hostname, node.State, strings.Join(node.Partitions, ","))
+		// state and partitions are shared by a grouped record but the values of its nodes aren't known
+		if node.grouped {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(nc.nodeCpus, prometheus.GaugeValue, node.Cpus, hostname)
+		ch <- prometheus.MustNewConstMetric(nc.nodeAllocCpus, prometheus.GaugeValue, node.AllocCpus, hostname)
+		ch <- prometheus.MustNewConstMetric(nc.nodeIdleCpus, prometheus.GaugeValue, node.IdleCpus, hostname)
+		ch <- prometheus.MustNewConstMetric(nc.nodeCpuLoad, prometheus.GaugeValue, node.CpuLoad, hostname)
+		ch <- prometheus.MustNewConstMetric(nc.nodeRealMem, prometheus.GaugeValue, node.RealMemory, hostname)
+		ch <- prometheus.MustNewConstMetric(nc.nodeAllocMem, prometheus.GaugeValue, node.AllocMemory, hostname)
+		ch <- prometheus.MustNewConstMetric(nc.nodeFreeMem, prometheus.GaugeValue, node.FreeMemory, hostname)
+		ch <- prometheus.MustNewConstMetric(nc.nodeWeight, prometheus.GaugeValue, node.Weight, hostname)
+	}
 }
 
 func (nc *NodesCollector) SetFetcher(fetcher SlurmMetricFetcher[NodeMetric]) {
@@ -5,10 +5,12 @@
 package exporter
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"slices"
 )
@@ -32,8 +34,8 @@ func TestNewNodeCollector(t *testing.T) {
 }
 
 func TestParseNodeMetrics(t *testing.T) {
-	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
-	nodeMetrics, err := fetcher.FetchMetrics()
+	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: newMockErrorCounter(), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nodeMetrics, err := fetcher.FetchMetrics(context.Background())
 	if err != nil {
 		t.Fatalf("Failed to parse metrics with %s", err)
 	}
@@ -43,6 +45,219 @@ func TestParseNodeMetrics(t *testing.T) {
 	t.Logf("Node metrics collected %d", len(nodeMetrics))
 }
 
+func TestParseNodeMetrics_DataParser(t *testing.T) {
+	for _, fixture := range []string{"fixtures/sinfo_2311.json", "fixtures/sinfo_2405.json"} {
+		t.Run(fixture, func(t *testing.T) {
+			assert := assert.New(t)
+			fetcher := NodeJsonFetcher{scraper: &MockScraper{fixture: fixture}, errorCounter: newMockErrorCounter(), cache: NewAtomicThrottledCache[NodeMetric](1)}
+			nodeMetrics, err := fetcher.fetch(context.Background())
+			assert.NoError(err)
+			nodes := make(map[string]NodeMetric)
+			for _, node := range nodeMetrics {
+				nodes[node.Hostname] = node
+			}
+			assert.Len(nodes, 4)
+			// grouped records are split evenly between their nodes
+			assert.Equal(32., nodes["cs75"].Cpus)
+			assert.Equal(3., nodes["cs75"].AllocCpus)
+			assert.Equal(3.3e10, nodes["cs75"].AllocMemory)
+			assert.Equal(5e11, nodes["cs75"].RealMemory)
+			assert.Equal("mixed", nodes["cs75"].State)
+			assert.Equal("idle+drain", nodes["cs78"].State)
+			// nodes in multiple partitions are merged
+			assert.Equal([]string{"hw", "magma"}, nodes["cs77"].Partitions)
+		})
+	}
+}
+
+func TestParseNodeMetrics_DataParserNodes(t *testing.T) {
+	assert := assert.New(t)
+	// per node schema served by slurmrestd
+	data := []byte(`{
+		"meta": {"plugin": {"data_parser": "data_parser/v0.0.40"}},
+		"errors": [],
+		"nodes": [{
+			"hostname": "cs75", "state": ["MIXED"], "cpus": 64, "alloc_cpus": 4, "alloc_idle_cpus": 60,
+			"alloc_memory": 64000, "real_memory": 500000, "free_mem": {"set": true, "infinite": false, "number": 400000},
+			"cpu_load": 2, "partitions": ["hw"], "weight": 1
+		}]
+	}`)
+	nodeMetrics, apiErrors, err := parseNodeMetrics(data)
+	assert.NoError(err)
+	assert.Empty(apiErrors)
+	assert.Equal([]NodeMetric{{
+		Hostname:    "cs75",
+		State:       "mixed",
+		Cpus:        64,
+		AllocCpus:   4,
+		IdleCpus:    60,
+		AllocMemory: 6.4e10,
+		RealMemory:  5e11,
+		FreeMemory:  4e11,
+		CpuLoad:     2,
+		Partitions:  []string{"hw"},
+		Weight:      1,
+	}}, nodeMetrics)
+}
+
+func TestParseNodeMetrics_DataParserGrouped(t *testing.T) {
+	assert := assert.New(t)
+	// cs75 shares a record with cs76 in hw but is alone in magma
+	data := []byte(`{
+		"meta": {"plugin": {"data_parser": "data_parser/v0.0.40"}},
+		"errors": [],
+		"sinfo": [{
+			"node": {"state": ["MIXED"]}, "nodes": {"nodes": ["cs75", "cs76"]}, "partition": {"name": "hw"},
+			"cpus": {"allocated": 6, "idle": 58, "total": 64, "load": {"maximum": 2}}
+		}, {
+			"node": {"state": ["MIXED"]}, "nodes": {"nodes": ["cs75"]}, "partition": {"name": "magma"},
+			"cpus": {"allocated": 4, "idle": 28, "total": 32, "load": {"maximum": 2}}
+		}]
+	}`)
+	nodeMetrics, _, err := parseNodeMetrics(data)
+	assert.NoError(err)
+	assert.Len(nodeMetrics, 2)
+	assert.Equal("cs75", nodeMetrics[0].Hostname)
+	assert.Equal(4., nodeMetrics[0].AllocCpus)
+	assert.Equal([]string{"hw", "magma"}, nodeMetrics[0].Partitions)
+	assert.False(nodeMetrics[0].grouped)
+	assert.Equal(3., nodeMetrics[1].AllocCpus)
+	assert.True(nodeMetrics[1].grouped)
+}
+
+func TestParseNodeMetrics_DataParserErrors(t *testing.T) {
+	assert := assert.New(t)
+	data := []byte(`{"meta": {"plugin": {"data_parser": "data_parser/v0.0.41"}}, "errors": [{"description": "slurmctld down", "error": "Unable to contact slurm controller"}]}`)
+	_, apiErrors, err := parseNodeMetrics(data)
+	assert.NoError(err)
+	assert.Equal([]string{"Unable to contact slurm controller: slurmctld down"}, apiErrors)
+}
+
+func TestParseNodeGresMetrics(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := NodeJsonFetcher{scraper: &MockScraper{fixture: "fixtures/sinfo_2405.json"}, errorCounter: newMockErrorCounter(), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nodeMetrics, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	states, partitions := parseNodeGresMetrics(nodeMetrics)
+	// cs75 and cs76 have 4 gpus each, 1 in use each
+	assert.Equal(&GresMetric{total: 8, alloc: 2, usageKnown: true}, states["gpu:a100"]["mixed"])
+	assert.Equal(&GresMetric{total: 12, alloc: 2, usageKnown: true}, partitions["gpu:a100"]["hw"])
+	assert.Equal(&GresMetric{total: 4, alloc: 0, usageKnown: true}, partitions["gpu:a100"]["magma"])
+}
+
+func TestParseNodeGresMetrics_Fallback(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := NodeCliFallbackFetcher{scraper: &MockScraper{fixture: "fixtures/sinfo_fallback.txt"}, errorCounter: newMockErrorCounter(), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nodeMetrics, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	states, partitions := parseNodeGresMetrics(nodeMetrics)
+	// sinfo can't report gres usage
+	assert.Equal(&GresMetric{total: 8}, states["gpu:a100"]["allocated"])
+	assert.Equal(&GresMetric{total: 8}, partitions["gpu:a100"]["hw-l"])
+}
+
+func TestNodeCollector_Gres(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	nc := NewNodeCollecter(config)
+	nc.fetcher = &NodeJsonFetcher{scraper: &MockScraper{fixture: "fixtures/sinfo_2311.json"}, errorCounter: newMockErrorCounter(), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	metricChan := make(chan prometheus.Metric)
+	go func() {
+		nc.Collect(metricChan)
+		close(metricChan)
+	}()
+	descs := make(map[string]int)
+	for metric := range metricChan {
+		descs[metric.Desc().String()]++
+	}
+	idle := prometheus.NewDesc("slurm_partition_gres_idle", "gres idle per partition", []string{"partition", "gres", "model"}, nil)
+	assert.Equal(2, descs[idle.String()])
+}
+
+// node info labels and per node values by desc, keyed by hostname
+func collectNodeMetrics(t *testing.T, enabled bool, scraper SlurmByteScraper) (*NodesCollector, map[string]map[string]string, map[*prometheus.Desc]map[string]float64) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{NodeMetricsEnabled: enabled})
+	assert.NoError(err)
+	nc := NewNodeCollecter(config)
+	nc.fetcher = &NodeJsonFetcher{scraper: scraper, errorCounter: newMockErrorCounter(), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	metricChan := make(chan prometheus.Metric)
+	go func() {
+		nc.Collect(metricChan)
+		close(metricChan)
+	}()
+	info := make(map[string]map[string]string)
+	values := make(map[*prometheus.Desc]map[string]float64)
+	for metric := range metricChan {
+		dtoMetric := new(dto.Metric)
+		assert.NoError(metric.Write(dtoMetric))
+		labels := make(map[string]string)
+		for _, label := range dtoMetric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		hostname, ok := labels["hostname"]
+		if !ok {
+			continue
+		}
+		if metric.Desc() == nc.nodeInfo {
+			info[hostname] = labels
+			continue
+		}
+		if values[metric.Desc()] == nil {
+			values[metric.Desc()] = make(map[string]float64)
+		}
+		values[metric.Desc()][hostname] = dtoMetric.GetGauge().GetValue()
+	}
+	return nc, info, values
+}
+
+func TestNodeCollector_NodeMetrics(t *testing.T) {
+	assert := assert.New(t)
+	_, info, values := collectNodeMetrics(t, false, &MockScraper{fixture: "fixtures/sinfo_2311.json"})
+	assert.Empty(info)
+	assert.Empty(values)
+
+	nc, info, values := collectNodeMetrics(t, true, &MockScraper{fixture: "fixtures/sinfo_2311.json"})
+	assert.Len(info, 4)
+	assert.Equal(map[string]string{"hostname": "cs77", "state": "idle", "partitions": "hw,magma"}, info["cs77"])
+	assert.Equal("idle+drain", info["cs78"]["state"])
+	assert.Equal(map[string]string{"hostname": "cs75", "state": "mixed", "partitions": "hw"}, info["cs75"])
+	// nodes alone in their record have exact values
+	assert.Equal(32., values[nc.nodeCpus]["cs77"])
+	assert.Equal(32., values[nc.nodeIdleCpus]["cs77"])
+	assert.Equal(4.9e11, values[nc.nodeFreeMem]["cs77"])
+	assert.Equal(2.5e11, values[nc.nodeRealMem]["cs78"])
+	// the record of cs75 and cs76 only has their sums and maximums
+	for _, desc := range []*prometheus.Desc{nc.nodeCpus, nc.nodeAllocCpus, nc.nodeIdleCpus, nc.nodeCpuLoad, nc.nodeRealMem, nc.nodeAllocMem, nc.nodeFreeMem, nc.nodeWeight} {
+		assert.NotContains(values[desc], "cs75")
+		assert.NotContains(values[desc], "cs76")
+		assert.Len(values[desc], 2)
+	}
+
+	// per node records, i.e scontrol show nodes --json
+	data := `{
+		"meta": {"plugin": {"data_parser": "data_parser/v0.0.40"}},
+		"errors": [],
+		"nodes": [{
+			"hostname": "cs75", "state": ["MIXED"], "cpus": 32, "alloc_cpus": 4, "alloc_idle_cpus": 28,
+			"alloc_memory": 64000, "real_memory": 500000, "free_mem": {"set": true, "infinite": false, "number": 400000},
+			"cpu_load": 3, "partitions": ["hw"], "weight": 1
+		}, {
+			"hostname": "cs76", "state": ["MIXED"], "cpus": 32, "alloc_cpus": 2, "alloc_idle_cpus": 30,
+			"alloc_memory": 2000, "real_memory": 500000, "free_mem": {"set": true, "infinite": false, "number": 480000},
+			"cpu_load": 1, "partitions": ["hw"], "weight": 1
+		}]
+	}`
+	nc, info, values = collectNodeMetrics(t, true, &StringByteScraper{msg: data})
+	assert.Len(info, 2)
+	assert.Equal(map[string]float64{"cs75": 4, "cs76": 2}, values[nc.nodeAllocCpus])
+	assert.Equal(map[string]float64{"cs75": 28, "cs76": 30}, values[nc.nodeIdleCpus])
+	assert.Equal(map[string]float64{"cs75": 6.4e10, "cs76": 2e9}, values[nc.nodeAllocMem])
+	assert.Equal(map[string]float64{"cs75": 4e11, "cs76": 4.8e11}, values[nc.nodeFreeMem])
+	assert.Equal(map[string]float64{"cs75": 3, "cs76": 1}, values[nc.nodeCpuLoad])
+}
+
 func sumStateMetric(metric map[string]float64) float64 {
 	sum := 0.
 	for _, val := range metric {
@@ -53,8 +268,8 @@ func sumStateMetric(metric map[string]float64) float64 {
 
 func TestPartitionMetric(t *testing.T) {
 	assert := assert.New(t)
-	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
-	nodeMetrics, err := fetcher.FetchMetrics()
+	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: newMockErrorCounter(), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nodeMetrics, err := fetcher.FetchMetrics(context.Background())
 	assert.Nil(err)
 	metrics := fetchNodePartitionMetrics(nodeMetrics)
 	assert.Equal(1, len(metrics))
@@ -71,8 +286,8 @@ func TestPartitionMetric(t *testing.T) {
 
 func TestNodeSummaryCpuMetric(t *testing.T) {
 	assert := assert.New(t)
-	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
-	nodeMetrics, err := fetcher.FetchMetrics()
+	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: newMockErrorCounter(), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nodeMetrics, err := fetcher.FetchMetrics(context.Background())
 	assert.Nil(err)
 	metrics := fetchNodeTotalCpuMetrics(nodeMetrics)
 	assert.Equal(4, len(metrics.PerState))
@@ -84,8 +299,8 @@ func TestNodeSummaryCpuMetric(t *testing.T) {
 
 func TestNodeSummaryMemoryMetrics(t *testing.T) {
 	assert := assert.New(t)
-	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
-	nodeMetrics, err := fetcher.FetchMetrics()
+	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: newMockErrorCounter(), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nodeMetrics, err := fetcher.FetchMetrics(context.Background())
 	assert.Nil(err)
 	metrics := fetchNodeTotalMemMetrics(nodeMetrics)
 	assert.Equal(114688., metrics.AllocMemory)
@@ -99,7 +314,7 @@ func TestNodeCollector(t *testing.T) {
 	assert.Nil(err)
 	nc := NewNodeCollecter(config)
 	// cache miss, use our mock fetcher
-	nc.fetcher = &NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nc.fetcher = &NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: newMockErrorCounter(), cache: NewAtomicThrottledCache[NodeMetric](1)}
 	metricChan := make(chan prometheus.Metric)
 	go func() {
 		nc.Collect(metricChan)
@@ -119,7 +334,7 @@ func TestNodeDescribe(t *testing.T) {
 	config, err := NewConfig(new(CliFlags))
 	assert.Nil(err)
 	jc := NewNodeCollecter(config)
-	jc.fetcher = &NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	jc.fetcher = &NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: newMockErrorCounter(), cache: NewAtomicThrottledCache[NodeMetric](1)}
 	go func() {
 		jc.Describe(ch)
 		close(ch)
@@ -134,8 +349,8 @@ func TestNodeDescribe(t *testing.T) {
 func TestParseFallbackNodeMetrics(t *testing.T) {
 	assert := assert.New(t)
 	byteFetcher := &MockScraper{fixture: "fixtures/sinfo_fallback.txt"}
-	fetcher := NodeCliFallbackFetcher{scraper: byteFetcher, errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
-	metrics, err := fetcher.FetchMetrics()
+	fetcher := NodeCliFallbackFetcher{scraper: byteFetcher, errorCounter: newMockErrorCounter(), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	metrics, err := fetcher.FetchMetrics(context.Background())
 	assert.Nil(err)
 	assert.NotEmpty(metrics)
 	cs25idx := slices.IndexFunc(metrics, func(nm NodeMetric) bool { return nm.Hostname == "cs25" })
@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var errNoSnapshot = errors.New("no snapshot taken yet")
+
+// implements SlurmMetricFetcher by serving the last snapshot taken by a background poll
+// this keeps scrape latency independent of how long slurm takes to respond
+type PolledFetcher[M SlurmPrimitiveMetric] struct {
+	sync.RWMutex
+	name     string
+	fetcher  SlurmMetricFetcher[M]
+	interval time.Duration
+	snapshot []M
+	// error of the last poll, the snapshot is kept
+	err error
+	// time of the last successful fetch
+	takenAt time.Time
+	// serve the snapshot for up to staleLimit seconds after it was taken when the last poll failed. 0 disables
+	staleLimit float64
+}
+
+func (pf *PolledFetcher[M]) refresh(ctx context.Context) {
+	metrics, err := pf.fetcher.FetchMetrics(ctx)
+	pf.Lock()
+	defer pf.Unlock()
+	pf.err = err
+	if err != nil {
+		slog.Error(fmt.Sprintf("background poll of %s failed with %q", pf.name, err))
+		return
+	}
+	pf.snapshot = metrics
+	pf.takenAt = time.Now()
+}
+
+// refresh on every tick until ctx is cancelled
+func (pf *PolledFetcher[M]) poll(ctx context.Context) {
+	ticker := time.NewTicker(pf.interval)
+	defer ticker.Stop()
+	for {
+		pf.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (pf *PolledFetcher[M]) fetcherName() string {
+	return pf.name
+}
+
+func (pf *PolledFetcher[M]) snapshotTime() time.Time {
+	pf.RLock()
+	defer pf.RUnlock()
+	return pf.takenAt
+}
+
+// ctx is unused since slurm is never contacted on read
+func (pf *PolledFetcher[M]) FetchMetrics(ctx context.Context) ([]M, error) {
+	pf.RLock()
+	defer pf.RUnlock()
+	if pf.err == nil && pf.takenAt.IsZero() {
+		return nil, errNoSnapshot
+	}
+	if pf.err != nil {
+		if pf.takenAt.IsZero() || time.Since(pf.takenAt).Seconds() >= pf.staleLimit {
+			return nil, pf.err
+		}
+		slog.Warn(fmt.Sprintf("poll of %s failed with %q, serving stale data from %s", pf.name, pf.err, pf.takenAt.Format(time.RFC3339)))
+	}
+	return pf.snapshot, nil
+}
+
+func (pf *PolledFetcher[M]) ScrapeDuration() time.Duration {
+	return pf.fetcher.ScrapeDuration()
+}
+
+type poller interface {
+	poll(ctx context.Context)
+	fetcherName() string
+	snapshotTime() time.Time
+}
+
+// refreshes each scheduled fetcher on its own ticker, decoupled from prometheus scrapes
+type Scheduler struct {
+	pollers           []poller
+	snapshotTimestamp *prometheus.Desc
+}
+
+func NewScheduler(constLabels prometheus.Labels) *Scheduler {
+	return &Scheduler{
+		snapshotTimestamp: prometheus.NewDesc("slurm_exporter_snapshot_timestamp_seconds", "unix time of the last successful background poll per fetcher", []string{"fetcher"}, constLabels),
+	}
+}
+
+// wrap fetcher so it is refreshed every interval once the scheduler is started
+func Schedule[M SlurmPrimitiveMetric](s *Scheduler, name string, fetcher SlurmMetricFetcher[M], interval time.Duration) *PolledFetcher[M] {
+	pf := &PolledFetcher[M]{
+		name:     name,
+		fetcher:  fetcher,
+		interval: interval,
+	}
+	s.pollers = append(s.pollers, pf)
+	return pf
+}
+
+// launch a poll loop per fetcher. Loops exit once ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, p := range s.pollers {
+		slog.Info("background polling enabled for " + p.fetcherName())
+		go p.poll(ctx)
+	}
+}
+
+func (s *Scheduler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.snapshotTimestamp
+}
+
+func (s *Scheduler) Collect(ch chan<- prometheus.Metric) {
+	for _, p := range s.pollers {
+		if t := p.snapshotTime(); !t.IsZero() {
+			ch <- prometheus.MustNewConstMetric(s.snapshotTimestamp, prometheus.GaugeValue, float64(t.UnixMilli())/1e3, p.fetcherName())
+		}
+	}
+}
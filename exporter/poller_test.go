@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockNodeFetcher(scraper SlurmByteScraper) *NodeJsonFetcher {
+	return &NodeJsonFetcher{
+		scraper:      scraper,
+		errorCounter: newMockErrorCounter(),
+		cache:        NewAtomicThrottledCache[NodeMetric](0),
+	}
+}
+
+func TestPolledFetcher_NoSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	scraper := &MockScraper{fixture: "fixtures/sinfo_out.json"}
+	pf := Schedule(NewScheduler(nil), "nodes", newMockNodeFetcher(scraper), time.Second)
+	metrics, err := pf.FetchMetrics(context.Background())
+	assert.ErrorIs(err, errNoSnapshot)
+	assert.Nil(metrics)
+	assert.Zero(scraper.CallCount)
+}
+
+func TestPolledFetcher_Refresh(t *testing.T) {
+	assert := assert.New(t)
+	scraper := &MockScraper{fixture: "fixtures/sinfo_out.json"}
+	pf := Schedule(NewScheduler(nil), "nodes", newMockNodeFetcher(scraper), time.Second)
+	pf.refresh(context.Background())
+	assert.False(pf.snapshotTime().IsZero())
+	for range 3 {
+		metrics, err := pf.FetchMetrics(context.Background())
+		assert.NoError(err)
+		assert.NotEmpty(metrics)
+	}
+	// reads never reach slurm
+	assert.Equal(1, scraper.CallCount)
+}
+
+func TestPolledFetcher_RefreshError(t *testing.T) {
+	assert := assert.New(t)
+	pf := Schedule(NewScheduler(nil), "nodes", newMockNodeFetcher(new(MockFetchErrored)), time.Second)
+	pf.refresh(context.Background())
+	metrics, err := pf.FetchMetrics(context.Background())
+	assert.EqualError(err, "mock fetch error")
+	assert.Nil(metrics)
+	assert.True(pf.snapshotTime().IsZero())
+}
+
+func TestPolledFetcher_RefreshErrorKeepsSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := newMockNodeFetcher(&MockScraper{fixture: "fixtures/sinfo_out.json"})
+	pf := Schedule(NewScheduler(nil), "nodes", fetcher, time.Second)
+	pf.refresh(context.Background())
+	takenAt := pf.snapshotTime()
+	fetcher.scraper = new(MockFetchErrored)
+	pf.refresh(context.Background())
+	assert.NotEmpty(pf.snapshot)
+	assert.Equal(takenAt, pf.snapshotTime())
+	// without a stale max age the failure hides the snapshot
+	metrics, err := pf.FetchMetrics(context.Background())
+	assert.EqualError(err, "mock fetch error")
+	assert.Nil(metrics)
+	pf.staleLimit = 60
+	metrics, err = pf.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.NotEmpty(metrics)
+	pf.takenAt = time.Now().Add(-time.Minute)
+	_, err = pf.FetchMetrics(context.Background())
+	assert.Error(err)
+}
+
+func TestScheduler(t *testing.T) {
+	assert := assert.New(t)
+	scheduler := NewScheduler(nil)
+	scraper := &MockScraper{fixture: "fixtures/sinfo_out.json"}
+	pf := Schedule(scheduler, "nodes", newMockNodeFetcher(scraper), time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduler.Start(ctx)
+	assert.Eventually(func() bool {
+		metrics, err := pf.FetchMetrics(context.Background())
+		return err == nil && len(metrics) > 0
+	}, time.Second, time.Millisecond)
+	cancel()
+
+	metricChan := make(chan prometheus.Metric)
+	go func() {
+		scheduler.Collect(metricChan)
+		close(metricChan)
+	}()
+	metrics := make([]prometheus.Metric, 0)
+	for m, ok := <-metricChan; ok; m, ok = <-metricChan {
+		metrics = append(metrics, m)
+	}
+	assert.Len(metrics, 1)
+}
+
+func TestScheduler_NoSnapshotTimestamp(t *testing.T) {
+	assert := assert.New(t)
+	scheduler := NewScheduler(nil)
+	Schedule(scheduler, "nodes", newMockNodeFetcher(new(MockFetchErrored)), time.Second)
+	metricChan := make(chan prometheus.Metric, 1)
+	scheduler.Collect(metricChan)
+	assert.Empty(metricChan)
+}
+
+func TestNewConfig_PollInterval(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	assert.Zero(config.PollInterval)
+	t.Setenv("POLL_INTERVAL", "30")
+	config, err = NewConfig(new(CliFlags))
+	assert.NoError(err)
+	assert.Equal(30., config.PollInterval)
+	config, err = NewConfig(&CliFlags{SlurmPollInterval: 15})
+	assert.NoError(err)
+	assert.Equal(15., config.PollInterval)
+}
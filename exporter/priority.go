@@ -0,0 +1,288 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// weighted priority of a pending job, as reported by sprio
+// jobs submitted to several partitions are listed once per partition
+type JobPriorityMetric struct {
+	JobId     float64
+	Partition string
+	UserName  string
+	Account   string
+	Priority  float64
+	Age       float64
+	Fairshare float64
+	JobSize   float64
+	// weight of the partition the job is pending in
+	PartitionPrio float64
+	Qos           float64
+	// sum of the weighted tres components
+	Tres float64
+}
+
+// priority components in the order exported by the component label
+var priorityComponents = []string{"total", "age", "fairshare", "jobsize", "partition", "qos", "tres"}
+
+func (jpm *JobPriorityMetric) components() []float64 {
+	return []float64{jpm.Priority, jpm.Age, jpm.Fairshare, jpm.JobSize, jpm.PartitionPrio, jpm.Qos, jpm.Tres}
+}
+
+type JobPriorityCliFetcher struct {
+	scraper    SlurmByteScraper
+	cache      *AtomicThrottledCache[JobPriorityMetric]
+	errCounter *prometheus.CounterVec
+}
+
+// weighted tres are reported per tres i.e cpu=1000,gres/gpu=2000
+func parseSprioTres(tres string) (float64, error) {
+	total := 0.
+	if tres == "" {
+		return total, nil
+	}
+	for _, item := range strings.Split(tres, ",") {
+		_, weight, found := strings.Cut(item, "=")
+		if !found {
+			return 0, fmt.Errorf("unexpected tres %q", item)
+		}
+		val, err := strconv.ParseFloat(weight, 64)
+		if err != nil {
+			return 0, err
+		}
+		total += val
+	}
+	return total, nil
+}
+
+// parse `sprio -h -o %i|%r|%u|%o|%Y|%A|%F|%J|%P|%Q|%T`
+func (jpf *JobPriorityCliFetcher) fetch(ctx context.Context) ([]JobPriorityMetric, error) {
+	sprio, err := jpf.scraper.FetchRawBytes(ctx)
+	if err != nil {
+		observeCommandError(jpf.errCounter, err)
+		slog.Error(fmt.Sprintf("failed to scrape job priorities with %q", err))
+		return nil, err
+	}
+	priorityMetrics := make([]JobPriorityMetric, 0)
+	for i, line := range bytes.Split(bytes.TrimSpace(sprio), []byte("\n")) {
+		if len(line) == 0 || isClusterHeader(line) {
+			continue
+		}
+		fields := strings.Split(string(line), "|")
+		if len(fields) != 11 {
+			slog.Error(fmt.Sprintf("sprio parse error: unexpected field count on line %d `%s`", i, line))
+			jpf.errCounter.WithLabelValues(reasonParse).Inc()
+			continue
+		}
+		values := make([]float64, 0, 7)
+		for _, field := range append([]string{fields[0]}, fields[4:10]...) {
+			val, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				break
+			}
+			values = append(values, val)
+		}
+		tres, err := parseSprioTres(strings.TrimSpace(fields[10]))
+		if len(values) != 7 || err != nil {
+			slog.Error(fmt.Sprintf("sprio parse error: failed on line %d `%s`", i, line))
+			jpf.errCounter.WithLabelValues(reasonParse).Inc()
+			continue
+		}
+		priorityMetrics = append(priorityMetrics, JobPriorityMetric{
+			JobId:         values[0],
+			Partition:     fields[1],
+			UserName:      fields[2],
+			Account:       fields[3],
+			Priority:      values[1],
+			Age:           values[2],
+			Fairshare:     values[3],
+			JobSize:       values[4],
+			PartitionPrio: values[5],
+			Qos:           values[6],
+			Tres:          tres,
+		})
+	}
+	return priorityMetrics, nil
+}
+
+func (jpf *JobPriorityCliFetcher) FetchMetrics(ctx context.Context) ([]JobPriorityMetric, error) {
+	return jpf.cache.FetchOrThrottle(func() ([]JobPriorityMetric, error) { return jpf.fetch(ctx) })
+}
+
+func (jpf *JobPriorityCliFetcher) ScrapeDuration() time.Duration {
+	return jpf.scraper.Duration()
+}
+
+type PriorityMetric struct {
+	count float64
+	// indexed like priorityComponents
+	sum []float64
+	max []float64
+}
+
+// aggregate priority components per partition and per account
+func parsePriorityMetrics(jobs []JobPriorityMetric) (map[string]*PriorityMetric, map[string]*PriorityMetric) {
+	partitionMetrics := make(map[string]*PriorityMetric)
+	accountMetrics := make(map[string]*PriorityMetric)
+	observe := func(metrics map[string]*PriorityMetric, key string, job *JobPriorityMetric) {
+		metric, ok := metrics[key]
+		if !ok {
+			metric = &PriorityMetric{
+				sum: make([]float64, len(priorityComponents)),
+				max: make([]float64, len(priorityComponents)),
+			}
+			metrics[key] = metric
+		}
+		metric.count++
+		for i, val := range job.components() {
+			metric.sum[i] += val
+			metric.max[i] = max(metric.max[i], val)
+		}
+	}
+	// sprio lists a job once per partition it's pending in. Accounts only count the highest priority line of each job
+	accountLines := make(map[float64]int)
+	for i := range jobs {
+		observe(partitionMetrics, jobs[i].Partition, &jobs[i])
+		if j, ok := accountLines[jobs[i].JobId]; !ok || jobs[i].Priority > jobs[j].Priority {
+			accountLines[jobs[i].JobId] = i
+		}
+	}
+	for _, i := range accountLines {
+		observe(accountMetrics, jobs[i].Account, &jobs[i])
+	}
+	return partitionMetrics, accountMetrics
+}
+
+// highest priority pending jobs, ties broken by the oldest job id
+func topPriorityJobs(jobs []JobPriorityMetric, n int) []JobPriorityMetric {
+	sorted := slices.Clone(jobs)
+	slices.SortFunc(sorted, func(a, b JobPriorityMetric) int {
+		if c := cmp.Compare(b.Priority, a.Priority); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.JobId, b.JobId)
+	})
+	return sorted[:min(n, len(sorted))]
+}
+
+type PriorityCollector struct {
+	fetcher SlurmMetricFetcher[JobPriorityMetric]
+	topJobs int
+	// aggregate metrics
+	partitionPriorityJobs *prometheus.Desc
+	partitionPrioritySum  *prometheus.Desc
+	partitionPriorityMax  *prometheus.Desc
+	accountPriorityJobs   *prometheus.Desc
+	accountPrioritySum    *prometheus.Desc
+	accountPriorityMax    *prometheus.Desc
+	// top pending jobs
+	jobPriority *prometheus.Desc
+	// sprio has no allocations, so accounts are ranked by pending jobs
+	labelLimits  LabelLimiter
+	foldedValues *prometheus.Desc
+	// exporter metrics
+	priorityScrapeDuration *prometheus.Desc
+}
+
+func NewPriorityCollector(config *Config) *PriorityCollector {
+	cliOpts := config.cliOpts
+	return &PriorityCollector{
+		fetcher: &JobPriorityCliFetcher{
+			scraper:    cliOpts.newCliScraper(cliOpts.sprio),
+			cache:      newConfiguredCache[JobPriorityMetric](config, "priority"),
+			errCounter: config.commandErrorCounter("sprio"),
+		},
+		topJobs:                cliOpts.priorityTopJobs,
+		labelLimits:            cliOpts.labelLimits,
+		foldedValues:           newFoldedValuesDesc(config, "priority"),
+		partitionPriorityJobs:  prometheus.NewDesc("slurm_partition_priority_jobs", "pending jobs with a priority per partition", []string{"partition"}, config.constLabels()),
+		partitionPrioritySum:   prometheus.NewDesc("slurm_partition_priority_sum", "sum of the weighted priority components of pending jobs per partition", []string{"partition", "component"}, config.constLabels()),
+		partitionPriorityMax:   prometheus.NewDesc("slurm_partition_priority_max", "max of the weighted priority components of pending jobs per partition", []string{"partition", "component"}, config.constLabels()),
+		accountPriorityJobs:    prometheus.NewDesc("slurm_account_priority_jobs", "pending jobs with a priority per account", []string{"account"}, config.constLabels()),
+		accountPrioritySum:     prometheus.NewDesc("slurm_account_priority_sum", "sum of the weighted priority components of pending jobs per account", []string{"account", "component"}, config.constLabels()),
+		accountPriorityMax:     prometheus.NewDesc("slurm_account_priority_max", "max of the weighted priority components of pending jobs per account", []string{"account", "component"}, config.constLabels()),
+		jobPriority:            prometheus.NewDesc("slurm_job_priority", "weighted priority components of the highest priority pending jobs", []string{"jobid", "user", "account", "partition", "component"}, config.constLabels()),
+		priorityScrapeDuration: prometheus.NewDesc("slurm_priority_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.sprio), nil, config.constLabels()),
+	}
+}
+
+// copy of jobs with the accounts past the account limit renamed to otherLabelValue
+// also returns how many accounts were folded
+func (pc *PriorityCollector) foldAccounts(jobs []JobPriorityMetric) ([]JobPriorityMetric, float64) {
+	if pc.labelLimits.maxAccounts <= 0 {
+		return jobs, 0
+	}
+	weights := make(map[string]float64)
+	for _, job := range jobs {
+		weights[job.Account]++
+	}
+	accounts := topLabelValues(weights, pc.labelLimits.maxAccounts)
+	folded := slices.Clone(jobs)
+	for i := range folded {
+		folded[i].Account = foldLabel(accounts, folded[i].Account)
+	}
+	return folded, foldedCount(weights, accounts)
+}
+
+func (pc *PriorityCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pc.partitionPriorityJobs
+	ch <- pc.partitionPrioritySum
+	ch <- pc.partitionPriorityMax
+	ch <- pc.accountPriorityJobs
+	ch <- pc.accountPrioritySum
+	ch <- pc.accountPriorityMax
+	ch <- pc.jobPriority
+	ch <- pc.foldedValues
+	ch <- pc.priorityScrapeDuration
+}
+
+func (pc *PriorityCollector) Collect(ch chan<- prometheus.Metric) {
+	pc.CollectWithContext(context.Background(), ch)
+}
+
+func (pc *PriorityCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	priorityMetrics, err := pc.fetcher.FetchMetrics(ctx)
+	ch <- prometheus.MustNewConstMetric(pc.priorityScrapeDuration, prometheus.GaugeValue, float64(pc.fetcher.ScrapeDuration().Milliseconds()))
+	if err != nil {
+		slog.Error(fmt.Sprintf("priority fetch error %q", err))
+		return
+	}
+	emitAggregates := func(metrics map[string]*PriorityMetric, jobs, sum, maxDesc *prometheus.Desc) {
+		for key, metric := range metrics {
+			ch <- prometheus.MustNewConstMetric(jobs, prometheus.GaugeValue, metric.count, key)
+			for i, component := range priorityComponents {
+				ch <- prometheus.MustNewConstMetric(sum, prometheus.GaugeValue, metric.sum[i], key, component)
+				ch <- prometheus.MustNewConstMetric(maxDesc, prometheus.GaugeValue, metric.max[i], key, component)
+			}
+		}
+	}
+	foldedJobs, folded := pc.foldAccounts(priorityMetrics)
+	if pc.labelLimits.maxAccounts > 0 {
+		ch <- prometheus.MustNewConstMetric(pc.foldedValues, prometheus.GaugeValue, folded, "account")
+	}
+	partitionMetrics, accountMetrics := parsePriorityMetrics(foldedJobs)
+	emitAggregates(partitionMetrics, pc.partitionPriorityJobs, pc.partitionPrioritySum, pc.partitionPriorityMax)
+	emitAggregates(accountMetrics, pc.accountPriorityJobs, pc.accountPrioritySum, pc.accountPriorityMax)
+	// the top jobs keep their real labels, they're already capped by topJobs
+	for _, job := range topPriorityJobs(priorityMetrics, pc.topJobs) {
+		jobid := fmt.Sprint(int64(job.JobId))
+		for i, val := range job.components() {
+			ch <- prometheus.MustNewConstMetric(pc.jobPriority, prometheus.GaugeValue, val, jobid, job.UserName, job.Account, job.Partition, priorityComponents[i])
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockPriorityFetcher() *JobPriorityCliFetcher {
+	return &JobPriorityCliFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/sprio.txt"},
+		cache:      NewAtomicThrottledCache[JobPriorityMetric](10),
+		errCounter: newMockErrorCounter(),
+	}
+}
+
+func TestParseSprioTres(t *testing.T) {
+	assert := assert.New(t)
+	tres, err := parseSprioTres("cpu=1000,gres/gpu=2000")
+	assert.NoError(err)
+	assert.Equal(3000., tres)
+	tres, err = parseSprioTres("")
+	assert.NoError(err)
+	assert.Zero(tres)
+	_, err = parseSprioTres("cpu")
+	assert.Error(err)
+}
+
+func TestPriorityFetch(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := newMockPriorityFetcher()
+	priorityMetrics, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	assert.Len(priorityMetrics, 4)
+	assert.Equal(JobPriorityMetric{
+		JobId:         51447053,
+		Partition:     "hw-h",
+		UserName:      "user2",
+		Account:       "account2",
+		Priority:      21000,
+		Age:           3000,
+		Fairshare:     2500,
+		JobSize:       500,
+		PartitionPrio: 2000,
+		Qos:           10000,
+		Tres:          3000,
+	}, priorityMetrics[2])
+	// a malformed priority and a truncated line
+	assert.Equal(2., CollectCounterValue(fetcher.errCounter.WithLabelValues(reasonParse)))
+}
+
+func TestParsePriorityMetrics(t *testing.T) {
+	assert := assert.New(t)
+	priorityMetrics, err := newMockPriorityFetcher().fetch(context.Background())
+	assert.NoError(err)
+	partitionMetrics, accountMetrics := parsePriorityMetrics(priorityMetrics)
+	hwh := partitionMetrics["hw-h"]
+	assert.Equal(3., hwh.count)
+	// total, age, fairshare, jobsize, partition, qos, tres
+	assert.Equal([]float64{42500, 4000, 12500, 1000, 6000, 16000, 3000}, hwh.sum)
+	assert.Equal([]float64{21000, 3000, 5000, 500, 2000, 10000, 3000}, hwh.max)
+	assert.Equal(3., accountMetrics["account1"].count)
+	assert.Equal(1., accountMetrics["account2"].count)
+}
+
+func TestParsePriorityMetrics_MultiPartition(t *testing.T) {
+	assert := assert.New(t)
+	jobs := []JobPriorityMetric{
+		{JobId: 1, Partition: "hw-h", Account: "account1", Priority: 100},
+		{JobId: 1, Partition: "hw-l", Account: "account1", Priority: 300},
+		{JobId: 2, Partition: "hw-l", Account: "account1", Priority: 200},
+	}
+	partitionMetrics, accountMetrics := parsePriorityMetrics(jobs)
+	assert.Equal(1., partitionMetrics["hw-h"].count)
+	assert.Equal(2., partitionMetrics["hw-l"].count)
+	// job 1 counts once, with its hw-l priority
+	assert.Equal(2., accountMetrics["account1"].count)
+	assert.Equal(500., accountMetrics["account1"].sum[0])
+	assert.Equal(300., accountMetrics["account1"].max[0])
+}
+
+func TestPriorityFoldAccounts(t *testing.T) {
+	assert := assert.New(t)
+	jobs := []JobPriorityMetric{{JobId: 1, Account: "account1"}, {JobId: 2, Account: "account2"}, {JobId: 3, Account: "account2"}}
+	pc := &PriorityCollector{labelLimits: LabelLimiter{maxAccounts: 1}}
+	folded, count := pc.foldAccounts(jobs)
+	assert.Equal(1., count)
+	assert.Equal([]string{otherLabelValue, "account2", "account2"}, []string{folded[0].Account, folded[1].Account, folded[2].Account})
+	// the fetched jobs are left untouched
+	assert.Equal("account1", jobs[0].Account)
+}
+
+func TestTopPriorityJobs(t *testing.T) {
+	assert := assert.New(t)
+	jobs := []JobPriorityMetric{
+		{JobId: 3, Priority: 10},
+		{JobId: 2, Priority: 20},
+		{JobId: 1, Priority: 10},
+	}
+	top := topPriorityJobs(jobs, 2)
+	assert.Equal([]float64{2, 1}, []float64{top[0].JobId, top[1].JobId})
+	assert.Len(topPriorityJobs(jobs, 10), 3)
+	// the fetched jobs are left untouched
+	assert.Equal(3., jobs[0].JobId)
+}
+
+func TestPriorityCollector(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	pc := NewPriorityCollector(config)
+	pc.fetcher = newMockPriorityFetcher()
+	pc.topJobs = 1
+	pcChan := make(chan prometheus.Metric)
+	go func() {
+		pc.Collect(pcChan)
+		close(pcChan)
+	}()
+	priorityMetrics := make([]prometheus.Metric, 0)
+	for metric, ok := <-pcChan; ok; metric, ok = <-pcChan {
+		priorityMetrics = append(priorityMetrics, metric)
+	}
+	// 4 partitions and accounts with a job count and 7 sums and maxes each, 7 components of the top job and the scrape duration
+	assert.Len(priorityMetrics, 4*(1+2*7)+7+1)
+}
+
+func TestPriorityDescribe(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	pc := NewPriorityCollector(config)
+	pcChan := make(chan *prometheus.Desc)
+	go func() {
+		pc.Describe(pcChan)
+		close(pcChan)
+	}()
+	descs := make([]*prometheus.Desc, 0)
+	for desc, ok := <-pcChan; ok; desc, ok = <-pcChan {
+		descs = append(descs, desc)
+	}
+	assert.Len(descs, 9)
+}
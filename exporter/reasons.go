@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// rewrites pending reasons matching Match into Replace, expanded with the regex captures i.e ${1}
+// reasons matching no rule are exported as is
+type ReasonRule struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+	// lowercase the rewritten reason, slurm reasons are CamelCase
+	Lower bool `yaml:"lower"`
+}
+
+// limit reasons embed the limited resource i.e AssocGrpCpuLimit, QOSMaxJobsPerUserLimit or PartitionTimeLimit
+// while node reasons embed the unavailable nodes i.e ReqNodeNotAvail, UnavailableNodes:cs[100-102]
+var defaultReasonRules = []ReasonRule{
+	{Match: regexp.QuoteMeta(reqNodeNotAvailReason), Replace: fmt.Sprintf("(%s)", reqNodeNotAvailReason)},
+	{Match: `^(Assoc|QOS)(?:Grp|Max)(\w+?)(?:Limit)?$`, Replace: "limit:${1}:${2}", Lower: true},
+	{Match: `^Partition(\w+)Limit$`, Replace: "limit:partition:${1}", Lower: true},
+}
+
+type compiledReasonRule struct {
+	re      *regexp.Regexp
+	replace string
+	lower   bool
+}
+
+// first matching rule wins. Configured rules are tried before the defaults so they can override them
+type ReasonNormalizer struct {
+	rules []compiledReasonRule
+}
+
+func NewReasonNormalizer(rules []ReasonRule) (*ReasonNormalizer, error) {
+	rn := new(ReasonNormalizer)
+	for _, rule := range append(append([]ReasonRule{}, rules...), defaultReasonRules...) {
+		re, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pending reason rule %q: %w", rule.Match, err)
+		}
+		rn.rules = append(rn.rules, compiledReasonRule{re: re, replace: rule.Replace, lower: rule.Lower})
+	}
+	return rn, nil
+}
+
+// the default rules are static, failing to compile them is a bug
+var defaultReasonNormalizer = func() *ReasonNormalizer {
+	rn, err := NewReasonNormalizer(nil)
+	if err != nil {
+		panic(err)
+	}
+	return rn
+}()
+
+// a nil normalizer applies the default rules only
+func (rn *ReasonNormalizer) normalize(reason string) string {
+	if rn == nil {
+		rn = defaultReasonNormalizer
+	}
+	for _, rule := range rn.rules {
+		match := rule.re.FindStringSubmatchIndex(reason)
+		if match == nil {
+			continue
+		}
+		normalized := string(rule.re.ExpandString(nil, rule.replace, reason, match))
+		if rule.lower {
+			normalized = strings.ToLower(normalized)
+		}
+		return normalized
+	}
+	return reason
+}
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReasonNormalizer_Defaults(t *testing.T) {
+	assert := assert.New(t)
+	normalizer, err := NewReasonNormalizer(nil)
+	assert.NoError(err)
+	for reason, expected := range map[string]string{
+		"AssocGrpCpuLimit":       "limit:assoc:cpu",
+		"AssocGrpMemLimit":       "limit:assoc:mem",
+		"AssocMaxJobsLimit":      "limit:assoc:jobs",
+		"QOSGrpGRES":             "limit:qos:gres",
+		"QOSMaxJobsPerUserLimit": "limit:qos:jobsperuser",
+		"PartitionTimeLimit":     "limit:partition:time",
+		"Dependency":             "Dependency",
+		"Resources":              "Resources",
+		"Priority":               "Priority",
+		// squeue json and the cli fallback report node reasons with and without parentheses
+		"ReqNodeNotAvail, UnavailableNodes:cs[100,101]":   "(ReqNodeNotAvail, UnavailableNodes)",
+		"(ReqNodeNotAvail, UnavailableNodes:cs[100,101])": "(ReqNodeNotAvail, UnavailableNodes)",
+	} {
+		assert.Equal(expected, normalizer.normalize(reason), reason)
+	}
+}
+
+func TestReasonNormalizer_Configured(t *testing.T) {
+	assert := assert.New(t)
+	normalizer, err := NewReasonNormalizer([]ReasonRule{
+		{Match: `^AssocGrp(\w+)Limit$`, Replace: "assoc_${1}"},
+		{Match: `^BeginTime$`, Replace: "Scheduled"},
+	})
+	assert.NoError(err)
+	// configured rules take precedence over the defaults
+	assert.Equal("assoc_Cpu", normalizer.normalize("AssocGrpCpuLimit"))
+	assert.Equal("Scheduled", normalizer.normalize("BeginTime"))
+	assert.Equal("limit:qos:cpu", normalizer.normalize("QOSGrpCpuLimit"))
+
+	_, err = NewReasonNormalizer([]ReasonRule{{Match: "(Assoc"}})
+	assert.Error(err)
+}
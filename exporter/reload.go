@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+// overlay the settings in a yaml config file onto cliFlags. Keys missing from the file are left untouched
+// keys use the yaml tags of CliFlags, i.e poll_limit, squeue_cli, cli_timeout
+func ReadConfigFile(path string, cliFlags *CliFlags) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	decoder := yaml.NewDecoder(file)
+	// fail loudly on typos instead of silently ignoring a setting
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cliFlags); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// collectors and handlers built from a single config
+type exporterState struct {
+	config  *Config
+	metrics http.Handler
+	trace   *TraceCollector
+	// stops background polling for this state
+	cancel context.CancelFunc
+}
+
+// serves metrics from the exporter built by the last successful reload
+// the listen address and telemetry/trace paths are bound at startup and need a restart to change
+type Reloader struct {
+	sync.Mutex
+	load            func() (*Config, error)
+	state           atomic.Pointer[exporterState]
+	reloadSuccess   prometheus.Gauge
+	reloadTimestamp prometheus.Gauge
+}
+
+// load the initial config. Unlike later reloads, failing here is fatal to the caller
+func NewReloader(load func() (*Config, error)) (*Reloader, error) {
+	r := &Reloader{
+		load: load,
+		reloadSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "slurm_exporter_config_last_reload_successful",
+			Help: "1 if the last config reload succeeded",
+		}),
+		reloadTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "slurm_exporter_config_last_reload_success_timestamp_seconds",
+			Help: "unix time of the last successful config reload",
+		}),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// rebuild all collectors from a freshly loaded config. On failure the previous collectors keep serving
+func (r *Reloader) Reload() error {
+	r.Lock()
+	defer r.Unlock()
+	config, err := r.load()
+	if err != nil {
+		r.reloadSuccess.Set(0)
+		slog.Error(fmt.Sprintf("config reload failed, keeping the previous config: %q", err))
+		return err
+	}
+	initLogger(config)
+	ctx, cancel := context.WithCancel(context.Background())
+	collectors, traceCollector := newCollectors(ctx, config)
+	collectors = append(collectors, r.reloadSuccess, r.reloadTimestamp)
+	old := r.state.Swap(&exporterState{
+		config:  config,
+		metrics: NewPromHTTPServer(config.cliOpts.excludeFilter, collectors...),
+		trace:   traceCollector,
+		cancel:  cancel,
+	})
+	if old != nil {
+		old.cancel()
+	}
+	r.reloadSuccess.Set(1)
+	r.reloadTimestamp.Set(float64(time.Now().UnixMilli()) / 1e3)
+	slog.Info("config loaded")
+	return nil
+}
+
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.state.Load().metrics.ServeHTTP(w, req)
+}
+
+// serves trace uploads while tracing is enabled by the current config
+func (r *Reloader) uploadTrace(w http.ResponseWriter, req *http.Request) {
+	trace := r.state.Load().trace
+	if trace == nil {
+		http.NotFound(w, req)
+		return
+	}
+	trace.uploadTrace(w, req)
+}
+
+// register the metrics and trace paths of the current config on mux, returning that config
+func (r *Reloader) Register(mux *http.ServeMux) *Config {
+	config := r.state.Load().config
+	mux.Handle(config.MetricsPath, r)
+	if config.TraceConf.enabled {
+		mux.HandleFunc(config.TraceConf.path, r.uploadTrace)
+	}
+	return config
+}
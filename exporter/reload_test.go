@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"log/slog"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	assert := assert.New(t)
+	cliFlags := CliFlags{SlurmLicEnabled: true}
+	assert.NoError(ReadConfigFile("fixtures/config.yaml", &cliFlags))
+	assert.Equal(":9093", cliFlags.ListenAddress)
+	assert.Equal(5., cliFlags.SlurmPollLimit)
+	assert.Equal(2, cliFlags.SlurmCliRetries)
+	assert.Equal("cat fixtures/squeue_fallback.txt", cliFlags.SlurmSqueueOverride)
+	assert.Equal([]ReasonRule{{Match: "^BeginTime$", Replace: "scheduled"}}, cliFlags.PendingReasonRules)
+	// keys missing from the file are left untouched
+	assert.True(cliFlags.SlurmLicEnabled)
+	config, err := NewConfig(&cliFlags)
+	assert.NoError(err)
+	assert.Equal(slog.LevelDebug, config.LogLevel)
+	assert.Equal(30*time.Second, config.cliOpts.timeout)
+	assert.Equal(250*time.Millisecond, config.cliOpts.backoff)
+	assert.Equal("scheduled", config.cliOpts.pendingReasons.normalize("BeginTime"))
+	scraper := config.cliOpts.newCliScraper(config.cliOpts.sinfo)
+	assert.Equal(30*time.Second, scraper.timeout)
+	assert.Equal(2, scraper.retries)
+}
+
+func TestReadConfigFile_UnknownKey(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(os.WriteFile(path, []byte("poll_limt: 5\n"), 0o600))
+	assert.ErrorContains(ReadConfigFile(path, new(CliFlags)), "poll_limt")
+	assert.Error(ReadConfigFile(filepath.Join(t.TempDir(), "missing.yaml"), new(CliFlags)))
+}
+
+func TestReloader(t *testing.T) {
+	assert := assert.New(t)
+	var loadErr error
+	squeue := "cat fixtures/squeue_fallback.txt"
+	reloader, err := NewReloader(func() (*Config, error) {
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return NewConfig(&CliFlags{
+			SlurmCliFallback:    true,
+			SlurmSqueueOverride: squeue,
+			SlurmSinfoOverride:  "cat fixtures/sinfo_fallback.txt",
+		})
+	})
+	assert.NoError(err)
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		reloader.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(200, w.Code)
+		return w.Body.String()
+	}
+	txt := scrape()
+	assert.Contains(txt, "slurm_exporter_config_last_reload_successful 1")
+	assert.Contains(txt, "cat fixtures/squeue_fallback.txt")
+
+	// collectors are rebuilt from the new config
+	squeue = "cat ./fixtures/squeue_fallback.txt"
+	assert.NoError(reloader.Reload())
+	assert.Contains(scrape(), "cat ./fixtures/squeue_fallback.txt")
+
+	// failed reloads keep serving the previous config
+	loadErr = errors.New("bad config")
+	assert.Error(reloader.Reload())
+	txt = scrape()
+	assert.Contains(txt, "slurm_exporter_config_last_reload_successful 0")
+	assert.Contains(txt, "cat ./fixtures/squeue_fallback.txt")
+}
+
+func TestReloader_Register(t *testing.T) {
+	assert := assert.New(t)
+	reloader, err := NewReloader(func() (*Config, error) {
+		return NewConfig(&CliFlags{TraceEnabled: true})
+	})
+	assert.NoError(err)
+	mux := http.NewServeMux()
+	config := reloader.Register(mux)
+	assert.Equal("/metrics", config.MetricsPath)
+	_, pattern := mux.Handler(httptest.NewRequest(http.MethodPost, "/trace", nil))
+	assert.Equal("/trace", pattern)
+}
+
+func TestReloader_TraceDisabled(t *testing.T) {
+	assert := assert.New(t)
+	reloader, err := NewReloader(func() (*Config, error) {
+		return NewConfig(new(CliFlags))
+	})
+	assert.NoError(err)
+	w := httptest.NewRecorder()
+	reloader.uploadTrace(w, httptest.NewRequest(http.MethodPost, "/trace", nil))
+	assert.Equal(http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,328 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rivosinc/prometheus-slurm-exporter/exporter/hostlist"
+)
+
+type ReservationMetric struct {
+	Name string `json:"name"`
+	// unix seconds. An unset or infinite end time is 0
+	StartTime OptionalFloat `json:"start_time"`
+	EndTime   OptionalFloat `json:"end_time"`
+	NodeCount OptionalFloat `json:"node_count"`
+	CoreCount OptionalFloat `json:"core_count"`
+	// compressed hostlist i.e cs[25,31]
+	NodeList  string   `json:"node_list"`
+	Partition string   `json:"partition"`
+	Flags     []string `json:"flags"`
+	// only reported by the cli fallback, json states are derived from the start and end times
+	State string `json:"-"`
+}
+
+// active while the reservation window covers now, reservations yet to start are inactive
+func (rm *ReservationMetric) state(now time.Time) string {
+	if rm.State != "" {
+		return strings.ToLower(rm.State)
+	}
+	ts := float64(now.Unix())
+	if float64(rm.StartTime) <= ts && (rm.EndTime == 0 || ts < float64(rm.EndTime)) {
+		return "active"
+	}
+	return "inactive"
+}
+
+// openapi/v0.0.37 schema
+type scontrolReservationResponse struct {
+	Meta         slurmMeta           `json:"meta"`
+	Errors       []string            `json:"errors"`
+	Reservations []ReservationMetric `json:"reservations"`
+}
+
+// data_parser/v0.0.40+ schema. Only the time and count fields changed and OptionalFloat decodes both
+type dataParserReservationResponse struct {
+	Meta         slurmMeta           `json:"meta"`
+	Errors       []dataParserError   `json:"errors"`
+	Reservations []ReservationMetric `json:"reservations"`
+}
+
+// decode scontrol reservation json with the schema reported by its meta block
+func parseReservationMetrics(data []byte) ([]ReservationMetric, error) {
+	meta, err := parseSlurmMeta(data)
+	if err != nil {
+		return nil, err
+	}
+	if !meta.isDataParser() {
+		resp := new(scontrolReservationResponse)
+		if err := json.Unmarshal(data, resp); err != nil {
+			return nil, err
+		}
+		for _, e := range resp.Errors {
+			slog.Error(fmt.Sprintf("scontrol reservation error response %q", e))
+		}
+		return resp.Reservations, nil
+	}
+	resp := new(dataParserReservationResponse)
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, err
+	}
+	for _, e := range resp.Errors {
+		slog.Error(fmt.Sprintf("scontrol reservation error response %q", e.String()))
+	}
+	return resp.Reservations, nil
+}
+
+type ReservationJsonFetcher struct {
+	scraper    SlurmByteScraper
+	cache      *AtomicThrottledCache[ReservationMetric]
+	errCounter *prometheus.CounterVec
+}
+
+func (rjf *ReservationJsonFetcher) fetch(ctx context.Context) ([]ReservationMetric, error) {
+	data, err := rjf.scraper.FetchRawBytes(ctx)
+	if err != nil {
+		observeCommandError(rjf.errCounter, err)
+		return nil, err
+	}
+	reservations, err := parseReservationMetrics(data)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Unmarshaling reservation metrics %q", err))
+		rjf.errCounter.WithLabelValues(reasonParse).Inc()
+		return nil, err
+	}
+	return reservations, nil
+}
+
+func (rjf *ReservationJsonFetcher) FetchMetrics(ctx context.Context) ([]ReservationMetric, error) {
+	return rjf.cache.FetchOrThrottle(func() ([]ReservationMetric, error) { return rjf.fetch(ctx) })
+}
+
+func (rjf *ReservationJsonFetcher) ScrapeDuration() time.Duration {
+	return rjf.scraper.Duration()
+}
+
+type ReservationCliFallbackFetcher struct {
+	scraper    SlurmByteScraper
+	cache      *AtomicThrottledCache[ReservationMetric]
+	errCounter *prometheus.CounterVec
+}
+
+// scontrol prints unset fields as (null)
+func scontrolValue(value string) string {
+	if value == "(null)" {
+		return ""
+	}
+	return value
+}
+
+// parse `scontrol show reservation -o`, one reservation per line of key=value pairs
+func (rcf *ReservationCliFallbackFetcher) fetch(ctx context.Context) ([]ReservationMetric, error) {
+	scontrol, err := rcf.scraper.FetchRawBytes(ctx)
+	if err != nil {
+		observeCommandError(rcf.errCounter, err)
+		return nil, err
+	}
+	reservations := make([]ReservationMetric, 0)
+	for i, line := range bytes.Split(bytes.TrimSpace(scontrol), []byte("\n")) {
+		// i.e No reservations in the system
+		if !bytes.HasPrefix(line, []byte("ReservationName=")) {
+			continue
+		}
+		fields := make(map[string]string)
+		for _, field := range strings.Fields(string(line)) {
+			// values may contain = themselves, i.e TRES=cpu=128
+			key, value, _ := strings.Cut(field, "=")
+			fields[key] = scontrolValue(value)
+		}
+		startTime, startErr := parseSacctTime(fields["StartTime"])
+		endTime, endErr := parseSacctTime(fields["EndTime"])
+		nodeCount, nodeErr := strconv.ParseFloat(fields["NodeCnt"], 64)
+		coreCount, coreErr := strconv.ParseFloat(fields["CoreCnt"], 64)
+		if err := errors.Join(startErr, endErr, nodeErr, coreErr); err != nil {
+			slog.Error(fmt.Sprintf("scontrol reservation parse error: failed on line %d `%s`: %q", i, line, err))
+			rcf.errCounter.WithLabelValues(reasonParse).Inc()
+			continue
+		}
+		reservation := ReservationMetric{
+			Name:      fields["ReservationName"],
+			NodeCount: OptionalFloat(nodeCount),
+			CoreCount: OptionalFloat(coreCount),
+			NodeList:  fields["Nodes"],
+			Partition: fields["PartitionName"],
+			State:     fields["State"],
+		}
+		if !startTime.IsZero() {
+			reservation.StartTime = OptionalFloat(startTime.Unix())
+		}
+		if !endTime.IsZero() {
+			reservation.EndTime = OptionalFloat(endTime.Unix())
+		}
+		if flags := fields["Flags"]; flags != "" {
+			reservation.Flags = strings.Split(flags, ",")
+		}
+		reservations = append(reservations, reservation)
+	}
+	return reservations, nil
+}
+
+func (rcf *ReservationCliFallbackFetcher) FetchMetrics(ctx context.Context) ([]ReservationMetric, error) {
+	return rcf.cache.FetchOrThrottle(func() ([]ReservationMetric, error) { return rcf.fetch(ctx) })
+}
+
+func (rcf *ReservationCliFallbackFetcher) ScrapeDuration() time.Duration {
+	return rcf.scraper.Duration()
+}
+
+// cpus of the reserved nodes, summed from the node collector data
+type ReservationCpuMetric struct {
+	total float64
+	alloc float64
+	idle  float64
+}
+
+// join reservations against node data by hostname. Reservations with an unparsable node list are skipped
+func parseReservationCpuMetrics(reservations []ReservationMetric, nodes []NodeMetric) map[string]*ReservationCpuMetric {
+	nodeIndex := make(map[string]*NodeMetric, len(nodes))
+	for i := range nodes {
+		nodeIndex[nodes[i].Hostname] = &nodes[i]
+	}
+	cpuMetrics := make(map[string]*ReservationCpuMetric)
+	for _, reservation := range reservations {
+		hosts, err := hostlist.Expand(reservation.NodeList)
+		if err != nil {
+			slog.Error(fmt.Sprintf("reservation %s node list error %q", reservation.Name, err))
+			continue
+		}
+		metric := new(ReservationCpuMetric)
+		for _, host := range hosts {
+			if node, ok := nodeIndex[host]; ok {
+				metric.total += node.Cpus
+				metric.alloc += node.AllocCpus
+				metric.idle += node.IdleCpus
+			}
+		}
+		cpuMetrics[reservation.Name] = metric
+	}
+	return cpuMetrics
+}
+
+type ReservationCollector struct {
+	fetcher SlurmMetricFetcher[ReservationMetric]
+	// shared with the node collector so reserved cpus don't cost an extra sinfo call
+	nodeFetcher SlurmMetricFetcher[NodeMetric]
+	now         func() time.Time
+	// reservation metrics
+	reservationInfo      *prometheus.Desc
+	reservationStartTime *prometheus.Desc
+	reservationEndTime   *prometheus.Desc
+	reservationNodes     *prometheus.Desc
+	reservationCores     *prometheus.Desc
+	// reserved cpus of active reservations
+	reservationCpusTotal *prometheus.Desc
+	reservationCpusAlloc *prometheus.Desc
+	reservationCpusIdle  *prometheus.Desc
+	// exporter metrics
+	reservationScrapeDuration *prometheus.Desc
+}
+
+// slurmrestd only speaks json so it takes precedence over the cli fallback
+func NewReservationCollector(config *Config, nodeFetcher SlurmMetricFetcher[NodeMetric]) *ReservationCollector {
+	cliOpts := config.cliOpts
+	var fetcher SlurmMetricFetcher[ReservationMetric]
+	if cliOpts.fallback && !cliOpts.useRest(restReservations) {
+		fetcher = &ReservationCliFallbackFetcher{
+			scraper:    cliOpts.newCliScraper(cliOpts.reservations),
+			cache:      newConfiguredCache[ReservationMetric](config, "reservations"),
+			errCounter: config.commandErrorCounter("scontrol"),
+		}
+	} else {
+		fetcher = &ReservationJsonFetcher{
+			scraper:    cliOpts.newScraper(restReservations, cliOpts.reservations),
+			cache:      newConfiguredCache[ReservationMetric](config, "reservations"),
+			errCounter: config.commandErrorCounter("scontrol"),
+		}
+	}
+	return &ReservationCollector{
+		fetcher:                   fetcher,
+		nodeFetcher:               nodeFetcher,
+		now:                       time.Now,
+		reservationInfo:           prometheus.NewDesc("slurm_reservation_info", "reservation state, partition and flags", []string{"reservation", "state", "partition", "flags"}, config.constLabels()),
+		reservationStartTime:      prometheus.NewDesc("slurm_reservation_start_time_seconds", "reservation start time", []string{"reservation"}, config.constLabels()),
+		reservationEndTime:        prometheus.NewDesc("slurm_reservation_end_time_seconds", "reservation end time, unset for reservations without an end", []string{"reservation"}, config.constLabels()),
+		reservationNodes:          prometheus.NewDesc("slurm_reservation_node_count", "nodes in the reservation", []string{"reservation"}, config.constLabels()),
+		reservationCores:          prometheus.NewDesc("slurm_reservation_core_count", "cores in the reservation", []string{"reservation"}, config.constLabels()),
+		reservationCpusTotal:      prometheus.NewDesc("slurm_reservation_cpus_total", "cpus of the nodes in an active reservation", []string{"reservation"}, config.constLabels()),
+		reservationCpusAlloc:      prometheus.NewDesc("slurm_reservation_cpus_alloc", "allocated cpus of the nodes in an active reservation", []string{"reservation"}, config.constLabels()),
+		reservationCpusIdle:       prometheus.NewDesc("slurm_reservation_cpus_idle", "idle cpus of the nodes in an active reservation", []string{"reservation"}, config.constLabels()),
+		reservationScrapeDuration: prometheus.NewDesc("slurm_reservation_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.reservations), nil, config.constLabels()),
+	}
+}
+
+func (rc *ReservationCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rc.reservationInfo
+	ch <- rc.reservationStartTime
+	ch <- rc.reservationEndTime
+	ch <- rc.reservationNodes
+	ch <- rc.reservationCores
+	ch <- rc.reservationCpusTotal
+	ch <- rc.reservationCpusAlloc
+	ch <- rc.reservationCpusIdle
+	ch <- rc.reservationScrapeDuration
+}
+
+func (rc *ReservationCollector) Collect(ch chan<- prometheus.Metric) {
+	rc.CollectWithContext(context.Background(), ch)
+}
+
+func (rc *ReservationCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	reservations, err := rc.fetcher.FetchMetrics(ctx)
+	ch <- prometheus.MustNewConstMetric(rc.reservationScrapeDuration, prometheus.GaugeValue, float64(rc.fetcher.ScrapeDuration().Milliseconds()))
+	if err != nil {
+		slog.Error(fmt.Sprintf("reservation fetch error %q", err))
+		return
+	}
+	now := rc.now()
+	active := make([]ReservationMetric, 0)
+	for _, reservation := range reservations {
+		state := reservation.state(now)
+		if state == "active" {
+			active = append(active, reservation)
+		}
+		ch <- prometheus.MustNewConstMetric(rc.reservationInfo, prometheus.GaugeValue, 1, reservation.Name, state, reservation.Partition, strings.Join(reservation.Flags, ","))
+		ch <- prometheus.MustNewConstMetric(rc.reservationStartTime, prometheus.GaugeValue, float64(reservation.StartTime), reservation.Name)
+		if reservation.EndTime > 0 {
+			ch <- prometheus.MustNewConstMetric(rc.reservationEndTime, prometheus.GaugeValue, float64(reservation.EndTime), reservation.Name)
+		}
+		ch <- prometheus.MustNewConstMetric(rc.reservationNodes, prometheus.GaugeValue, float64(reservation.NodeCount), reservation.Name)
+		ch <- prometheus.MustNewConstMetric(rc.reservationCores, prometheus.GaugeValue, float64(reservation.CoreCount), reservation.Name)
+	}
+	if len(active) == 0 {
+		return
+	}
+	// reserved cpus only reflect the reservation while it's active, node usage before then belongs to other jobs
+	nodes, err := rc.nodeFetcher.FetchMetrics(ctx)
+	if err != nil {
+		slog.Error(fmt.Sprintf("reservation node fetch error %q", err))
+		return
+	}
+	for name, metric := range parseReservationCpuMetrics(active, nodes) {
+		ch <- prometheus.MustNewConstMetric(rc.reservationCpusTotal, prometheus.GaugeValue, metric.total, name)
+		ch <- prometheus.MustNewConstMetric(rc.reservationCpusAlloc, prometheus.GaugeValue, metric.alloc, name)
+		ch <- prometheus.MustNewConstMetric(rc.reservationCpusIdle, prometheus.GaugeValue, metric.idle, name)
+	}
+}
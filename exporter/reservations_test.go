@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// between the start and end of the maint reservation, before project1 starts
+var reservationNow = time.Unix(1695261600, 0)
+
+func newMockNodeFallbackFetcher() *NodeCliFallbackFetcher {
+	return &NodeCliFallbackFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/sinfo_fallback.txt"},
+		errorCounter: newMockErrorCounter(),
+		cache:        NewAtomicThrottledCache[NodeMetric](1),
+	}
+}
+
+func TestParseReservationMetrics(t *testing.T) {
+	assert := assert.New(t)
+	for _, fixture := range []string{"fixtures/reservations.json", "fixtures/reservations_2405.json"} {
+		data, err := (&MockScraper{fixture: fixture}).FetchRawBytes(context.Background())
+		assert.NoError(err)
+		reservations, err := parseReservationMetrics(data)
+		assert.NoError(err, fixture)
+		assert.Len(reservations, 2)
+		maint := reservations[0]
+		assert.Equal("maint", maint.Name)
+		assert.Equal(OptionalFloat(1695254400), maint.StartTime)
+		assert.Equal(OptionalFloat(1695297600), maint.EndTime)
+		assert.Equal(OptionalFloat(2), maint.NodeCount)
+		assert.Equal(OptionalFloat(128), maint.CoreCount)
+		assert.Equal("cs[25,31]", maint.NodeList)
+		assert.Equal([]string{"MAINT", "SPEC_NODES"}, maint.Flags)
+		assert.Equal("active", maint.state(reservationNow))
+		assert.Equal("hw", reservations[1].Partition)
+		assert.Equal("inactive", reservations[1].state(reservationNow))
+	}
+}
+
+func TestReservationFallbackFetch(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &ReservationCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/reservations_fallback.txt"},
+		cache:      NewAtomicThrottledCache[ReservationMetric](1),
+		errCounter: newMockErrorCounter(),
+	}
+	reservations, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	assert.Len(reservations, 2)
+	maint := reservations[0]
+	assert.Equal(OptionalFloat(sacctTime("2023-09-21T00:00:00").Unix()), maint.StartTime)
+	assert.Equal(OptionalFloat(sacctTime("2023-09-21T12:00:00").Unix()), maint.EndTime)
+	assert.Equal("", maint.Partition)
+	assert.Equal([]string{"MAINT", "SPEC_NODES"}, maint.Flags)
+	// states come from scontrol rather than the clock
+	assert.Equal("active", maint.state(time.Time{}))
+	project := reservations[1]
+	assert.Zero(project.EndTime)
+	assert.Equal("hw", project.Partition)
+	assert.Equal("inactive", project.state(reservationNow))
+	// the unparsable start time
+	assert.Equal(1., CollectCounterValue(fetcher.errCounter.WithLabelValues(reasonParse)))
+
+	fetcher.scraper = NewCliScraper("echo", "No reservations in the system")
+	reservations, err = fetcher.fetch(context.Background())
+	assert.NoError(err)
+	assert.Empty(reservations)
+}
+
+func TestParseReservationCpuMetrics(t *testing.T) {
+	assert := assert.New(t)
+	nodes, err := newMockNodeFallbackFetcher().fetch(context.Background())
+	assert.NoError(err)
+	reservations := []ReservationMetric{
+		{Name: "maint", NodeList: "cs[25,31]"},
+		{Name: "missing", NodeList: "gpu01"},
+		{Name: "broken", NodeList: "cs[25"},
+	}
+	cpuMetrics := parseReservationCpuMetrics(reservations, nodes)
+	// cs25 is fully allocated while cs31 is idle
+	assert.Equal(ReservationCpuMetric{total: 128, alloc: 64, idle: 64}, *cpuMetrics["maint"])
+	assert.Equal(ReservationCpuMetric{}, *cpuMetrics["missing"])
+	assert.NotContains(cpuMetrics, "broken")
+}
+
+func TestReservationCollector(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	rc := NewReservationCollector(config, newMockNodeFallbackFetcher())
+	rc.fetcher = &ReservationJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/reservations_2405.json"},
+		cache:      NewAtomicThrottledCache[ReservationMetric](1),
+		errCounter: newMockErrorCounter(),
+	}
+	rc.now = func() time.Time { return reservationNow }
+	rcChan := make(chan prometheus.Metric)
+	go func() {
+		rc.Collect(rcChan)
+		close(rcChan)
+	}()
+	reservationMetrics := make([]prometheus.Metric, 0)
+	for metric, ok := <-rcChan; ok; metric, ok = <-rcChan {
+		reservationMetrics = append(reservationMetrics, metric)
+	}
+	// 5 metrics per reservation without the end time of project1, cpus of the active maint reservation and the scrape duration
+	assert.Len(reservationMetrics, 2*5-1+3+1)
+}
+
+func TestReservationDescribe(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	rc := NewReservationCollector(config, newMockNodeFallbackFetcher())
+	rcChan := make(chan *prometheus.Desc)
+	go func() {
+		rc.Describe(rcChan)
+		close(rcChan)
+	}()
+	descs := make([]*prometheus.Desc, 0)
+	for desc, ok := <-rcChan; ok; desc, ok = <-rcChan {
+		descs = append(descs, desc)
+	}
+	assert.Len(descs, 9)
+}
+
+func TestNewReservationCollector_Fallback(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{SlurmCliFallback: true})
+	assert.NoError(err)
+	rc := NewReservationCollector(config, newMockNodeFallbackFetcher())
+	assert.IsType(&ReservationCliFallbackFetcher{}, rc.fetcher)
+	assert.Equal([]string{"scontrol", "show", "reservation", "-o"}, config.cliOpts.reservations)
+}
@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"log/slog"
+)
+
+const (
+	// prefix used to denote that slurmrestd is listening on a local unix socket
+	unixSocketScheme string = "unix://"
+	// host used in the request url when dialing a unix socket. Ignored by the transport
+	unixSocketHost string = "slurmrestd"
+	// collector keys that can be served by slurmrestd
+	restJobs         string = "jobs"
+	restNodes        string = "nodes"
+	restDiags        string = "diags"
+	restLicenses     string = "licenses"
+	restReservations string = "reservations"
+)
+
+// endpoints per collector relative to /slurm/<api version>
+var restEndpoints = map[string]string{
+	restJobs:         "jobs",
+	restNodes:        "nodes",
+	restDiags:        "diag",
+	restLicenses:     "licenses",
+	restReservations: "reservations",
+}
+
+type RestOpts struct {
+	// either http(s)://host:port or unix:///path/to/slurmrestd.sock
+	url        string
+	user       string
+	token      string
+	apiVersion string
+	// collectors that should be served by slurmrestd instead of the cli
+	collectors map[string]bool
+}
+
+// implements SlurmByteScraper by fetching data from slurmrestd
+type RestScraper struct {
+	client   *http.Client
+	url      string
+	user     string
+	token    string
+	duration time.Duration
+}
+
+func (rs *RestScraper) Duration() time.Duration {
+	return rs.duration
+}
+
+func (rs *RestScraper) FetchRawBytes(ctx context.Context) ([]byte, error) {
+	defer func(t time.Time) { rs.duration = time.Since(t) }(time.Now())
+	defer duration(rs.url, time.Now())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rs.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if rs.user != "" {
+		req.Header.Set("X-SLURM-USER-NAME", rs.user)
+	}
+	if rs.token != "" {
+		req.Header.Set("X-SLURM-USER-TOKEN", rs.token)
+	}
+	resp, err := rs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("slurmrestd request %s failed with %s: %s", rs.url, resp.Status, body)
+	}
+	return body, nil
+}
+
+// builds a scraper for the endpoint backing a particular collector
+func NewRestScraper(opts *RestOpts, collector string) (*RestScraper, error) {
+	endpoint, ok := restEndpoints[collector]
+	if !ok {
+		return nil, fmt.Errorf("collector %s not supported by slurmrestd", collector)
+	}
+	client := &http.Client{Timeout: scrapeTimeout()}
+	base := strings.TrimSuffix(opts.url, "/")
+	if socket, ok := strings.CutPrefix(base, unixSocketScheme); ok {
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		base = "http://" + unixSocketHost
+	} else if u, err := url.Parse(base); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid slurmrestd url %s, expected http(s):// or %s", opts.url, unixSocketScheme)
+	}
+	return &RestScraper{
+		client: client,
+		url:    fmt.Sprintf("%s/slurm/%s/%s", base, opts.apiVersion, endpoint),
+		user:   opts.user,
+		token:  opts.token,
+	}, nil
+}
+
+func newRestOpts(cliFlags *CliFlags) (*RestOpts, error) {
+	restOpts := &RestOpts{
+		url:        cliFlags.SlurmRestUrl,
+		user:       cliFlags.SlurmRestUser,
+		token:      cliFlags.SlurmRestToken,
+		apiVersion: "v0.0.37",
+	}
+	if cliFlags.SlurmRestApiVersion != "" {
+		restOpts.apiVersion = cliFlags.SlurmRestApiVersion
+	}
+	// SLURM_JWT is the env var set by `scontrol token`
+	if jwt, ok := os.LookupEnv("SLURM_JWT"); ok && restOpts.token == "" {
+		restOpts.token = jwt
+	}
+	collectors, err := parseRestCollectors(cliFlags.SlurmRestCollectors)
+	if err != nil {
+		return nil, err
+	}
+	restOpts.collectors = collectors
+	// validate the url up front so collectors don't have to
+	if _, err := NewRestScraper(restOpts, restJobs); err != nil {
+		return nil, err
+	}
+	return restOpts, nil
+}
+
+// parse a comma separated list of collectors to serve from slurmrestd
+// empty string enables slurmrestd for all supported collectors
+func parseRestCollectors(collectors string) (map[string]bool, error) {
+	enabled := make(map[string]bool)
+	if collectors == "" {
+		for collector := range restEndpoints {
+			enabled[collector] = true
+		}
+		return enabled, nil
+	}
+	for _, collector := range strings.Split(collectors, ",") {
+		collector = strings.TrimSpace(collector)
+		if _, ok := restEndpoints[collector]; !ok {
+			return nil, fmt.Errorf("unknown slurmrestd collector %q", collector)
+		}
+		enabled[collector] = true
+	}
+	return enabled, nil
+}
+
+// returns whether a collector should scrape slurmrestd instead of the cli
+func (co *CliOpts) useRest(collector string) bool {
+	return co.rest != nil && co.rest.collectors[collector]
+}
+
+// returns a slurmrestd scraper if enabled for the collector, otherwise a cli scraper with args
+func (co *CliOpts) newScraper(collector string, args []string) SlurmByteScraper {
+	if co.useRest(collector) {
+		scraper, err := NewRestScraper(co.rest, collector)
+		if err == nil {
+			return scraper
+		}
+		// url is validated in NewConfig so this should be unreachable
+		slog.Error(fmt.Sprintf("failed to init slurmrestd scraper for %s, falling back to cli: %q", collector, err))
+	}
+	return co.newCliScraper(args)
+}
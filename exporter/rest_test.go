@@ -0,0 +1,171 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// serves fixtures the same way slurmrestd would serve the openapi endpoints
+func newMockSlurmrestd(t *testing.T) *httptest.Server {
+	fixtures := map[string]string{
+		"/slurm/v0.0.37/jobs":     "fixtures/squeue_out.json",
+		"/slurm/v0.0.37/nodes":    "fixtures/sinfo_out.json",
+		"/slurm/v0.0.37/diag":     "fixtures/sdiag.json",
+		"/slurm/v0.0.37/licenses": "fixtures/license_out.json",
+	}
+	mux := http.NewServeMux()
+	for path, fixture := range fixtures {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-SLURM-USER-TOKEN") != "token" || r.Header.Get("X-SLURM-USER-NAME") != "slurm" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			http.ServeFile(w, r, fixture)
+		})
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestRestOpts(url string) *RestOpts {
+	collectors, _ := parseRestCollectors("")
+	return &RestOpts{
+		url:        url,
+		user:       "slurm",
+		token:      "token",
+		apiVersion: "v0.0.37",
+		collectors: collectors,
+	}
+}
+
+func TestRestScraper(t *testing.T) {
+	assert := assert.New(t)
+	server := newMockSlurmrestd(t)
+	scraper, err := NewRestScraper(newTestRestOpts(server.URL), restJobs)
+	assert.NoError(err)
+	data, err := scraper.FetchRawBytes(context.Background())
+	assert.NoError(err)
+	assert.NotEmpty(data)
+	assert.Positive(scraper.Duration())
+}
+
+func TestRestScraper_Unauthorized(t *testing.T) {
+	assert := assert.New(t)
+	server := newMockSlurmrestd(t)
+	opts := newTestRestOpts(server.URL)
+	opts.token = ""
+	scraper, err := NewRestScraper(opts, restNodes)
+	assert.NoError(err)
+	data, err := scraper.FetchRawBytes(context.Background())
+	assert.ErrorContains(err, "401")
+	assert.Nil(data)
+}
+
+func TestRestScraper_UnixSocket(t *testing.T) {
+	assert := assert.New(t)
+	socket := filepath.Join(t.TempDir(), "slurmrestd.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.NoError(err)
+	server := httptest.NewUnstartedServer(newMockSlurmrestd(t).Config.Handler)
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+	scraper, err := NewRestScraper(newTestRestOpts(unixSocketScheme+socket), restDiags)
+	assert.NoError(err)
+	data, err := scraper.FetchRawBytes(context.Background())
+	assert.NoError(err)
+	resp, err := parseDiagMetrics(data)
+	assert.NoError(err)
+	assert.True(resp.IsDataParserPlugin())
+}
+
+func TestNewRestScraper_BadUrl(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewRestScraper(newTestRestOpts("localhost:6820"), restJobs)
+	assert.Error(err)
+	_, err = NewRestScraper(newTestRestOpts("http://localhost:6820"), "sacctmgr")
+	assert.Error(err)
+}
+
+func TestParseRestCollectors(t *testing.T) {
+	assert := assert.New(t)
+	collectors, err := parseRestCollectors("")
+	assert.NoError(err)
+	assert.Len(collectors, len(restEndpoints))
+	collectors, err = parseRestCollectors("jobs, diags")
+	assert.NoError(err)
+	assert.Equal(map[string]bool{restJobs: true, restDiags: true}, collectors)
+	_, err = parseRestCollectors("jobs,squeue")
+	assert.Error(err)
+}
+
+func TestNewConfig_Rest(t *testing.T) {
+	assert := assert.New(t)
+	cliFlags := CliFlags{
+		SlurmCliFallback:    true,
+		SlurmRestUrl:        "http://localhost:6820",
+		SlurmRestUser:       "slurm",
+		SlurmRestToken:      "token",
+		SlurmRestCollectors: "jobs",
+	}
+	config, err := NewConfig(&cliFlags)
+	assert.NoError(err)
+	assert.IsType(&JobJsonFetcher{}, config.TraceConf.sharedFetcher)
+	assert.IsType(&RestScraper{}, config.TraceConf.sharedFetcher.(*JobJsonFetcher).scraper)
+	// nodes aren't served by slurmrestd so keep the cli fallback
+	nc := NewNodeCollecter(config)
+	assert.IsType(&NodeCliFallbackFetcher{}, nc.fetcher)
+	assert.Equal("sinfo", config.cliOpts.sinfo[0])
+}
+
+func TestNewConfig_RestJwtEnv(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("SLURM_JWT", "envtoken")
+	config, err := NewConfig(&CliFlags{SlurmRestUrl: "unix:///run/slurmrestd.sock"})
+	assert.NoError(err)
+	assert.Equal("envtoken", config.cliOpts.rest.token)
+	assert.Equal("v0.0.37", config.cliOpts.rest.apiVersion)
+}
+
+func TestRestCollectors(t *testing.T) {
+	assert := assert.New(t)
+	server := newMockSlurmrestd(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	config.cliOpts.rest = newTestRestOpts(server.URL)
+	config.TraceConf.sharedFetcher = &JobJsonFetcher{
+		scraper:    config.cliOpts.newScraper(restJobs, config.cliOpts.squeue),
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: newMockErrorCounter(),
+	}
+	for _, collector := range []prometheus.Collector{
+		NewJobsController(config),
+		NewNodeCollecter(config),
+		NewDiagsCollector(config),
+		NewLicCollector(config),
+	} {
+		metricChan := make(chan prometheus.Metric)
+		go func() {
+			collector.Collect(metricChan)
+			close(metricChan)
+		}()
+		metrics := make([]prometheus.Metric, 0)
+		for m, ok := <-metricChan; ok; m, ok = <-metricChan {
+			metrics = append(metrics, m)
+		}
+		// more than just the scrape error and duration
+		assert.Greater(len(metrics), 2)
+	}
+}
@@ -0,0 +1,321 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sacct prints times in the local timezone without an offset
+const sacctTimeFormat = "2006-01-02T15:04:05"
+
+// slurmdbd records jobs asynchronously, so the window stops short of now to let jobs that just ended land
+const sacctSettleDelay = time.Minute
+
+// a job that reached a terminal state, as reported by sacct
+type CompletedJobMetric struct {
+	JobId      string
+	JobState   string
+	ExitCode   string // <exit code>:<signal> i.e 0:0
+	Partition  string
+	Account    string
+	UserName   string
+	SubmitTime time.Time
+	// zero for jobs cancelled before they started
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// exit code class of a sacct <exit code>:<signal> pair
+func exitCodeClass(exitCode string) string {
+	code, signal, _ := strings.Cut(exitCode, ":")
+	switch {
+	case signal != "" && signal != "0":
+		return "signal"
+	case code == "0":
+		return "success"
+	default:
+		return "error"
+	}
+}
+
+// sacct reports unset times as Unknown or None
+func parseSacctTime(value string) (time.Time, error) {
+	if value == "Unknown" || value == "None" || value == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(sacctTimeFormat, value, time.Local)
+}
+
+// high-water mark of the job end times already counted
+// persisted to path, if set, so restarts neither drop nor double count jobs
+type SacctCursor struct {
+	sync.Mutex
+	path string
+	time time.Time
+}
+
+// resume from the cursor file, or start from now when there isn't one yet
+func loadSacctCursor(path string, now time.Time) *SacctCursor {
+	cursor := &SacctCursor{path: path, time: now.Add(-sacctSettleDelay).Truncate(time.Second)}
+	if path == "" {
+		return cursor
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cursor
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to read sacct cursor %s, starting from now: %q", path, err))
+		return cursor
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to parse sacct cursor %s, starting from now: %q", path, err))
+		return cursor
+	}
+	cursor.time = t
+	return cursor
+}
+
+func (sc *SacctCursor) get() time.Time {
+	sc.Lock()
+	defer sc.Unlock()
+	return sc.time
+}
+
+// the next window, from the cursor up to the settle delay before now. ok is false while the window is empty
+func (sc *SacctCursor) window(now time.Time) (start time.Time, end time.Time, ok bool) {
+	start, end = sc.get(), now.Add(-sacctSettleDelay).Truncate(time.Second)
+	return start, end, end.After(start)
+}
+
+// append the window to a sacct command, i.e `sacct ... -S <start> -E <end>`
+func withSacctWindow(args []string, start time.Time, end time.Time) []string {
+	return append(slices.Clone(args), "-S", start.In(time.Local).Format(sacctTimeFormat), "-E", end.In(time.Local).Format(sacctTimeFormat))
+}
+
+// move the cursor and persist it. The file is replaced atomically so a crash can't leave a truncated cursor
+func (sc *SacctCursor) advance(t time.Time) error {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.time = t
+	if sc.path == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(sc.path), filepath.Base(sc.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(t.Format(time.RFC3339) + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), sc.path)
+}
+
+type CompletedJobFetcher struct {
+	args []string
+	// the sacct window changes on every fetch, so a scraper is built per fetch
+	newScraper func(args []string) SlurmByteScraper
+	duration   time.Duration
+	cache      *AtomicThrottledCache[CompletedJobMetric]
+	errCounter *prometheus.CounterVec
+	cursor     *SacctCursor
+	// jobs are counted as they're fetched so cached and stale results are never counted twice
+	observe func([]CompletedJobMetric)
+	now     func() time.Time
+}
+
+// fetch the jobs that ended since the cursor, i.e `sacct ... -S <cursor> -E <now - settle delay>`
+func (cjf *CompletedJobFetcher) fetch(ctx context.Context) ([]CompletedJobMetric, error) {
+	start, end, ok := cjf.cursor.window(cjf.now())
+	if !ok {
+		return []CompletedJobMetric{}, nil
+	}
+	scraper := cjf.newScraper(withSacctWindow(cjf.args, start, end))
+	sacct, err := scraper.FetchRawBytes(ctx)
+	cjf.duration = scraper.Duration()
+	if err != nil {
+		observeCommandError(cjf.errCounter, err)
+		slog.Error(fmt.Sprintf("failed to scrape completed jobs with %q", err))
+		return nil, err
+	}
+	jobs := make([]CompletedJobMetric, 0)
+	for i, line := range bytes.Split(bytes.TrimSpace(sacct), []byte("\n")) {
+		if len(line) == 0 || isClusterHeader(line) {
+			continue
+		}
+		fields := strings.Split(string(line), "|")
+		if len(fields) != 9 {
+			slog.Error(fmt.Sprintf("sacct parse error: unexpected field count on line %d `%s`", i, line))
+			cjf.errCounter.WithLabelValues(reasonParse).Inc()
+			continue
+		}
+		submitTime, submitErr := parseSacctTime(fields[6])
+		startTime, startErr := parseSacctTime(fields[7])
+		endTime, endErr := parseSacctTime(fields[8])
+		if err := errors.Join(submitErr, startErr, endErr); err != nil || endTime.IsZero() {
+			slog.Error(fmt.Sprintf("sacct parse error: failed on line %d `%s`", i, line))
+			cjf.errCounter.WithLabelValues(reasonParse).Inc()
+			continue
+		}
+		// the window is inclusive on both ends, jobs ending on the cursor were counted by the previous fetch
+		if !endTime.After(start) || endTime.After(end) {
+			continue
+		}
+		// i.e CANCELLED by 1234
+		state, _, _ := strings.Cut(fields[1], " ")
+		jobs = append(jobs, CompletedJobMetric{
+			JobId:      fields[0],
+			JobState:   state,
+			ExitCode:   fields[2],
+			Partition:  fields[3],
+			Account:    fields[4],
+			UserName:   fields[5],
+			SubmitTime: submitTime,
+			StartTime:  startTime,
+			EndTime:    endTime,
+		})
+	}
+	cjf.observe(jobs)
+	if err := cjf.cursor.advance(end); err != nil {
+		slog.Error(fmt.Sprintf("failed to persist sacct cursor: %q", err))
+	}
+	return jobs, nil
+}
+
+func (cjf *CompletedJobFetcher) FetchMetrics(ctx context.Context) ([]CompletedJobMetric, error) {
+	return cjf.cache.FetchOrThrottle(func() ([]CompletedJobMetric, error) { return cjf.fetch(ctx) })
+}
+
+func (cjf *CompletedJobFetcher) ScrapeDuration() time.Duration {
+	return cjf.duration
+}
+
+type CompletedJobsCollector struct {
+	fetcher       SlurmMetricFetcher[CompletedJobMetric]
+	cursor        *SacctCursor
+	completedJobs *prometheus.CounterVec
+	runtime       *prometheus.HistogramVec
+	queueWait     *prometheus.HistogramVec
+	// sacct doesn't report allocations here, so users and accounts are ranked by completed jobs
+	labelLimits  LabelLimiter
+	users        *stickyLabels
+	accounts     *stickyLabels
+	foldedValues *prometheus.Desc
+	// exporter metrics
+	cursorTimestamp *prometheus.Desc
+	scrapeDuration  *prometheus.Desc
+}
+
+func NewCompletedJobsCollector(config *Config) *CompletedJobsCollector {
+	cliOpts := config.cliOpts
+	cursor := loadSacctCursor(cliOpts.sacctCursorFile, time.Now())
+	cjc := &CompletedJobsCollector{
+		cursor:       cursor,
+		labelLimits:  cliOpts.labelLimits,
+		users:        newStickyLabels(cliOpts.labelLimits.maxUsers),
+		accounts:     newStickyLabels(cliOpts.labelLimits.maxAccounts),
+		foldedValues: newFoldedValuesDesc(config, "completed_jobs"),
+		completedJobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "slurm_completed_jobs_total",
+			Help:        "jobs that reached a terminal state per state, exit code class, partition, account and user",
+			ConstLabels: config.constLabels(),
+		}, []string{"state", "exit_code", "partition", "account", "user"}),
+		// buckets from a minute up to a week, like pending ages
+		runtime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "slurm_completed_job_runtime_seconds",
+			Help:        "runtime of completed jobs per partition and account",
+			Buckets:     pendingAgeBuckets,
+			ConstLabels: config.constLabels(),
+		}, []string{"partition", "account"}),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "slurm_completed_job_wait_seconds",
+			Help:        "time completed jobs waited between submission and start per partition and account",
+			Buckets:     pendingAgeBuckets,
+			ConstLabels: config.constLabels(),
+		}, []string{"partition", "account"}),
+		cursorTimestamp: prometheus.NewDesc("slurm_completed_jobs_cursor_timestamp_seconds", "end of the last sacct window counted", nil, config.constLabels()),
+		scrapeDuration:  prometheus.NewDesc("slurm_completed_jobs_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.sacct), nil, config.constLabels()),
+	}
+	cjc.fetcher = &CompletedJobFetcher{
+		args:       cliOpts.sacct,
+		newScraper: func(args []string) SlurmByteScraper { return cliOpts.newCliScraper(args) },
+		cache:      newConfiguredCache[CompletedJobMetric](config, "completed_jobs"),
+		errCounter: config.commandErrorCounter("sacct"),
+		cursor:     cursor,
+		observe:    cjc.observe,
+		now:        time.Now,
+	}
+	return cjc
+}
+
+func (cjc *CompletedJobsCollector) observe(jobs []CompletedJobMetric) {
+	userWeights := make(map[string]float64)
+	accountWeights := make(map[string]float64)
+	for _, job := range jobs {
+		userWeights[job.UserName]++
+		accountWeights[job.Account]++
+	}
+	users, accounts := cjc.users.fold(userWeights), cjc.accounts.fold(accountWeights)
+	for _, job := range jobs {
+		account := accounts[job.Account]
+		cjc.completedJobs.WithLabelValues(job.JobState, exitCodeClass(job.ExitCode), job.Partition, account, users[job.UserName]).Inc()
+		if job.StartTime.IsZero() {
+			continue
+		}
+		cjc.runtime.WithLabelValues(job.Partition, account).Observe(job.EndTime.Sub(job.StartTime).Seconds())
+		if !job.SubmitTime.IsZero() {
+			cjc.queueWait.WithLabelValues(job.Partition, account).Observe(job.StartTime.Sub(job.SubmitTime).Seconds())
+		}
+	}
+}
+
+func (cjc *CompletedJobsCollector) Describe(ch chan<- *prometheus.Desc) {
+	cjc.completedJobs.Describe(ch)
+	cjc.runtime.Describe(ch)
+	cjc.queueWait.Describe(ch)
+	ch <- cjc.foldedValues
+	ch <- cjc.cursorTimestamp
+	ch <- cjc.scrapeDuration
+}
+
+func (cjc *CompletedJobsCollector) Collect(ch chan<- prometheus.Metric) {
+	cjc.CollectWithContext(context.Background(), ch)
+}
+
+func (cjc *CompletedJobsCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	if _, err := cjc.fetcher.FetchMetrics(ctx); err != nil {
+		slog.Error(fmt.Sprintf("completed jobs fetch error %q", err))
+	}
+	ch <- prometheus.MustNewConstMetric(cjc.scrapeDuration, prometheus.GaugeValue, float64(cjc.fetcher.ScrapeDuration().Milliseconds()))
+	ch <- prometheus.MustNewConstMetric(cjc.cursorTimestamp, prometheus.GaugeValue, float64(cjc.cursor.get().Unix()))
+	// counters keep their totals across failed fetches
+	cjc.completedJobs.Collect(ch)
+	cjc.runtime.Collect(ch)
+	cjc.queueWait.Collect(ch)
+	if cjc.labelLimits.enabled() {
+		ch <- prometheus.MustNewConstMetric(cjc.foldedValues, prometheus.GaugeValue, cjc.users.foldedCount(), "user")
+		ch <- prometheus.MustNewConstMetric(cjc.foldedValues, prometheus.GaugeValue, cjc.accounts.foldedCount(), "account")
+	}
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func sacctTime(value string) time.Time {
+	t, err := time.ParseInLocation(sacctTimeFormat, value, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// fetcher over the sacct fixture with a cursor at midnight and the window ending at 1am
+func newMockCompletedJobFetcher(cursor *SacctCursor, observe func([]CompletedJobMetric)) (*CompletedJobFetcher, *[][]string) {
+	calls := new([][]string)
+	return &CompletedJobFetcher{
+		args: []string{"sacct"},
+		newScraper: func(args []string) SlurmByteScraper {
+			*calls = append(*calls, args)
+			return &MockScraper{fixture: "fixtures/sacct.txt"}
+		},
+		cache:      NewAtomicThrottledCache[CompletedJobMetric](10),
+		errCounter: newMockErrorCounter(),
+		cursor:     cursor,
+		observe:    observe,
+		now:        func() time.Time { return sacctTime("2023-09-21T01:01:00") },
+	}, calls
+}
+
+func TestExitCodeClass(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("success", exitCodeClass("0:0"))
+	assert.Equal("error", exitCodeClass("1:0"))
+	assert.Equal("signal", exitCodeClass("0:9"))
+}
+
+func TestCompletedJobFetch(t *testing.T) {
+	assert := assert.New(t)
+	cursor := &SacctCursor{time: sacctTime("2023-09-21T00:00:00")}
+	var observed []CompletedJobMetric
+	fetcher, calls := newMockCompletedJobFetcher(cursor, func(jobs []CompletedJobMetric) { observed = append(observed, jobs...) })
+	jobs, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	assert.Equal([][]string{{"sacct", "-S", "2023-09-21T00:00:00", "-E", "2023-09-21T01:00:00"}}, *calls)
+	// the job ending on the cursor was counted by the previous window
+	assert.Len(jobs, 4)
+	assert.Equal(jobs, observed)
+	assert.Equal("CANCELLED", jobs[2].JobState)
+	assert.True(jobs[3].StartTime.IsZero())
+	// an unknown end time and a truncated line
+	assert.Equal(2., CollectCounterValue(fetcher.errCounter.WithLabelValues(reasonParse)))
+	assert.Equal(sacctTime("2023-09-21T01:00:00"), cursor.get())
+
+	// the next window starts at the cursor, nothing new has ended yet
+	jobs, err = fetcher.fetch(context.Background())
+	assert.NoError(err)
+	assert.Empty(jobs)
+	assert.Len(*calls, 1)
+}
+
+func TestCompletedJobsCollector_LabelLimits(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{MaxUsers: 1})
+	assert.NoError(err)
+	cjc := NewCompletedJobsCollector(config)
+	cjc.observe([]CompletedJobMetric{
+		{JobState: "COMPLETED", ExitCode: "0:0", Partition: "hw-h", Account: "account1", UserName: "user1"},
+		{JobState: "COMPLETED", ExitCode: "0:0", Partition: "hw-h", Account: "account1", UserName: "user1"},
+		{JobState: "COMPLETED", ExitCode: "0:0", Partition: "hw-h", Account: "account1", UserName: "user2"},
+	})
+	assert.Equal(2., CollectCounterValue(cjc.completedJobs.WithLabelValues("COMPLETED", "success", "hw-h", "account1", "user1")))
+	assert.Equal(1., CollectCounterValue(cjc.completedJobs.WithLabelValues("COMPLETED", "success", "hw-h", "account1", otherLabelValue)))
+	assert.Equal(1., cjc.users.foldedCount())
+}
+
+func TestSacctCursor(t *testing.T) {
+	assert := assert.New(t)
+	now := sacctTime("2023-09-21T01:01:00")
+	path := filepath.Join(t.TempDir(), "sacct.cursor")
+	// without a cursor file the first window starts now
+	cursor := loadSacctCursor(path, now)
+	assert.Equal(now.Add(-sacctSettleDelay), cursor.get())
+	assert.NoError(cursor.advance(now))
+	assert.True(loadSacctCursor(path, now.Add(time.Hour)).get().Equal(now))
+	// a corrupt cursor starts from now rather than failing
+	assert.NoError(os.WriteFile(path, []byte("yesterday"), 0o600))
+	assert.Equal(now.Add(-sacctSettleDelay), loadSacctCursor(path, now).get())
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(err)
+	assert.Len(entries, 1)
+}
+
+func TestSacctWindow(t *testing.T) {
+	assert := assert.New(t)
+	cursor := &SacctCursor{time: sacctTime("2023-09-21T00:00:00")}
+	start, end, ok := cursor.window(sacctTime("2023-09-21T01:01:00.5"))
+	assert.True(ok)
+	assert.Equal([]string{"sacct", "-S", "2023-09-21T00:00:00", "-E", "2023-09-21T01:00:00"}, withSacctWindow([]string{"sacct"}, start, end))
+	// nothing has settled since the cursor
+	_, _, ok = cursor.window(sacctTime("2023-09-21T00:01:00"))
+	assert.False(ok)
+}
+
+func TestCompletedJobsCollector(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	cjc := NewCompletedJobsCollector(config)
+	cjc.cursor = &SacctCursor{time: sacctTime("2023-09-21T00:00:00")}
+	fetcher, _ := newMockCompletedJobFetcher(cjc.cursor, cjc.observe)
+	cjc.fetcher = fetcher
+	collect := func() {
+		cjcChan := make(chan prometheus.Metric)
+		go func() {
+			cjc.Collect(cjcChan)
+			close(cjcChan)
+		}()
+		for range cjcChan {
+		}
+	}
+	collect()
+	assert.Equal(1., CollectCounterValue(cjc.completedJobs.WithLabelValues("COMPLETED", "success", "hw-h", "account1", "user1")))
+	assert.Equal(1., CollectCounterValue(cjc.completedJobs.WithLabelValues("FAILED", "error", "hw-h", "account1", "user1")))
+	assert.Equal(1., CollectCounterValue(cjc.completedJobs.WithLabelValues("CANCELLED", "signal", "hw-l", "account2", "user2")))
+	assert.Equal(1., CollectCounterValue(cjc.completedJobs.WithLabelValues("CANCELLED", "success", "hw-l", "account2", "user2")))
+	// cached fetches aren't counted twice
+	collect()
+	assert.Equal(1., CollectCounterValue(cjc.completedJobs.WithLabelValues("COMPLETED", "success", "hw-h", "account1", "user1")))
+}
@@ -0,0 +1,296 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// usage of a finished job against its allocation, as reported by seff
+type CompletedJobEfficiencyMetric struct {
+	JobId     string
+	Partition string
+	Account   string
+	UserName  string
+	EndTime   time.Time
+	// seconds
+	Elapsed  float64
+	TotalCpu float64
+	// bytes, ReqMem is the memory requested by the whole job and MemUsed the largest total rss of any step, summed over its tasks
+	AllocCpus float64
+	ReqMem    float64
+	MemUsed   float64
+}
+
+func (m *CompletedJobEfficiencyMetric) cpuAlloc() float64 {
+	return m.AllocCpus * m.Elapsed
+}
+
+// sacct memory requests, i.e 4G for the whole job. Older slurm versions suffix requests per cpu or per node, i.e 4000Mc or 4000Mn
+func parseReqMem(value string, cpus float64, nodes float64) (float64, error) {
+	multiplier := 1.
+	switch {
+	case strings.HasSuffix(value, "c"):
+		multiplier, value = cpus, strings.TrimSuffix(value, "c")
+	case strings.HasSuffix(value, "n"):
+		multiplier, value = nodes, strings.TrimSuffix(value, "n")
+	}
+	// sacct reports requests without a unit in megabytes
+	if value != "" && value[len(value)-1] >= '0' && value[len(value)-1] <= '9' {
+		value += "M"
+	}
+	mem, err := parseSlurmBytes(value)
+	return mem * multiplier, err
+}
+
+// mem of a sacct tres usage, i.e 6G for `cpu=02:30:00,energy=0,fs/disk=2048,mem=6G,pages=0,vmem=8G`. 0 without a mem sample
+func parseTresMem(value string) (float64, error) {
+	for _, tres := range strings.Split(value, ",") {
+		if mem, ok := strings.CutPrefix(tres, "mem="); ok {
+			return parseSlurmBytes(mem)
+		}
+	}
+	return 0, nil
+}
+
+type CompletedJobEfficiencyFetcher struct {
+	args       []string
+	newScraper func(args []string) SlurmByteScraper
+	duration   time.Duration
+	cache      *AtomicThrottledCache[CompletedJobEfficiencyMetric]
+	errCounter *prometheus.CounterVec
+	cursor     *SacctCursor
+	// jobs are observed as they're fetched so cached and stale results are never counted twice
+	observe func([]CompletedJobEfficiencyMetric)
+	now     func() time.Time
+}
+
+// fetch the jobs that ended since the cursor with their steps, i.e
+// `123|COMPLETED|hw-h|account1|user1|2023-09-21T00:30:00|01:00:00|03:00:00|4|1|16G|` followed by `123.batch|...|cpu=00:30:00,mem=2G`
+func (cef *CompletedJobEfficiencyFetcher) fetch(ctx context.Context) ([]CompletedJobEfficiencyMetric, error) {
+	start, end, ok := cef.cursor.window(cef.now())
+	if !ok {
+		return []CompletedJobEfficiencyMetric{}, nil
+	}
+	scraper := cef.newScraper(withSacctWindow(cef.args, start, end))
+	sacct, err := scraper.FetchRawBytes(ctx)
+	cef.duration = scraper.Duration()
+	if err != nil {
+		observeCommandError(cef.errCounter, err)
+		slog.Error(fmt.Sprintf("failed to scrape completed job efficiency with %q", err))
+		return nil, err
+	}
+	jobs := make([]CompletedJobEfficiencyMetric, 0)
+	// steps are listed after their job
+	index := make(map[string]int)
+	for i, line := range bytes.Split(bytes.TrimSpace(sacct), []byte("\n")) {
+		if len(line) == 0 || isClusterHeader(line) {
+			continue
+		}
+		fields := strings.Split(string(line), "|")
+		if len(fields) != 12 {
+			slog.Error(fmt.Sprintf("sacct efficiency parse error: unexpected field count on line %d `%s`", i, line))
+			cef.errCounter.WithLabelValues(reasonParse).Inc()
+			continue
+		}
+		jobId, step, isStep := strings.Cut(fields[0], ".")
+		if isStep {
+			j, ok := index[jobId]
+			if !ok || step == "extern" {
+				continue
+			}
+			// TRESUsageInTot sums the rss peak of every task, where MaxRSS is a single task's and undercounts multi task steps
+			memUsed, err := parseTresMem(fields[11])
+			if err != nil {
+				slog.Error(fmt.Sprintf("sacct efficiency parse error: failed on line %d `%s`", i, line))
+				cef.errCounter.WithLabelValues(reasonParse).Inc()
+				continue
+			}
+			jobs[j].MemUsed = max(jobs[j].MemUsed, memUsed)
+			continue
+		}
+		endTime, endErr := parseSacctTime(fields[5])
+		elapsed, elapsedErr := parseSlurmCpuTime(fields[6])
+		totalCpu, cpuErr := parseSlurmCpuTime(fields[7])
+		cpus, cpusErr := strconv.ParseFloat(fields[8], 64)
+		nodes, nodesErr := strconv.ParseFloat(fields[9], 64)
+		if err := errors.Join(endErr, elapsedErr, cpuErr, cpusErr, nodesErr); err != nil || endTime.IsZero() {
+			slog.Error(fmt.Sprintf("sacct efficiency parse error: failed on line %d `%s`", i, line))
+			cef.errCounter.WithLabelValues(reasonParse).Inc()
+			continue
+		}
+		reqMem, err := parseReqMem(fields[10], cpus, nodes)
+		if err != nil {
+			slog.Error(fmt.Sprintf("sacct efficiency parse error: unexpected ReqMem on line %d `%s`", i, line))
+			cef.errCounter.WithLabelValues(reasonParse).Inc()
+			continue
+		}
+		// the window is inclusive on both ends, jobs ending on the cursor were counted by the previous fetch
+		if !endTime.After(start) || endTime.After(end) {
+			continue
+		}
+		index[jobId] = len(jobs)
+		jobs = append(jobs, CompletedJobEfficiencyMetric{
+			JobId:     jobId,
+			Partition: fields[2],
+			Account:   fields[3],
+			UserName:  fields[4],
+			EndTime:   endTime,
+			Elapsed:   elapsed,
+			TotalCpu:  totalCpu,
+			AllocCpus: cpus,
+			ReqMem:    reqMem,
+		})
+	}
+	cef.observe(jobs)
+	if err := cef.cursor.advance(end); err != nil {
+		slog.Error(fmt.Sprintf("failed to persist sacct efficiency cursor: %q", err))
+	}
+	return jobs, nil
+}
+
+func (cef *CompletedJobEfficiencyFetcher) FetchMetrics(ctx context.Context) ([]CompletedJobEfficiencyMetric, error) {
+	return cef.cache.FetchOrThrottle(func() ([]CompletedJobEfficiencyMetric, error) { return cef.fetch(ctx) })
+}
+
+func (cef *CompletedJobEfficiencyFetcher) ScrapeDuration() time.Duration {
+	return cef.duration
+}
+
+type CompletedJobEfficiencyCollector struct {
+	fetcher SlurmMetricFetcher[CompletedJobEfficiencyMetric]
+	cursor  *SacctCursor
+	// totals, so the wasted share of any period is 1 - increase(used) / increase(alloc)
+	cpuAlloc *prometheus.CounterVec
+	cpuUsed  *prometheus.CounterVec
+	memReq   *prometheus.CounterVec
+	memUsed  *prometheus.CounterVec
+	// distributions of the efficiency of each job
+	cpuEfficiency *prometheus.HistogramVec
+	memEfficiency *prometheus.HistogramVec
+	labelLimits   LabelLimiter
+	users         *stickyLabels
+	accounts      *stickyLabels
+	foldedValues  *prometheus.Desc
+	// exporter metrics
+	cursorTimestamp *prometheus.Desc
+	scrapeDuration  *prometheus.Desc
+}
+
+func NewCompletedJobEfficiencyCollector(config *Config) *CompletedJobEfficiencyCollector {
+	cliOpts := config.cliOpts
+	cursorFile := cliOpts.sacctCursorFile
+	if cursorFile != "" {
+		// kept apart from the completed jobs cursor so either collector can be enabled on its own
+		cursorFile += ".efficiency"
+	}
+	cursor := loadSacctCursor(cursorFile, time.Now())
+	labels := []string{"partition", "account", "user"}
+	counter := func(name string, help string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help, ConstLabels: config.constLabels()}, labels)
+	}
+	// a series per bucket and user, capped by -slurm.max-users
+	histogram := func(name string, help string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: efficiencyBuckets, ConstLabels: config.constLabels()}, labels)
+	}
+	cec := &CompletedJobEfficiencyCollector{
+		cursor:          cursor,
+		cpuAlloc:        counter("slurm_completed_job_cpu_alloc_seconds_total", "cpu seconds allocated to completed jobs per partition, account and user"),
+		cpuUsed:         counter("slurm_completed_job_cpu_used_seconds_total", "cpu seconds used by completed jobs per partition, account and user"),
+		memReq:          counter("slurm_completed_job_mem_req_bytes_total", "mem requested by completed jobs with a memory sample per partition, account and user"),
+		memUsed:         counter("slurm_completed_job_mem_used_bytes_total", "largest step rss summed over its tasks of completed jobs with a memory sample per partition, account and user"),
+		cpuEfficiency:   histogram("slurm_completed_job_cpu_efficiency", "share of the allocated cpu time used by completed jobs per partition, account and user"),
+		memEfficiency:   histogram("slurm_completed_job_mem_efficiency", "largest step rss summed over its tasks over the mem requested by completed jobs per partition, account and user"),
+		labelLimits:     cliOpts.labelLimits,
+		users:           newStickyLabels(cliOpts.labelLimits.maxUsers),
+		accounts:        newStickyLabels(cliOpts.labelLimits.maxAccounts),
+		foldedValues:    newFoldedValuesDesc(config, "completed_efficiency"),
+		cursorTimestamp: prometheus.NewDesc("slurm_completed_job_efficiency_cursor_timestamp_seconds", "end of the last sacct efficiency window counted", nil, config.constLabels()),
+		scrapeDuration:  prometheus.NewDesc("slurm_completed_job_efficiency_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.sacctEfficiency), nil, config.constLabels()),
+	}
+	cec.fetcher = &CompletedJobEfficiencyFetcher{
+		args:       cliOpts.sacctEfficiency,
+		newScraper: func(args []string) SlurmByteScraper { return cliOpts.newCliScraper(args) },
+		cache:      newConfiguredCache[CompletedJobEfficiencyMetric](config, "completed_efficiency"),
+		errCounter: config.commandErrorCounter("sacct"),
+		cursor:     cursor,
+		observe:    cec.observe,
+		now:        time.Now,
+	}
+	return cec
+}
+
+// jobs that never ran have nothing to measure. Memory is only observed when both the request and a step's mem usage are known
+func (cec *CompletedJobEfficiencyCollector) observe(jobs []CompletedJobEfficiencyMetric) {
+	userWeights := make(map[string]float64)
+	accountWeights := make(map[string]float64)
+	for i := range jobs {
+		weight := cec.labelLimits.weight(jobs[i].cpuAlloc(), jobs[i].ReqMem)
+		userWeights[jobs[i].UserName] += weight
+		accountWeights[jobs[i].Account] += weight
+	}
+	users, accounts := cec.users.fold(userWeights), cec.accounts.fold(accountWeights)
+	for i := range jobs {
+		job := &jobs[i]
+		alloc := job.cpuAlloc()
+		if alloc <= 0 {
+			continue
+		}
+		labels := []string{job.Partition, accounts[job.Account], users[job.UserName]}
+		cec.cpuAlloc.WithLabelValues(labels...).Add(alloc)
+		cec.cpuUsed.WithLabelValues(labels...).Add(job.TotalCpu)
+		cec.cpuEfficiency.WithLabelValues(labels...).Observe(efficiencyRatio(job.TotalCpu, alloc))
+		if job.ReqMem <= 0 || job.MemUsed <= 0 {
+			continue
+		}
+		cec.memReq.WithLabelValues(labels...).Add(job.ReqMem)
+		cec.memUsed.WithLabelValues(labels...).Add(job.MemUsed)
+		cec.memEfficiency.WithLabelValues(labels...).Observe(efficiencyRatio(job.MemUsed, job.ReqMem))
+	}
+}
+
+func (cec *CompletedJobEfficiencyCollector) Describe(ch chan<- *prometheus.Desc) {
+	cec.cpuAlloc.Describe(ch)
+	cec.cpuUsed.Describe(ch)
+	cec.memReq.Describe(ch)
+	cec.memUsed.Describe(ch)
+	cec.cpuEfficiency.Describe(ch)
+	cec.memEfficiency.Describe(ch)
+	ch <- cec.foldedValues
+	ch <- cec.cursorTimestamp
+	ch <- cec.scrapeDuration
+}
+
+func (cec *CompletedJobEfficiencyCollector) Collect(ch chan<- prometheus.Metric) {
+	cec.CollectWithContext(context.Background(), ch)
+}
+
+func (cec *CompletedJobEfficiencyCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	if _, err := cec.fetcher.FetchMetrics(ctx); err != nil {
+		slog.Error(fmt.Sprintf("completed job efficiency fetch error %q", err))
+	}
+	ch <- prometheus.MustNewConstMetric(cec.scrapeDuration, prometheus.GaugeValue, float64(cec.fetcher.ScrapeDuration().Milliseconds()))
+	ch <- prometheus.MustNewConstMetric(cec.cursorTimestamp, prometheus.GaugeValue, float64(cec.cursor.get().Unix()))
+	// counters keep their totals across failed fetches
+	cec.cpuAlloc.Collect(ch)
+	cec.cpuUsed.Collect(ch)
+	cec.memReq.Collect(ch)
+	cec.memUsed.Collect(ch)
+	cec.cpuEfficiency.Collect(ch)
+	cec.memEfficiency.Collect(ch)
+	if cec.labelLimits.enabled() {
+		ch <- prometheus.MustNewConstMetric(cec.foldedValues, prometheus.GaugeValue, cec.users.foldedCount(), "user")
+		ch <- prometheus.MustNewConstMetric(cec.foldedValues, prometheus.GaugeValue, cec.accounts.foldedCount(), "account")
+	}
+}
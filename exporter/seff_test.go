@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fetcher over the sacct efficiency fixture with a cursor at midnight and the window ending at 1am
+func newMockCompletedJobEfficiencyFetcher(cursor *SacctCursor, observe func([]CompletedJobEfficiencyMetric)) (*CompletedJobEfficiencyFetcher, *[][]string) {
+	calls := new([][]string)
+	return &CompletedJobEfficiencyFetcher{
+		args: []string{"sacct"},
+		newScraper: func(args []string) SlurmByteScraper {
+			*calls = append(*calls, args)
+			return &MockScraper{fixture: "fixtures/sacct_efficiency.txt"}
+		},
+		cache:      NewAtomicThrottledCache[CompletedJobEfficiencyMetric](10),
+		errCounter: newMockErrorCounter(),
+		cursor:     cursor,
+		observe:    observe,
+		now:        func() time.Time { return sacctTime("2023-09-21T01:01:00") },
+	}, calls
+}
+
+func TestParseReqMem(t *testing.T) {
+	assert := assert.New(t)
+	for value, expected := range map[string]float64{"16G": 16 << 30, "4000M": 4000 << 20, "4000": 4000 << 20, "1000Mc": 4 * 1000 << 20, "2Gn": 2 * 2 << 30, "0": 0} {
+		mem, err := parseReqMem(value, 4, 2)
+		assert.NoError(err)
+		assert.Equal(expected, mem, value)
+	}
+	_, err := parseReqMem("lots", 4, 2)
+	assert.Error(err)
+}
+
+func TestParseTresMem(t *testing.T) {
+	assert := assert.New(t)
+	for value, expected := range map[string]float64{"cpu=00:30:00,energy=0,fs/disk=2048,mem=1.5G,pages=0,vmem=3G": 1.5 * (1 << 30), "mem=500M": 500 << 20, "cpu=00:30:00": 0, "": 0} {
+		mem, err := parseTresMem(value)
+		assert.NoError(err)
+		assert.Equal(expected, mem, value)
+	}
+	_, err := parseTresMem("cpu=00:30:00,mem=lots")
+	assert.Error(err)
+}
+
+func TestCompletedJobEfficiencyFetch(t *testing.T) {
+	assert := assert.New(t)
+	cursor := &SacctCursor{time: sacctTime("2023-09-21T00:00:00")}
+	var observed []CompletedJobEfficiencyMetric
+	fetcher, calls := newMockCompletedJobEfficiencyFetcher(cursor, func(jobs []CompletedJobEfficiencyMetric) { observed = append(observed, jobs...) })
+	jobs, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	assert.Equal([][]string{{"sacct", "-S", "2023-09-21T00:00:00", "-E", "2023-09-21T01:00:00"}}, *calls)
+	// the job ending on the cursor was counted by the previous window
+	assert.Len(jobs, 3)
+	assert.Equal(jobs, observed)
+	job := jobs[0]
+	assert.Equal("100", job.JobId)
+	assert.Equal(14400., job.cpuAlloc())
+	assert.Equal(10800., job.TotalCpu)
+	assert.Equal(float64(16<<30), job.ReqMem)
+	// the largest step summed over its tasks, the extern step doesn't count
+	assert.Equal(float64(10<<30), job.MemUsed)
+	// requested per cpu
+	assert.Equal(float64(2000<<20), jobs[1].ReqMem)
+	assert.Equal(0., jobs[2].cpuAlloc())
+	// an unknown end time and a truncated line
+	assert.Equal(2., CollectCounterValue(fetcher.errCounter.WithLabelValues(reasonParse)))
+	assert.Equal(sacctTime("2023-09-21T01:00:00"), cursor.get())
+
+	jobs, err = fetcher.fetch(context.Background())
+	assert.NoError(err)
+	assert.Empty(jobs)
+	assert.Len(*calls, 1)
+}
+
+func TestCompletedJobEfficiencyCollector_LabelLimits(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{MaxAccounts: 1})
+	assert.NoError(err)
+	cec := NewCompletedJobEfficiencyCollector(config)
+	// account2 holds the most cpu time
+	cec.observe([]CompletedJobEfficiencyMetric{
+		{Partition: "hw-h", Account: "account1", UserName: "user1", Elapsed: 100, AllocCpus: 1, TotalCpu: 50},
+		{Partition: "hw-h", Account: "account2", UserName: "user1", Elapsed: 100, AllocCpus: 4, TotalCpu: 100},
+	})
+	assert.Equal(400., CollectCounterValue(cec.cpuAlloc.WithLabelValues("hw-h", "account2", "user1")))
+	assert.Equal(100., CollectCounterValue(cec.cpuAlloc.WithLabelValues("hw-h", otherLabelValue, "user1")))
+	assert.Equal(1., cec.accounts.foldedCount())
+}
+
+func TestCompletedJobEfficiencyCollector(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	cec := NewCompletedJobEfficiencyCollector(config)
+	cec.cursor = &SacctCursor{time: sacctTime("2023-09-21T00:00:00")}
+	fetcher, _ := newMockCompletedJobEfficiencyFetcher(cec.cursor, cec.observe)
+	cec.fetcher = fetcher
+	collect := func() {
+		cecChan := make(chan prometheus.Metric)
+		go func() {
+			cec.Collect(cecChan)
+			close(cecChan)
+		}()
+		for range cecChan {
+		}
+	}
+	collect()
+	assert.Equal(14400., CollectCounterValue(cec.cpuAlloc.WithLabelValues("hw-h", "account1", "user1")))
+	assert.Equal(10800., CollectCounterValue(cec.cpuUsed.WithLabelValues("hw-h", "account1", "user1")))
+	assert.Equal(1200., CollectCounterValue(cec.cpuAlloc.WithLabelValues("hw-l", "account2", "user2")))
+	assert.Equal(float64(500<<20), CollectCounterValue(cec.memUsed.WithLabelValues("hw-l", "account2", "user2")))
+	memEfficiency, err := cec.memEfficiency.GetMetricWithLabelValues("hw-h", "account1", "user1")
+	assert.NoError(err)
+	metric := new(dto.Metric)
+	assert.NoError(memEfficiency.(prometheus.Histogram).Write(metric))
+	assert.Equal(uint64(1), metric.GetHistogram().GetSampleCount())
+	// cached fetches aren't counted twice
+	collect()
+	assert.Equal(14400., CollectCounterValue(cec.cpuAlloc.WithLabelValues("hw-h", "account1", "user1")))
+}
@@ -5,11 +5,15 @@
 package exporter
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"log/slog"
 
@@ -19,16 +23,55 @@ import (
 )
 
 type CliOpts struct {
-	sinfo         []string
-	squeue        []string
-	sacctmgr      []string
-	lic           []string
-	sdiag         []string
-	licEnabled    bool
-	diagsEnabled  bool
-	fallback      bool
-	sacctEnabled  bool
-	excludeFilter *regexp.Regexp
+	sinfo                []string
+	squeue               []string
+	sacctmgr             []string
+	lic                  []string
+	sdiag                []string
+	sshare               []string
+	sprio                []string
+	sacct                []string
+	reservations         []string
+	sstat                []string
+	sacctEfficiency      []string
+	licEnabled           bool
+	diagsEnabled         bool
+	fallback             bool
+	sacctEnabled         bool
+	fairshareEnabled     bool
+	priorityEnabled      bool
+	completedJobsEnabled bool
+	reservationsEnabled  bool
+	efficiencyEnabled    bool
+	seffEnabled          bool
+	excludeFilter        *regexp.Regexp
+	rest                 *RestOpts
+	// per job metrics are opt-in since they grow with the job count
+	jobMetricsEnabled bool
+	jobMetricsMax     int
+	jobMetricsStates  []string
+	// per node job counts and allocations, opt-in since they grow with the node count
+	nodeJobsEnabled bool
+	// per node sinfo metrics labeled by hostname, opt-in for the same reason
+	nodeMetricsEnabled bool
+	// sacct high-water mark, kept in memory only when unset
+	sacctCursorFile string
+	// pending jobs exported individually by the priority collector
+	priorityTopJobs int
+	// running jobs with less walltime left are counted as near their time limit
+	timeLimitWarning time.Duration
+	// split the account job metrics by qos
+	accountQosLabels bool
+	// rewrites pending reasons into bounded categories
+	pendingReasons *ReasonNormalizer
+	// running jobs sampled by each sstat call
+	sstatBatchSize int
+	// caps the users and accounts of the aggregate metrics
+	labelLimits LabelLimiter
+	// cli scraper settings. Zero values keep the env var defaults
+	timeout time.Duration
+	retries int
+	backoff time.Duration
 }
 
 type TraceConfig struct {
@@ -41,30 +84,78 @@ type TraceConfig struct {
 type Config struct {
 	TraceConf     *TraceConfig
 	PollLimit     float64
+	PollInterval  float64
+	StaleMaxAge   float64
 	LogLevel      slog.Level
 	ListenAddress string
 	MetricsPath   string
 	cliOpts       *CliOpts
+	caches        map[string]CacheStatus
+	// shared by all fetchers, curried per command
+	commandErrors *prometheus.CounterVec
+	// set when scraping multiple clusters, each with its own config
+	cluster  string
+	clusters []*Config
 }
 
+// flags from the cli, optionally layered over a yaml config file. See ReadConfigFile
 type CliFlags struct {
-	SlurmLicEnabled           bool
-	SlurmDiagEnabled          bool
-	SlurmCliFallback          bool
-	TraceEnabled              bool
-	SacctEnabled              bool
-	SlurmPollLimit            float64
-	LogLevel                  string
-	ListenAddress             string
-	MetricsPath               string
-	SlurmSqueueOverride       string
-	SlurmSinfoOverride        string
-	SlurmDiagOverride         string
-	SlurmAcctOverride         string
-	TraceRate                 uint64
-	TracePath                 string
-	SlurmLicenseOverride      string
-	MetricsExcludeFilterRegex string
+	SlurmLicEnabled           bool         `yaml:"collect_licenses"`
+	SlurmDiagEnabled          bool         `yaml:"collect_diags"`
+	SlurmCliFallback          bool         `yaml:"cli_fallback"`
+	TraceEnabled              bool         `yaml:"trace_enabled"`
+	SacctEnabled              bool         `yaml:"collect_limits"`
+	FairshareEnabled          bool         `yaml:"collect_fairshare"`
+	PriorityEnabled           bool         `yaml:"collect_priority"`
+	PriorityTopJobs           int          `yaml:"priority_top_jobs"`
+	CompletedJobsEnabled      bool         `yaml:"collect_completed_jobs"`
+	ReservationsEnabled       bool         `yaml:"collect_reservations"`
+	EfficiencyEnabled         bool         `yaml:"collect_efficiency"`
+	SstatBatchSize            int          `yaml:"sstat_batch_size"`
+	SeffEnabled               bool         `yaml:"collect_completed_efficiency"`
+	SacctCursorFile           string       `yaml:"sacct_cursor_file"`
+	SlurmPollLimit            float64      `yaml:"poll_limit"`
+	SlurmPollInterval         float64      `yaml:"poll_interval"`
+	SlurmStaleMaxAge          float64      `yaml:"stale_max_age"`
+	SlurmCliTimeout           float64      `yaml:"cli_timeout"`
+	SlurmCliRetries           int          `yaml:"cli_retries"`
+	SlurmCliRetryBackoff      float64      `yaml:"cli_retry_backoff"`
+	JobMetricsEnabled         bool         `yaml:"collect_job_metrics"`
+	JobMetricsMax             int          `yaml:"job_metrics_max"`
+	JobMetricsStates          string       `yaml:"job_metrics_states"`
+	NodeJobsEnabled           bool         `yaml:"collect_node_jobs"`
+	NodeMetricsEnabled        bool         `yaml:"collect_node_metrics"`
+	TimeLimitWarning          float64      `yaml:"time_limit_warning"`
+	AccountQosLabels          bool         `yaml:"account_qos_labels"`
+	MaxUsers                  int          `yaml:"max_users"`
+	MaxAccounts               int          `yaml:"max_accounts"`
+	LimitLabelsBy             string       `yaml:"limit_labels_by"`
+	LogLevel                  string       `yaml:"log_level"`
+	ListenAddress             string       `yaml:"listen_address"`
+	MetricsPath               string       `yaml:"telemetry_path"`
+	SlurmSqueueOverride       string       `yaml:"squeue_cli"`
+	SlurmSinfoOverride        string       `yaml:"sinfo_cli"`
+	SlurmDiagOverride         string       `yaml:"diag_cli"`
+	SlurmAcctOverride         string       `yaml:"sacctmgr_cli"`
+	SlurmSshareOverride       string       `yaml:"sshare_cli"`
+	SlurmSprioOverride        string       `yaml:"sprio_cli"`
+	SlurmSacctOverride        string       `yaml:"sacct_cli"`
+	SlurmReservationOverride  string       `yaml:"reservation_cli"`
+	SlurmSstatOverride        string       `yaml:"sstat_cli"`
+	SlurmSacctEffOverride     string       `yaml:"sacct_efficiency_cli"`
+	TraceRate                 uint64       `yaml:"trace_rate"`
+	TracePath                 string       `yaml:"trace_path"`
+	SlurmLicenseOverride      string       `yaml:"lic_cli"`
+	MetricsExcludeFilterRegex string       `yaml:"metrics_exclude"`
+	SlurmRestUrl              string       `yaml:"rest_url"`
+	SlurmRestUser             string       `yaml:"rest_user"`
+	SlurmRestToken            string       `yaml:"rest_token"`
+	SlurmRestApiVersion       string       `yaml:"rest_api_version"`
+	SlurmRestCollectors       string       `yaml:"rest_collectors"`
+	PendingReasonRules        []ReasonRule `yaml:"pending_reason_rules"`
+	Clusters                  ClusterList  `yaml:"clusters"`
+	// path of the yaml file the remaining flags were read from
+	ConfigFile string `yaml:"-"`
 }
 
 var logLevelMap = map[string]slog.Level{
@@ -80,17 +171,50 @@ func NewConfig(cliFlags *CliFlags) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	pendingReasons, err := NewReasonNormalizer(cliFlags.PendingReasonRules)
+	if err != nil {
+		return nil, err
+	}
+	labelLimits, err := NewLabelLimiter(cliFlags.MaxUsers, cliFlags.MaxAccounts, cliFlags.LimitLabelsBy)
+	if err != nil {
+		return nil, err
+	}
 	cliOpts := CliOpts{
-		squeue:        []string{"squeue", "--json"},
-		sinfo:         []string{"sinfo", "--json"},
-		lic:           []string{"scontrol", "show", "lic", "--json"},
-		sdiag:         []string{"sdiag", "--json"},
-		sacctmgr:      []string{"sacctmgr", "show", "assoc", "format=User,Account,GrpCPU,GrpMem,GrpJobs,GrpSubmit", "--noheader", "--parsable2"},
-		licEnabled:    cliFlags.SlurmLicEnabled,
-		diagsEnabled:  cliFlags.SlurmDiagEnabled,
-		fallback:      cliFlags.SlurmCliFallback,
-		sacctEnabled:  cliFlags.SacctEnabled,
-		excludeFilter: compiledExcludeRegex,
+		squeue:               []string{"squeue", "--json"},
+		sinfo:                []string{"sinfo", "--json"},
+		lic:                  []string{"scontrol", "show", "lic", "--json"},
+		sdiag:                []string{"sdiag", "--json"},
+		sacctmgr:             []string{"sacctmgr", "show", "assoc", "format=User,Account,GrpCPU,GrpMem,GrpJobs,GrpSubmit", "--noheader", "--parsable2"},
+		sshare:               []string{"sshare", "-a", "-P", "-o", "Account,User,Partition,RawShares,NormShares,RawUsage,EffectvUsage,FairShare,LevelFS"},
+		sprio:                []string{"sprio", "-h", "-o", "%i|%r|%u|%o|%Y|%A|%F|%J|%P|%Q|%T"},
+		sacct:                []string{"sacct", "-a", "-X", "-n", "-P", "--state=BF,CA,CD,DL,F,NF,OOM,PR,TO", "-o", "JobID,State,ExitCode,Partition,Account,User,Submit,Start,End"},
+		reservations:         []string{"scontrol", "show", "reservation", "--json"},
+		sstat:                []string{"sstat", "-a", "-n", "-P", "-o", "JobID,NTasks,AveCPU,MaxRSS,AveDiskRead,AveDiskWrite"},
+		sacctEfficiency:      []string{"sacct", "-a", "-n", "-P", "--state=BF,CA,CD,DL,F,NF,OOM,PR,TO", "-o", "JobID,State,Partition,Account,User,End,Elapsed,TotalCPU,AllocCPUS,NNodes,ReqMem,TRESUsageInTot"},
+		licEnabled:           cliFlags.SlurmLicEnabled,
+		diagsEnabled:         cliFlags.SlurmDiagEnabled,
+		fallback:             cliFlags.SlurmCliFallback,
+		sacctEnabled:         cliFlags.SacctEnabled,
+		fairshareEnabled:     cliFlags.FairshareEnabled,
+		priorityEnabled:      cliFlags.PriorityEnabled,
+		completedJobsEnabled: cliFlags.CompletedJobsEnabled,
+		reservationsEnabled:  cliFlags.ReservationsEnabled,
+		efficiencyEnabled:    cliFlags.EfficiencyEnabled,
+		seffEnabled:          cliFlags.SeffEnabled,
+		nodeMetricsEnabled:   cliFlags.NodeMetricsEnabled,
+		excludeFilter:        compiledExcludeRegex,
+		// per job metrics
+		jobMetricsEnabled: cliFlags.JobMetricsEnabled,
+		nodeJobsEnabled:   cliFlags.NodeJobsEnabled,
+		jobMetricsMax:     5000,
+		jobMetricsStates:  []string{"RUNNING"},
+		timeLimitWarning:  30 * time.Minute,
+		priorityTopJobs:   10,
+		sstatBatchSize:    50,
+		sacctCursorFile:   cliFlags.SacctCursorFile,
+		accountQosLabels:  cliFlags.AccountQosLabels,
+		pendingReasons:    pendingReasons,
+		labelLimits:       labelLimits,
 	}
 	traceConf := TraceConfig{
 		enabled: cliFlags.TraceEnabled,
@@ -115,6 +239,37 @@ func NewConfig(cliFlags *CliFlags) (*Config, error) {
 	if cliFlags.SlurmPollLimit > 0 {
 		config.PollLimit = cliFlags.SlurmPollLimit
 	}
+	if pi, ok := os.LookupEnv("POLL_INTERVAL"); ok {
+		if interval, err := strconv.ParseFloat(pi, 64); err != nil {
+			return nil, err
+		} else {
+			config.PollInterval = interval
+		}
+	}
+	if cliFlags.SlurmPollInterval > 0 {
+		config.PollInterval = cliFlags.SlurmPollInterval
+	}
+	if cliFlags.SlurmStaleMaxAge > 0 {
+		config.StaleMaxAge = cliFlags.SlurmStaleMaxAge
+	}
+	if cliFlags.SlurmCliTimeout > 0 {
+		cliOpts.timeout = time.Duration(cliFlags.SlurmCliTimeout * float64(time.Second))
+	}
+	if cliFlags.SlurmCliRetries > 0 {
+		cliOpts.retries = cliFlags.SlurmCliRetries
+	}
+	if cliFlags.SlurmCliRetryBackoff > 0 {
+		cliOpts.backoff = time.Duration(cliFlags.SlurmCliRetryBackoff * float64(time.Second))
+	}
+	if cliFlags.JobMetricsMax > 0 {
+		cliOpts.jobMetricsMax = cliFlags.JobMetricsMax
+	}
+	if cliFlags.JobMetricsStates != "" {
+		cliOpts.jobMetricsStates = strings.Split(strings.ToUpper(cliFlags.JobMetricsStates), ",")
+	}
+	if cliFlags.TimeLimitWarning > 0 {
+		cliOpts.timeLimitWarning = time.Duration(cliFlags.TimeLimitWarning * float64(time.Second))
+	}
 	if lvl, ok := os.LookupEnv("LOGLEVEL"); ok {
 		config.LogLevel = logLevelMap[lvl]
 	}
@@ -139,6 +294,30 @@ func NewConfig(cliFlags *CliFlags) (*Config, error) {
 	if cliFlags.SlurmAcctOverride != "" {
 		cliOpts.sacctmgr = strings.Split(cliFlags.SlurmAcctOverride, " ")
 	}
+	if cliFlags.SlurmSshareOverride != "" {
+		cliOpts.sshare = strings.Split(cliFlags.SlurmSshareOverride, " ")
+	}
+	if cliFlags.SlurmSprioOverride != "" {
+		cliOpts.sprio = strings.Split(cliFlags.SlurmSprioOverride, " ")
+	}
+	if cliFlags.SlurmSacctOverride != "" {
+		cliOpts.sacct = strings.Split(cliFlags.SlurmSacctOverride, " ")
+	}
+	if cliFlags.SlurmReservationOverride != "" {
+		cliOpts.reservations = strings.Split(cliFlags.SlurmReservationOverride, " ")
+	}
+	if cliFlags.SlurmSacctEffOverride != "" {
+		cliOpts.sacctEfficiency = strings.Split(cliFlags.SlurmSacctEffOverride, " ")
+	}
+	if cliFlags.SlurmSstatOverride != "" {
+		cliOpts.sstat = strings.Split(cliFlags.SlurmSstatOverride, " ")
+	}
+	if cliFlags.SstatBatchSize > 0 {
+		cliOpts.sstatBatchSize = cliFlags.SstatBatchSize
+	}
+	if cliFlags.PriorityTopJobs > 0 {
+		cliOpts.priorityTopJobs = cliFlags.PriorityTopJobs
+	}
 	if cliFlags.TraceRate != 0 {
 		traceConf.rate = cliFlags.TraceRate
 	}
@@ -148,44 +327,106 @@ func NewConfig(cliFlags *CliFlags) (*Config, error) {
 	if cliFlags.SlurmLicenseOverride != "" {
 		cliOpts.lic = strings.Split(cliFlags.SlurmLicenseOverride, " ")
 	}
+	if cliFlags.SlurmRestUrl != "" {
+		restOpts, err := newRestOpts(cliFlags)
+		if err != nil {
+			return nil, err
+		}
+		cliOpts.rest = restOpts
+	}
 	if cliOpts.fallback {
 		// we define a custom json format that we convert back into the openapi format
 		if cliFlags.SlurmSqueueOverride == "" {
-			cliOpts.squeue = []string{"squeue", "--states=all", "-h", "-r", "-o", `{"a": "%a", "id": %A, "end_time": "%e", "u": "%u", "state": "%T", "p": "%P", "cpu": %C, "mem": "%m", "array_id": "%K", "r": "%R"}`}
+			cliOpts.squeue = []string{"squeue", "--states=all", "-h", "-r", "-o", `{"a": "%a", "id": %A, "end_time": "%e", "submit": "%V", "start": "%S", "limit": "%l", "u": "%u", "state": "%T", "p": "%P", "qos": "%q", "cpu": %C, "mem": "%m", "gres": "%b", "nodes": %D, "array_job_id": %F, "array_id": "%K", "n": "%N", "r": "%R"}`}
 		}
 		if cliFlags.SlurmSinfoOverride == "" {
-			cliOpts.sinfo = []string{"sinfo", "-h", "-o", `{"s": "%T", "mem": %m, "n": "%n", "l": "%O", "p": "%R", "fmem": "%e", "cstate": "%C", "w": %w}`}
+			cliOpts.sinfo = []string{"sinfo", "-h", "-o", `{"s": "%T", "mem": %m, "n": "%n", "l": "%O", "p": "%R", "fmem": "%e", "cstate": "%C", "w": %w, "g": "%G"}`}
 		}
-		// must instantiate the job fetcher here since it is shared between 2 collectors
-		traceConf.sharedFetcher = &JobCliFallbackFetcher{
-			scraper: NewCliScraper(cliOpts.squeue...),
-			cache:   NewAtomicThrottledCache[JobMetric](config.PollLimit),
-			errCounter: prometheus.NewCounter(prometheus.CounterOpts{
-				Name: "job_scrape_errors",
-				Help: "job scrape errors",
-			}),
+		if cliFlags.SlurmReservationOverride == "" {
+			cliOpts.reservations = []string{"scontrol", "show", "reservation", "-o"}
 		}
-	} else {
-		traceConf.sharedFetcher = &JobJsonFetcher{
-			scraper: NewCliScraper(cliOpts.squeue...),
-			cache:   NewAtomicThrottledCache[JobMetric](config.PollLimit),
-			errCounter: prometheus.NewCounter(prometheus.CounterOpts{
-				Name: "job_scrape_errors",
-				Help: "job scrape errors",
-			}),
+	}
+	if len(cliFlags.Clusters) == 0 {
+		config.initJobFetcher()
+		return config, nil
+	}
+	if cliOpts.rest != nil {
+		return nil, errors.New("slurmrestd doesn't support scraping multiple clusters")
+	}
+	for i, cluster := range cliFlags.Clusters {
+		// traces are uploaded from nodes of the local cluster only
+		clusterConfig, err := newClusterConfig(config, cluster, traceConf.enabled && i == 0)
+		if err != nil {
+			return nil, err
 		}
+		config.clusters = append(config.clusters, clusterConfig)
 	}
 	return config, nil
 }
 
-func NewPromHTTPServer(metricsExcludeFilter *regexp.Regexp) http.Handler {
-	// Create a handler that filters metrics based on the exclude regex pattern
+// must instantiate the job fetcher up front since it is shared between 2 collectors
+// slurmrestd only speaks json so it takes precedence over the cli fallback
+func (c *Config) initJobFetcher() {
+	cliOpts := c.cliOpts
+	if cliOpts.fallback && !cliOpts.useRest(restJobs) {
+		c.TraceConf.sharedFetcher = &JobCliFallbackFetcher{
+			scraper:    cliOpts.newCliScraper(cliOpts.squeue),
+			cache:      newConfiguredCache[JobMetric](c, "jobs"),
+			errCounter: c.commandErrorCounter("squeue"),
+		}
+	} else {
+		c.TraceConf.sharedFetcher = &JobJsonFetcher{
+			scraper:    cliOpts.newScraper(restJobs, cliOpts.squeue),
+			cache:      newConfiguredCache[JobMetric](c, "jobs"),
+			errCounter: c.commandErrorCounter("squeue"),
+		}
+	}
+}
+
+// implemented by collectors that fetch from slurm so fetches can be bound to the scrape request
+type ContextCollector interface {
+	prometheus.Collector
+	CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric)
+}
+
+// binds a ContextCollector to the lifetime of a single scrape
+type scrapeCollector struct {
+	ContextCollector
+	ctx context.Context
+}
+
+func (sc *scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	sc.CollectWithContext(sc.ctx, ch)
+}
+
+// time reserved to encode and send the response before the prometheus scrape timeout
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+// derive a scrape context from the request, honoring the timeout prometheus advertises
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if header == "" {
+		return context.WithCancel(r.Context())
+	}
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to parse scrape timeout header %q: %q", header, err))
+		return context.WithCancel(r.Context())
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// drop all metric families matching the exclude regex
+func filterGatherer(gatherer prometheus.Gatherer, metricsExcludeFilter *regexp.Regexp) prometheus.Gatherer {
 	if metricsExcludeFilter == nil || metricsExcludeFilter.String() == "" {
-		return promhttp.Handler()
+		return gatherer
 	}
-	slog.Info("filtering metrics based on regex: " + metricsExcludeFilter.String())
-	filteredGatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
-		allMetrics, err := prometheus.DefaultGatherer.Gather()
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		allMetrics, err := gatherer.Gather()
 		if err != nil {
 			return nil, err
 		}
@@ -197,34 +438,169 @@ func NewPromHTTPServer(metricsExcludeFilter *regexp.Regexp) http.Handler {
 		}
 		return filteredMetrics, nil
 	})
-	return promhttp.HandlerFor(filteredGatherer, promhttp.HandlerOpts{})
 }
 
-func InitPromServer(config *Config) http.Handler {
+// serves the default registry along with collectors registered per scrape
+// so that slurm fetches are cancelled along with the scrape request
+type scrapeHandler struct {
+	collectors    []prometheus.Collector
+	excludeFilter *regexp.Regexp
+}
+
+func (sh *scrapeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	for _, collector := range sh.collectors {
+		if cc, ok := collector.(ContextCollector); ok {
+			collector = &scrapeCollector{ContextCollector: cc, ctx: ctx}
+		}
+		if err := registry.Register(collector); err != nil {
+			slog.Error(fmt.Sprintf("failed to register collector: %q", err))
+		}
+	}
+	gatherer := filterGatherer(prometheus.Gatherers{prometheus.DefaultGatherer, registry}, sh.excludeFilter)
+	promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+func NewPromHTTPServer(metricsExcludeFilter *regexp.Regexp, collectors ...prometheus.Collector) http.Handler {
+	if metricsExcludeFilter != nil && metricsExcludeFilter.String() != "" {
+		slog.Info("filtering metrics based on regex: " + metricsExcludeFilter.String())
+	}
+	handler := &scrapeHandler{
+		collectors:    collectors,
+		excludeFilter: metricsExcludeFilter,
+	}
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handler)
+}
+
+// wrap fetcher with a background poller if polling is enabled
+func schedule[M SlurmPrimitiveMetric](config *Config, scheduler *Scheduler, name string, fetcher SlurmMetricFetcher[M]) SlurmMetricFetcher[M] {
+	if config.PollInterval <= 0 {
+		return fetcher
+	}
+	pf := Schedule(scheduler, name, fetcher, time.Duration(config.PollInterval*float64(time.Second)))
+	pf.staleLimit = config.StaleMaxAge
+	return pf
+}
+
+func initLogger(config *Config) {
 	textHandler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level: config.LogLevel,
 	})
 	slog.SetDefault(slog.New(textHandler))
-	prometheus.MustRegister(NewNodeCollecter(config), NewJobsController(config))
-	if traceconf := config.TraceConf; traceconf.enabled {
+}
+
+// build every collector enabled by config, for every cluster. Background polling stops once ctx is done
+// the trace collector is returned separately since its upload handler needs to be served
+func newCollectors(ctx context.Context, config *Config) ([]prometheus.Collector, *TraceCollector) {
+	if len(config.clusters) == 0 {
+		return newClusterCollectors(ctx, config)
+	}
+	var collectors []prometheus.Collector
+	var traceCollector *TraceCollector
+	for _, cluster := range config.clusters {
+		slog.Info("collecting from cluster " + cluster.cluster)
+		clusterCollectors, clusterTrace := newClusterCollectors(ctx, cluster)
+		collectors = append(collectors, clusterCollectors...)
+		if clusterTrace != nil {
+			traceCollector = clusterTrace
+		}
+	}
+	return collectors, traceCollector
+}
+
+func newClusterCollectors(ctx context.Context, config *Config) ([]prometheus.Collector, *TraceCollector) {
+	scheduler := NewScheduler(config.constLabels())
+	// the jobs collector hooks its trackers into the shared fetcher, then the shared fetcher is wrapped before any other collector consumes it
+	jobsCollector := NewJobsController(config)
+	traceconf := config.TraceConf
+	traceconf.sharedFetcher = schedule(config, scheduler, "jobs", traceconf.sharedFetcher)
+	jobsCollector.SetFetcher(traceconf.sharedFetcher)
+	nodeCollector := NewNodeCollecter(config)
+	nodeCollector.SetFetcher(schedule(config, scheduler, "nodes", nodeCollector.fetcher))
+	collectors := []prometheus.Collector{nodeCollector, jobsCollector}
+	var traceCollector *TraceCollector
+	if traceconf.enabled {
 		slog.Info("trace path enabled at path: " + config.ListenAddress + traceconf.path)
-		traceController := NewTraceCollector(config)
-		http.HandleFunc(traceconf.path, traceController.uploadTrace)
-		prometheus.MustRegister(traceController)
+		traceCollector = NewTraceCollector(config)
+		collectors = append(collectors, traceCollector)
 	}
 	cliOpts := config.cliOpts
 	if cliOpts.licEnabled {
 		slog.Info("licence collection enabled")
-		prometheus.MustRegister(NewLicCollector(config))
+		licCollector := NewLicCollector(config)
+		licCollector.fetcher = schedule(config, scheduler, "licenses", licCollector.fetcher)
+		collectors = append(collectors, licCollector)
 	}
 	if cliOpts.diagsEnabled {
 		slog.Info("daemon diagnostic collection enabled")
-		prometheus.MustRegister(NewDiagsCollector(config))
+		diagCollector := NewDiagsCollector(config)
+		diagCollector.fetcher = schedule(config, scheduler, "diags", diagCollector.fetcher)
+		collectors = append(collectors, diagCollector)
 	}
 	if cliOpts.sacctEnabled {
 		slog.Info("account limit collection enabled")
-		prometheus.MustRegister(NewLimitCollector(config))
+		limitCollector := NewLimitCollector(config)
+		limitCollector.fetcher = schedule(config, scheduler, "limits", limitCollector.fetcher)
+		collectors = append(collectors, limitCollector)
+	}
+	if cliOpts.fairshareEnabled {
+		slog.Info("fairshare collection enabled")
+		fairshareCollector := NewFairshareCollector(config)
+		fairshareCollector.fetcher = schedule(config, scheduler, "fairshare", fairshareCollector.fetcher)
+		collectors = append(collectors, fairshareCollector)
+	}
+	if cliOpts.priorityEnabled {
+		slog.Info("job priority collection enabled")
+		priorityCollector := NewPriorityCollector(config)
+		priorityCollector.fetcher = schedule(config, scheduler, "priority", priorityCollector.fetcher)
+		collectors = append(collectors, priorityCollector)
 	}
+	if cliOpts.completedJobsEnabled {
+		slog.Info("completed job collection enabled")
+		completedJobsCollector := NewCompletedJobsCollector(config)
+		completedJobsCollector.fetcher = schedule(config, scheduler, "completed_jobs", completedJobsCollector.fetcher)
+		collectors = append(collectors, completedJobsCollector)
+	}
+	if cliOpts.reservationsEnabled {
+		slog.Info("reservation collection enabled")
+		reservationCollector := NewReservationCollector(config, nodeCollector.fetcher)
+		reservationCollector.fetcher = schedule(config, scheduler, "reservations", reservationCollector.fetcher)
+		collectors = append(collectors, reservationCollector)
+	}
+	if cliOpts.seffEnabled {
+		slog.Info("completed job efficiency collection enabled")
+		completedEfficiencyCollector := NewCompletedJobEfficiencyCollector(config)
+		completedEfficiencyCollector.fetcher = schedule(config, scheduler, "completed_efficiency", completedEfficiencyCollector.fetcher)
+		collectors = append(collectors, completedEfficiencyCollector)
+	}
+	if cliOpts.efficiencyEnabled {
+		if len(cliOpts.sstat) == 0 {
+			slog.Warn(fmt.Sprintf("sstat can't select a cluster, set sstat_cli for cluster %s to collect job efficiency", config.cluster))
+		} else {
+			slog.Info("running job efficiency collection enabled")
+			efficiencyCollector := NewJobEfficiencyCollector(config, traceconf.sharedFetcher)
+			efficiencyCollector.fetcher = schedule(config, scheduler, "efficiency", efficiencyCollector.fetcher)
+			collectors = append(collectors, efficiencyCollector)
+		}
+	}
+	if config.PollInterval > 0 {
+		scheduler.Start(ctx)
+		collectors = append(collectors, scheduler)
+	}
+	collectors = append(collectors, NewCacheCollector(config))
+	if config.commandErrors != nil {
+		collectors = append(collectors, config.commandErrors)
+	}
+	return collectors, traceCollector
+}
 
-	return NewPromHTTPServer(cliOpts.excludeFilter)
+func InitPromServer(config *Config) http.Handler {
+	initLogger(config)
+	collectors, traceCollector := newCollectors(context.Background(), config)
+	if traceCollector != nil {
+		http.HandleFunc(config.TraceConf.path, traceCollector.uploadTrace)
+	}
+	return NewPromHTTPServer(config.cliOpts.excludeFilter, collectors...)
 }
@@ -0,0 +1,421 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sstat and sacct cpu times, i.e 1-02:03:04, 02:03:04 or 03:04.567. Empty values are 0
+func parseSlurmCpuTime(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	var days float64
+	if dayStr, rest, ok := strings.Cut(value, "-"); ok {
+		d, err := strconv.ParseFloat(dayStr, 64)
+		if err != nil {
+			return 0, err
+		}
+		days, value = d, rest
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("unexpected cpu time format %q", value)
+	}
+	seconds := 0.
+	for _, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, err
+		}
+		seconds = seconds*60 + n
+	}
+	return days*24*3600 + seconds, nil
+}
+
+var slurmByteUnits = map[byte]float64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40, 'P': 1 << 50}
+
+// sstat and sacct sizes, i.e 1084K or 2.50G. Values without a unit are bytes and empty values are 0
+func parseSlurmBytes(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	multiplier := 1.
+	if unit, ok := slurmByteUnits[value[len(value)-1]]; ok {
+		multiplier, value = unit, value[:len(value)-1]
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
+
+// usage of a running job summed over its steps, as sampled by sstat
+type JobEfficiencyMetric struct {
+	JobId     float64
+	UserName  string
+	Account   string
+	Partition string
+	// cpu seconds used by the steps still running and cpu seconds allocated since the job started, at the time of the sample.
+	// sstat doesn't report finished steps, so jobs that ran steps before their current ones read low
+	CpuUsed  float64
+	CpuAlloc float64
+	// largest task rss of any step, like seff
+	MaxRss    float64
+	AllocMem  float64
+	DiskRead  float64
+	DiskWrite float64
+}
+
+// samples running jobs with sstat, a shard of at most batchSize jobs per fetch so every job is sampled
+// once per len(jobs) / batchSize fetches. Samples are kept until the job stops running
+type SstatFetcher struct {
+	// guards duration, offset and samples. Fetches are serialized by the cache but read concurrently by collectors
+	sync.Mutex
+	// sstat command, the job ids of the shard are appended with -j
+	args       []string
+	newScraper func(args []string) SlurmByteScraper
+	jobFetcher SlurmMetricFetcher[JobMetric]
+	batchSize  int
+	duration   time.Duration
+	cache      *AtomicThrottledCache[JobEfficiencyMetric]
+	errCounter *prometheus.CounterVec
+	now        func() time.Time
+	offset     int
+	samples    map[float64]JobEfficiencyMetric
+}
+
+// the next size ids starting at offset, wrapping around. Also returns the offset of the following shard
+func nextShard(ids []float64, offset int, size int) ([]float64, int) {
+	if size <= 0 || size >= len(ids) {
+		return ids, 0
+	}
+	offset %= len(ids)
+	shard := make([]float64, 0, size)
+	for i := 0; i < size; i++ {
+		shard = append(shard, ids[(offset+i)%len(ids)])
+	}
+	return shard, (offset + size) % len(ids)
+}
+
+// sum the steps of each job in the shard, i.e `123.batch|1|01:30:00|4G|1.5G|512M`
+func (sf *SstatFetcher) parseSteps(sstat []byte, shard map[float64]*JobEfficiencyMetric) {
+	for i, line := range bytes.Split(bytes.TrimSpace(sstat), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		fields := strings.Split(string(line), "|")
+		if len(fields) != 6 {
+			slog.Error(fmt.Sprintf("sstat parse error: unexpected field count on line %d `%s`", i, line))
+			sf.errCounter.WithLabelValues(reasonParse).Inc()
+			continue
+		}
+		jobIdStr, _, _ := strings.Cut(fields[0], ".")
+		jobId, err := strconv.ParseFloat(jobIdStr, 64)
+		if err != nil {
+			slog.Error(fmt.Sprintf("sstat parse error: unexpected job id on line %d `%s`", i, line))
+			sf.errCounter.WithLabelValues(reasonParse).Inc()
+			continue
+		}
+		sample, ok := shard[jobId]
+		if !ok {
+			continue
+		}
+		tasks, taskErr := strconv.ParseFloat(fields[1], 64)
+		aveCpu, cpuErr := parseSlurmCpuTime(fields[2])
+		maxRss, rssErr := parseSlurmBytes(fields[3])
+		aveRead, readErr := parseSlurmBytes(fields[4])
+		aveWrite, writeErr := parseSlurmBytes(fields[5])
+		if taskErr != nil || cpuErr != nil || rssErr != nil || readErr != nil || writeErr != nil {
+			slog.Error(fmt.Sprintf("sstat parse error: failed on line %d `%s`", i, line))
+			sf.errCounter.WithLabelValues(reasonParse).Inc()
+			continue
+		}
+		// sstat averages over the tasks of a step
+		sample.CpuUsed += aveCpu * tasks
+		sample.MaxRss = max(sample.MaxRss, maxRss)
+		sample.DiskRead += aveRead * tasks
+		sample.DiskWrite += aveWrite * tasks
+	}
+}
+
+func (sf *SstatFetcher) fetch(ctx context.Context) ([]JobEfficiencyMetric, error) {
+	jobs, err := sf.jobFetcher.FetchMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := sf.now()
+	running := make(map[float64]*JobMetric)
+	for i := range jobs {
+		if jobs[i].JobState == "RUNNING" && jobs[i].StartTime > 0 {
+			running[jobs[i].JobId] = &jobs[i]
+		}
+	}
+	ids := make([]float64, 0, len(running))
+	for id := range running {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	sf.Lock()
+	for id := range sf.samples {
+		if _, ok := running[id]; !ok {
+			delete(sf.samples, id)
+		}
+	}
+	var shardIds []float64
+	shardIds, sf.offset = nextShard(ids, sf.offset, sf.batchSize)
+	sf.Unlock()
+	if len(shardIds) > 0 {
+		shard := make(map[float64]*JobEfficiencyMetric, len(shardIds))
+		jobIds := make([]string, 0, len(shardIds))
+		for _, id := range shardIds {
+			job := running[id]
+			elapsed := max(float64(now.Unix())-float64(job.StartTime), 0)
+			shard[id] = &JobEfficiencyMetric{
+				JobId:     id,
+				UserName:  job.UserName,
+				Account:   job.Account,
+				Partition: job.Partition,
+				CpuAlloc:  job.JobResources.AllocCpus * elapsed,
+				AllocMem:  totalAllocMem(&job.JobResources),
+			}
+			jobIds = append(jobIds, fmt.Sprint(int64(id)))
+		}
+		scraper := sf.newScraper(append(slices.Clone(sf.args), "-j", strings.Join(jobIds, ",")))
+		sstat, err := scraper.FetchRawBytes(ctx)
+		sf.Lock()
+		sf.duration = scraper.Duration()
+		sf.Unlock()
+		if err != nil {
+			// the shard keeps its previous samples and the rotation moves on, so a failing job can't stall it
+			observeCommandError(sf.errCounter, err)
+			slog.Error(fmt.Sprintf("failed to scrape job usage with %q", err))
+		} else {
+			sf.parseSteps(sstat, shard)
+			sf.Lock()
+			for id, sample := range shard {
+				sf.samples[id] = *sample
+			}
+			sf.Unlock()
+		}
+	}
+	sf.Lock()
+	defer sf.Unlock()
+	samples := make([]JobEfficiencyMetric, 0, len(sf.samples))
+	for _, sample := range sf.samples {
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+func (sf *SstatFetcher) FetchMetrics(ctx context.Context) ([]JobEfficiencyMetric, error) {
+	return sf.cache.FetchOrThrottle(func() ([]JobEfficiencyMetric, error) { return sf.fetch(ctx) })
+}
+
+func (sf *SstatFetcher) ScrapeDuration() time.Duration {
+	sf.Lock()
+	defer sf.Unlock()
+	return sf.duration
+}
+
+// share of the allocation used, up to fully used
+var efficiencyBuckets = []float64{.1, .25, .5, .75, .9, 1}
+
+type JobEfficiencyAggregate struct {
+	jobs      float64
+	cpuUsed   float64
+	cpuAlloc  float64
+	maxRss    float64
+	allocMem  float64
+	diskRead  float64
+	diskWrite float64
+}
+
+type jobEfficiencyKey struct {
+	partition string
+	account   string
+}
+
+// share of its allocation a job used, 0 when nothing was allocated yet
+func efficiencyRatio(used float64, alloc float64) float64 {
+	if alloc <= 0 {
+		return 0
+	}
+	return used / alloc
+}
+
+// sampled jobs summed per partition and account, and the cpu efficiency of each job per partition
+func parseJobEfficiencyMetrics(samples []JobEfficiencyMetric) (map[jobEfficiencyKey]*JobEfficiencyAggregate, map[string]*HistogramMetric) {
+	aggregates := make(map[jobEfficiencyKey]*JobEfficiencyAggregate)
+	partitions := make(map[string]*HistogramMetric)
+	for _, sample := range samples {
+		key := jobEfficiencyKey{partition: sample.Partition, account: sample.Account}
+		aggregate, ok := aggregates[key]
+		if !ok {
+			aggregate = new(JobEfficiencyAggregate)
+			aggregates[key] = aggregate
+		}
+		aggregate.jobs++
+		aggregate.cpuUsed += sample.CpuUsed
+		aggregate.cpuAlloc += sample.CpuAlloc
+		aggregate.maxRss += sample.MaxRss
+		aggregate.allocMem += sample.AllocMem
+		aggregate.diskRead += sample.DiskRead
+		aggregate.diskWrite += sample.DiskWrite
+		if sample.CpuAlloc > 0 {
+			observeHistogram(partitions, sample.Partition, efficiencyBuckets, efficiencyRatio(sample.CpuUsed, sample.CpuAlloc))
+		}
+	}
+	return aggregates, partitions
+}
+
+type JobEfficiencyCollector struct {
+	fetcher           SlurmMetricFetcher[JobEfficiencyMetric]
+	jobMetricsEnabled bool
+	jobMetricsMax     int
+	sampledJobs       *prometheus.Desc
+	cpuUsed           *prometheus.Desc
+	cpuAlloc          *prometheus.Desc
+	maxRss            *prometheus.Desc
+	allocMem          *prometheus.Desc
+	diskRead          *prometheus.Desc
+	diskWrite         *prometheus.Desc
+	cpuEfficiency     *prometheus.Desc
+	// per job metrics
+	jobCpuEfficiency *prometheus.Desc
+	jobMemEfficiency *prometheus.Desc
+	labelLimits      LabelLimiter
+	foldedValues     *prometheus.Desc
+	// exporter metrics
+	scrapeDuration *prometheus.Desc
+}
+
+func NewJobEfficiencyCollector(config *Config, jobFetcher SlurmMetricFetcher[JobMetric]) *JobEfficiencyCollector {
+	cliOpts := config.cliOpts
+	labels := []string{"partition", "account"}
+	jobLabels := []string{"jobid", "user", "account", "partition"}
+	return &JobEfficiencyCollector{
+		fetcher: &SstatFetcher{
+			args: cliOpts.sstat,
+			newScraper: func(args []string) SlurmByteScraper {
+				// sstat warns about jobs of the shard that finished or have no steps yet and still reports the others
+				scraper := cliOpts.newCliScraper(args)
+				scraper.stderrWarnings = true
+				return scraper
+			},
+			jobFetcher: jobFetcher,
+			batchSize:  cliOpts.sstatBatchSize,
+			cache:      newConfiguredCache[JobEfficiencyMetric](config, "efficiency"),
+			errCounter: config.commandErrorCounter("sstat"),
+			now:        time.Now,
+			samples:    make(map[float64]JobEfficiencyMetric),
+		},
+		jobMetricsEnabled: cliOpts.jobMetricsEnabled,
+		jobMetricsMax:     cliOpts.jobMetricsMax,
+		labelLimits:       cliOpts.labelLimits,
+		foldedValues:      newFoldedValuesDesc(config, "efficiency"),
+		sampledJobs:       prometheus.NewDesc("slurm_running_jobs_sampled", "running jobs with a sstat sample per partition and account", labels, config.constLabels()),
+		cpuUsed:           prometheus.NewDesc("slurm_running_job_cpu_used_seconds", "cpu seconds used by the running steps of sampled running jobs per partition and account", labels, config.constLabels()),
+		cpuAlloc:          prometheus.NewDesc("slurm_running_job_cpu_alloc_seconds", "cpu seconds allocated to sampled running jobs per partition and account", labels, config.constLabels()),
+		maxRss:            prometheus.NewDesc("slurm_running_job_max_rss_bytes", "largest task rss of sampled running jobs summed per partition and account", labels, config.constLabels()),
+		allocMem:          prometheus.NewDesc("slurm_running_job_mem_alloc", "mem allocated to sampled running jobs per partition and account", labels, config.constLabels()),
+		diskRead:          prometheus.NewDesc("slurm_running_job_disk_read_bytes", "bytes read by sampled running jobs per partition and account", labels, config.constLabels()),
+		diskWrite:         prometheus.NewDesc("slurm_running_job_disk_write_bytes", "bytes written by sampled running jobs per partition and account", labels, config.constLabels()),
+		cpuEfficiency:     prometheus.NewDesc("slurm_running_job_cpu_efficiency", "share of the allocated cpu time used by the running steps of sampled running jobs per partition", []string{"partition"}, config.constLabels()),
+		jobCpuEfficiency:  prometheus.NewDesc("slurm_job_cpu_efficiency", "share of the allocated cpu time used by the running steps per sampled running job", jobLabels, config.constLabels()),
+		jobMemEfficiency:  prometheus.NewDesc("slurm_job_mem_efficiency", "largest task rss over the mem allocated per sampled running job", jobLabels, config.constLabels()),
+		scrapeDuration:    prometheus.NewDesc("slurm_efficiency_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.sstat), nil, config.constLabels()),
+	}
+}
+
+// copy of samples with the accounts past the account limit renamed to otherLabelValue, ranked by allocated cpu time or mem
+// also returns how many accounts were folded
+func (jec *JobEfficiencyCollector) foldAccounts(samples []JobEfficiencyMetric) ([]JobEfficiencyMetric, float64) {
+	if jec.labelLimits.maxAccounts <= 0 {
+		return samples, 0
+	}
+	weights := make(map[string]float64)
+	for _, sample := range samples {
+		weights[sample.Account] += jec.labelLimits.weight(sample.CpuAlloc, sample.AllocMem)
+	}
+	accounts := topLabelValues(weights, jec.labelLimits.maxAccounts)
+	folded := slices.Clone(samples)
+	for i := range folded {
+		folded[i].Account = foldLabel(accounts, folded[i].Account)
+	}
+	return folded, foldedCount(weights, accounts)
+}
+
+func (jec *JobEfficiencyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- jec.sampledJobs
+	ch <- jec.cpuUsed
+	ch <- jec.cpuAlloc
+	ch <- jec.maxRss
+	ch <- jec.allocMem
+	ch <- jec.diskRead
+	ch <- jec.diskWrite
+	ch <- jec.cpuEfficiency
+	ch <- jec.jobCpuEfficiency
+	ch <- jec.jobMemEfficiency
+	ch <- jec.foldedValues
+	ch <- jec.scrapeDuration
+}
+
+func (jec *JobEfficiencyCollector) Collect(ch chan<- prometheus.Metric) {
+	jec.CollectWithContext(context.Background(), ch)
+}
+
+func (jec *JobEfficiencyCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	samples, err := jec.fetcher.FetchMetrics(ctx)
+	ch <- prometheus.MustNewConstMetric(jec.scrapeDuration, prometheus.GaugeValue, float64(jec.fetcher.ScrapeDuration().Milliseconds()))
+	if err != nil {
+		slog.Error(fmt.Sprintf("sstat fetch error %q", err))
+		return
+	}
+	foldedSamples, folded := jec.foldAccounts(samples)
+	if jec.labelLimits.maxAccounts > 0 {
+		ch <- prometheus.MustNewConstMetric(jec.foldedValues, prometheus.GaugeValue, folded, "account")
+	}
+	aggregates, partitions := parseJobEfficiencyMetrics(foldedSamples)
+	for key, aggregate := range aggregates {
+		ch <- prometheus.MustNewConstMetric(jec.sampledJobs, prometheus.GaugeValue, aggregate.jobs, key.partition, key.account)
+		ch <- prometheus.MustNewConstMetric(jec.cpuUsed, prometheus.GaugeValue, aggregate.cpuUsed, key.partition, key.account)
+		ch <- prometheus.MustNewConstMetric(jec.cpuAlloc, prometheus.GaugeValue, aggregate.cpuAlloc, key.partition, key.account)
+		ch <- prometheus.MustNewConstMetric(jec.maxRss, prometheus.GaugeValue, aggregate.maxRss, key.partition, key.account)
+		ch <- prometheus.MustNewConstMetric(jec.allocMem, prometheus.GaugeValue, aggregate.allocMem, key.partition, key.account)
+		ch <- prometheus.MustNewConstMetric(jec.diskRead, prometheus.GaugeValue, aggregate.diskRead, key.partition, key.account)
+		ch <- prometheus.MustNewConstMetric(jec.diskWrite, prometheus.GaugeValue, aggregate.diskWrite, key.partition, key.account)
+	}
+	for partition, metric := range partitions {
+		ch <- metric.constHistogram(jec.cpuEfficiency, partition)
+	}
+	if !jec.jobMetricsEnabled {
+		return
+	}
+	// capped like the other per job metrics, lowest job ids first so the exported jobs are stable
+	samples = slices.Clone(samples)
+	slices.SortFunc(samples, func(a, b JobEfficiencyMetric) int { return cmp.Compare(a.JobId, b.JobId) })
+	if jec.jobMetricsMax > 0 && len(samples) > jec.jobMetricsMax {
+		samples = samples[:jec.jobMetricsMax]
+	}
+	for _, sample := range samples {
+		jobid := fmt.Sprint(int64(sample.JobId))
+		ch <- prometheus.MustNewConstMetric(jec.jobCpuEfficiency, prometheus.GaugeValue, efficiencyRatio(sample.CpuUsed, sample.CpuAlloc), jobid, sample.UserName, sample.Account, sample.Partition)
+		ch <- prometheus.MustNewConstMetric(jec.jobMemEfficiency, prometheus.GaugeValue, efficiencyRatio(sample.MaxRss, sample.AllocMem), jobid, sample.UserName, sample.Account, sample.Partition)
+	}
+}
@@ -0,0 +1,203 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSlurmCpuTime(t *testing.T) {
+	assert := assert.New(t)
+	for value, expected := range map[string]float64{"": 0, "00:00:00": 0, "01:30:00": 5400, "10:00.500": 600.5, "1-02:03:04": 93784} {
+		seconds, err := parseSlurmCpuTime(value)
+		assert.NoError(err)
+		assert.Equal(expected, seconds, value)
+	}
+	_, err := parseSlurmCpuTime("xx")
+	assert.Error(err)
+	_, err = parseSlurmCpuTime("1:2:3:4")
+	assert.Error(err)
+}
+
+func TestParseSlurmBytes(t *testing.T) {
+	assert := assert.New(t)
+	for value, expected := range map[string]float64{"": 0, "0": 0, "512": 512, "1084K": 1084 * 1024, "2.5M": 2.5 * 1024 * 1024, "4G": 4 << 30} {
+		size, err := parseSlurmBytes(value)
+		assert.NoError(err)
+		assert.Equal(expected, size, value)
+	}
+	_, err := parseSlurmBytes("G")
+	assert.Error(err)
+}
+
+func TestNextShard(t *testing.T) {
+	assert := assert.New(t)
+	ids := []float64{1, 2, 3, 4, 5}
+	shard, offset := nextShard(ids, 0, 2)
+	assert.Equal([]float64{1, 2}, shard)
+	shard, offset = nextShard(ids, offset, 2)
+	assert.Equal([]float64{3, 4}, shard)
+	// wraps around
+	shard, offset = nextShard(ids, offset, 2)
+	assert.Equal([]float64{5, 1}, shard)
+	assert.Equal(1, offset)
+	// offsets past a shrunk job list start over
+	shard, _ = nextShard(ids[:2], 4, 1)
+	assert.Equal([]float64{1}, shard)
+	shard, _ = nextShard(ids, 3, 0)
+	assert.Equal(ids, shard)
+}
+
+func newMockSstatFetcher(batchSize int) (*SstatFetcher, *[][]string) {
+	calls := new([][]string)
+	return &SstatFetcher{
+		args: []string{"sstat"},
+		newScraper: func(args []string) SlurmByteScraper {
+			*calls = append(*calls, args)
+			return &MockScraper{fixture: "fixtures/sstat.txt"}
+		},
+		jobFetcher: &JobCliFallbackFetcher{
+			scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
+			cache:      NewAtomicThrottledCache[JobMetric](1),
+			errCounter: newMockErrorCounter(),
+		},
+		batchSize:  batchSize,
+		cache:      NewAtomicThrottledCache[JobEfficiencyMetric](1),
+		errCounter: newMockErrorCounter(),
+		now:        func() time.Time { return time.Date(2023, 9, 21, 12, 0, 0, 0, time.Local) },
+		samples:    make(map[float64]JobEfficiencyMetric),
+	}, calls
+}
+
+func TestSstatFetch(t *testing.T) {
+	assert := assert.New(t)
+	fetcher, calls := newMockSstatFetcher(2)
+	samples, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	// running jobs are sampled in job id order, a shard at a time
+	assert.Equal([][]string{{"sstat", "-j", "60000,26515966"}}, *calls)
+	assert.Len(samples, 2)
+	// a truncated line and an unparsable cpu time
+	assert.Equal(2., CollectCounterValue(fetcher.errCounter.WithLabelValues(reasonParse)))
+	job := fetcher.samples[26515966]
+	assert.Equal(5400.+2*36000, job.CpuUsed)
+	// running since 2023-09-20T00:22:00 on a single cpu
+	assert.Equal(float64(35*3600+38*60), job.CpuAlloc)
+	assert.Equal(float64(30<<30), job.MaxRss)
+	assert.Equal(2*2.5*(1<<20)+1.5*(1<<30)+2*10*(1<<30), job.DiskRead)
+	assert.Equal("account1", job.Account)
+
+	samples, err = fetcher.fetch(context.Background())
+	assert.NoError(err)
+	assert.Equal([]string{"sstat", "-j", "50580016,60000"}, (*calls)[1])
+	assert.Len(samples, 3)
+	assert.Equal(600.5, fetcher.samples[50580016].CpuUsed)
+}
+
+func TestSstatFetch_DropsFinishedJobs(t *testing.T) {
+	assert := assert.New(t)
+	fetcher, _ := newMockSstatFetcher(0)
+	fetcher.samples[1] = JobEfficiencyMetric{JobId: 1}
+	samples, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	assert.Len(samples, 3)
+	assert.NotContains(fetcher.samples, 1.)
+}
+
+func TestSstatFetch_KeepsSamplesOnError(t *testing.T) {
+	assert := assert.New(t)
+	fetcher, _ := newMockSstatFetcher(2)
+	_, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	fetcher.newScraper = func(args []string) SlurmByteScraper { return &MockFetchErrored{} }
+	samples, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	assert.Len(samples, 2)
+	assert.Equal(5400.+2*36000, fetcher.samples[26515966].CpuUsed)
+	assert.NotContains(fetcher.samples, 50580016.)
+	// the rotation moved past the failed shard
+	assert.Equal(1, fetcher.offset)
+}
+
+func TestSstatFetch_Concurrent(t *testing.T) {
+	fetcher, _ := newMockSstatFetcher(1)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			fetcher.FetchMetrics(context.Background())
+		}()
+		go func() {
+			defer wg.Done()
+			fetcher.ScrapeDuration()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestJobEfficiencyCollect(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{JobMetricsEnabled: true})
+	assert.NoError(err)
+	jec := NewJobEfficiencyCollector(config, nil)
+	fetcher, _ := newMockSstatFetcher(0)
+	jec.fetcher = fetcher
+	jecChan := make(chan prometheus.Metric)
+	go func() {
+		jec.Collect(jecChan)
+		close(jecChan)
+	}()
+	descs := make(map[string]int)
+	for metric := range jecChan {
+		desc := metric.Desc().String()
+		descs[desc[strings.Index(desc, `"`)+1:strings.Index(desc, `",`)]]++
+	}
+	assert.Equal(3, descs["slurm_running_jobs_sampled"])
+	assert.Equal(3, descs["slurm_running_job_cpu_efficiency"])
+	assert.Equal(3, descs["slurm_job_cpu_efficiency"])
+	assert.Equal(1, descs["slurm_efficiency_scrape_duration"])
+}
+
+func TestJobEfficiencyFoldAccounts(t *testing.T) {
+	assert := assert.New(t)
+	samples := []JobEfficiencyMetric{
+		{Partition: "hw-h", Account: "account1", CpuAlloc: 100},
+		{Partition: "hw-h", Account: "account2", CpuAlloc: 50},
+		{Partition: "hw-h", Account: "account3", CpuAlloc: 10},
+	}
+	jec := &JobEfficiencyCollector{labelLimits: LabelLimiter{maxAccounts: 1, by: limitByCpus}}
+	folded, count := jec.foldAccounts(samples)
+	assert.Equal(2., count)
+	aggregates, _ := parseJobEfficiencyMetrics(folded)
+	assert.Equal(60., aggregates[jobEfficiencyKey{partition: "hw-h", account: otherLabelValue}].cpuAlloc)
+	assert.Equal("account2", samples[1].Account)
+}
+
+func TestParseJobEfficiencyMetrics(t *testing.T) {
+	assert := assert.New(t)
+	samples := []JobEfficiencyMetric{
+		{Partition: "hw-h", Account: "account1", CpuUsed: 10, CpuAlloc: 100, MaxRss: 1, AllocMem: 4},
+		{Partition: "hw-h", Account: "account1", CpuUsed: 90, CpuAlloc: 100, MaxRss: 2, AllocMem: 4},
+		// just started, nothing allocated yet
+		{Partition: "hw-l", Account: "account2"},
+	}
+	aggregates, partitions := parseJobEfficiencyMetrics(samples)
+	hwh := aggregates[jobEfficiencyKey{partition: "hw-h", account: "account1"}]
+	assert.Equal(2., hwh.jobs)
+	assert.Equal(100., hwh.cpuUsed)
+	assert.Equal(200., hwh.cpuAlloc)
+	assert.Equal(8., hwh.allocMem)
+	assert.Equal(uint64(2), partitions["hw-h"].count)
+	assert.NotContains(partitions, "hw-l")
+	assert.Equal(0., efficiencyRatio(1, 0))
+}
@@ -5,6 +5,7 @@
 package exporter
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -140,14 +141,14 @@ func NewTraceCollector(config *Config) *TraceCollector {
 		squeueFetcher:  traceConfig.sharedFetcher,
 		fallback:       config.cliOpts.fallback,
 		// add for job id correlation
-		jobAllocMem:  prometheus.NewDesc("slurm_job_mem_alloc", "running job mem allocated", []string{"jobid"}, nil),
-		jobAllocCpus: prometheus.NewDesc("slurm_job_cpu_alloc", "running job cpus allocated", []string{"jobid"}, nil),
-		pid:          prometheus.NewDesc("slurm_proc_pid", "pid of running slurm job", []string{"jobid", "hostname"}, nil),
-		cpuUsage:     prometheus.NewDesc("slurm_proc_cpu_usage", "actual cpu usage collected from proc monitor", []string{"jobid", "username"}, nil),
-		memUsage:     prometheus.NewDesc("slurm_proc_mem_usage", "proc mem usage", []string{"jobid", "username"}, nil),
-		threadCount:  prometheus.NewDesc("slurm_proc_threadcount", "threads currently being used", []string{"jobid", "username"}, nil),
-		writeBytes:   prometheus.NewDesc("slurm_proc_write_bytes", "proc write bytes", []string{"jobid", "username"}, nil),
-		readBytes:    prometheus.NewDesc("slurm_proc_read_bytes", "proc read bytes", []string{"jobid", "username"}, nil),
+		jobAllocMem:  prometheus.NewDesc("slurm_job_mem_alloc", "running job mem allocated", []string{"jobid"}, config.constLabels()),
+		jobAllocCpus: prometheus.NewDesc("slurm_job_cpu_alloc", "running job cpus allocated", []string{"jobid"}, config.constLabels()),
+		pid:          prometheus.NewDesc("slurm_proc_pid", "pid of running slurm job", []string{"jobid", "hostname"}, config.constLabels()),
+		cpuUsage:     prometheus.NewDesc("slurm_proc_cpu_usage", "actual cpu usage collected from proc monitor", []string{"jobid", "username"}, config.constLabels()),
+		memUsage:     prometheus.NewDesc("slurm_proc_mem_usage", "proc mem usage", []string{"jobid", "username"}, config.constLabels()),
+		threadCount:  prometheus.NewDesc("slurm_proc_threadcount", "threads currently being used", []string{"jobid", "username"}, config.constLabels()),
+		writeBytes:   prometheus.NewDesc("slurm_proc_write_bytes", "proc write bytes", []string{"jobid", "username"}, config.constLabels()),
+		readBytes:    prometheus.NewDesc("slurm_proc_read_bytes", "proc read bytes", []string{"jobid", "username"}, config.constLabels()),
 	}
 }
 
@@ -163,8 +164,12 @@ func (c *TraceCollector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (c *TraceCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectWithContext(context.Background(), ch)
+}
+
+func (c *TraceCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
 	procs := c.ProcessFetcher.Fetch()
-	jobMetrics, err := c.squeueFetcher.FetchMetrics()
+	jobMetrics, err := c.squeueFetcher.FetchMetrics(ctx)
 	if err != nil {
 		return
 	}
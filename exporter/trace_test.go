@@ -6,6 +6,7 @@ package exporter
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -109,7 +110,7 @@ func TestTraceControllerCollect(t *testing.T) {
 			sharedFetcher: &JobJsonFetcher{
 				scraper:    MockJobInfoScraper,
 				cache:      NewAtomicThrottledCache[JobMetric](1),
-				errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+				errCounter: newMockErrorCounter(),
 			},
 		},
 		cliOpts: new(CliOpts),
@@ -139,7 +140,7 @@ func TestTraceControllerCollect_Fallback(t *testing.T) {
 			sharedFetcher: &JobCliFallbackFetcher{
 				scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
 				cache:      NewAtomicThrottledCache[JobMetric](1),
-				errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+				errCounter: newMockErrorCounter(),
 			},
 		},
 		cliOpts: &CliOpts{fallback: true},
@@ -169,7 +170,7 @@ func TestTraceControllerDescribe(t *testing.T) {
 			sharedFetcher: &JobJsonFetcher{
 				scraper:    MockJobInfoScraper,
 				cache:      NewAtomicThrottledCache[JobMetric](1),
-				errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+				errCounter: newMockErrorCounter(),
 			},
 		},
 		cliOpts: new(CliOpts),
@@ -199,7 +200,7 @@ func TestPython3Wrapper(t *testing.T) {
 	assert := assert.New(t)
 	fetcher := NewCliScraper("python3", "../wrappers/proctrac.py", "--cmd", "sleep", "100", "--jobid=10", "--validate")
 	t.Logf("cmd: %+v", fetcher.args)
-	wrapperOut, err := fetcher.FetchRawBytes()
+	wrapperOut, err := fetcher.FetchRawBytes(context.Background())
 	assert.Nil(err)
 	var info TraceInfo
 	json.Unmarshal(wrapperOut, &info)
@@ -219,7 +220,10 @@ func TestDetectTraceRootPath_Env(t *testing.T) {
 
 func TestDetectTraceRootPath_Default(t *testing.T) {
 	testDir := t.TempDir()
-	os.Chdir(testDir)
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(testDir))
+	t.Cleanup(func() { os.Chdir(wd) })
 
 	// Should come back empty if since we don't yet have a 'templates' subdir
 	assert.Equal(t, detectTraceTemplatePath(), "")
@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// pseudo state of jobs missing from the previous fetch, i.e submitted since
+	newJobState = "NEW"
+	// pseudo state of jobs missing from the current fetch, i.e purged by slurmctld after MinJobAge
+	purgedJobState = "PURGED"
+)
+
+type jobSnapshot struct {
+	state     string
+	partition string
+	// as exported, after the account limit
+	account string
+}
+
+// counts job state changes between fetches by diffing consecutive job lists keyed by job id
+// nothing is persisted: after a restart the first fetch is a new baseline and the counters start from 0,
+// which rate() and increase() treat as a counter reset
+type JobTransitionTracker struct {
+	sync.Mutex
+	// jobs of the last fetch. nil until the first fetch
+	previous    map[float64]jobSnapshot
+	labelLimits LabelLimiter
+	accounts    *stickyLabels
+	transitions *prometheus.CounterVec
+}
+
+func NewJobTransitionTracker(constLabels prometheus.Labels, labelLimits LabelLimiter) *JobTransitionTracker {
+	return &JobTransitionTracker{
+		labelLimits: labelLimits,
+		accounts:    newStickyLabels(labelLimits.maxAccounts),
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "slurm_job_transitions_total",
+			Help:        "job state changes seen between fetches, NEW and PURGED stand for jobs entering and leaving the queue",
+			ConstLabels: constLabels,
+		}, []string{"from", "to", "partition", "account"}),
+	}
+}
+
+// idempotent for a given job list, so cached fetches can be observed on every scrape
+func (jt *JobTransitionTracker) observe(jobs []JobMetric) {
+	weights := make(map[string]float64)
+	for i := range jobs {
+		weights[jobs[i].Account] += jt.labelLimits.jobWeight(&jobs[i])
+	}
+	accounts := jt.accounts.fold(weights)
+	current := make(map[float64]jobSnapshot, len(jobs))
+	for i := range jobs {
+		job := &jobs[i]
+		current[job.JobId] = jobSnapshot{state: job.JobState, partition: job.Partition, account: accounts[job.Account]}
+	}
+	jt.Lock()
+	defer jt.Unlock()
+	if jt.previous != nil {
+		for id, job := range current {
+			from := newJobState
+			if prev, ok := jt.previous[id]; ok {
+				from = prev.state
+			}
+			// the partition and account of the current fetch, since pending jobs can list several partitions
+			if from != job.state {
+				jt.transitions.WithLabelValues(from, job.state, job.partition, job.account).Inc()
+			}
+		}
+		for id, prev := range jt.previous {
+			if _, ok := current[id]; !ok {
+				jt.transitions.WithLabelValues(prev.state, purgedJobState, prev.partition, prev.account).Inc()
+			}
+		}
+	}
+	jt.previous = current
+}
@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobTransitionTracker(t *testing.T) {
+	assert := assert.New(t)
+	tracker := NewJobTransitionTracker(nil, LabelLimiter{})
+	job := func(id float64, state string, partition string) JobMetric {
+		return JobMetric{JobId: id, JobState: state, Partition: partition, Account: "account1"}
+	}
+	transitions := func(from, to, partition string) float64 {
+		return CollectCounterValue(tracker.transitions.WithLabelValues(from, to, partition, "account1"))
+	}
+	// the first fetch is only a baseline, i.e after an exporter restart
+	tracker.observe([]JobMetric{job(1, "PENDING", "hw-h,hw-l"), job(2, "RUNNING", "hw-h"), job(3, "RUNNING", "hw-l")})
+	assert.Equal(0., transitions(newJobState, "PENDING", "hw-h,hw-l"))
+	assert.Equal(0., transitions(newJobState, "RUNNING", "hw-h"))
+
+	// job 1 started on one of its partitions, job 2 completed, job 3 left the queue and job 4 was submitted
+	jobs := []JobMetric{job(1, "RUNNING", "hw-l"), job(2, "COMPLETED", "hw-h"), job(4, "PENDING", "hw-h")}
+	tracker.observe(jobs)
+	assert.Equal(1., transitions("PENDING", "RUNNING", "hw-l"))
+	assert.Equal(1., transitions("RUNNING", "COMPLETED", "hw-h"))
+	assert.Equal(1., transitions("RUNNING", purgedJobState, "hw-l"))
+	assert.Equal(1., transitions(newJobState, "PENDING", "hw-h"))
+
+	// cached fetches aren't counted twice
+	tracker.observe(jobs)
+	assert.Equal(1., transitions("PENDING", "RUNNING", "hw-l"))
+	assert.Equal(1., transitions(newJobState, "PENDING", "hw-h"))
+
+	tracker.observe([]JobMetric{job(1, "RUNNING", "hw-l"), job(4, "RUNNING", "hw-h")})
+	assert.Equal(1., transitions("COMPLETED", purgedJobState, "hw-h"))
+	assert.Equal(1., transitions("PENDING", "RUNNING", "hw-h"))
+}
+
+func TestJobTransitionTracker_LabelLimits(t *testing.T) {
+	assert := assert.New(t)
+	tracker := NewJobTransitionTracker(nil, LabelLimiter{maxAccounts: 1, by: limitByJobs})
+	job := func(id float64, state string, account string) JobMetric {
+		return JobMetric{JobId: id, JobState: state, Partition: "hw-h", Account: account}
+	}
+	transitions := func(account string) float64 {
+		return CollectCounterValue(tracker.transitions.WithLabelValues("PENDING", "RUNNING", "hw-h", account))
+	}
+	tracker.observe([]JobMetric{job(1, "PENDING", "account1"), job(2, "PENDING", "account2")})
+	// account2 outranks account1 now, but the counters of account1 don't move to other
+	tracker.observe([]JobMetric{job(1, "RUNNING", "account1"), job(2, "RUNNING", "account2"), job(3, "PENDING", "account2")})
+	assert.Equal(1., transitions("account1"))
+	assert.Equal(1., transitions(otherLabelValue))
+	assert.Zero(transitions("account2"))
+	assert.Equal(1., tracker.accounts.foldedCount())
+}
+
+func TestJobTransitionTracker_ObservedByFetcher(t *testing.T) {
+	assert := assert.New(t)
+	scraper := new(StringByteScraper)
+	config := &Config{
+		TraceConf: &TraceConfig{
+			sharedFetcher: &JobCliFallbackFetcher{
+				scraper:    scraper,
+				cache:      NewAtomicThrottledCache[JobMetric](0),
+				errCounter: newMockErrorCounter(),
+			},
+		},
+		cliOpts: &CliOpts{fallback: true},
+	}
+	jc := NewJobsController(config)
+	assert.True(jc.observedByFetcher)
+	// polls between scrapes see the job go through every state
+	pf := Schedule(NewScheduler(nil), "jobs", config.TraceConf.sharedFetcher, time.Second)
+	for _, state := range []string{"PENDING", "RUNNING", "COMPLETED"} {
+		scraper.msg = fmt.Sprintf(`{"a": "account1", "id": 1, "end_time": "N/A", "submit": "2023-09-21T09:00:00", "start": "N/A", "limit": "1:00:00", "state": "%s", "p": "hw-h", "qos": "normal", "u": "user1", "cpu": 1, "mem": "1G", "gres": "N/A", "nodes": 1, "array_job_id": 0, "array_id": "N/A", "r": "None"}`, state)
+		pf.refresh(context.Background())
+	}
+	assert.Equal(1., CollectCounterValue(jc.transitions.transitions.WithLabelValues("PENDING", "RUNNING", "hw-h", "account1")))
+	assert.Equal(1., CollectCounterValue(jc.transitions.transitions.WithLabelValues("RUNNING", "COMPLETED", "hw-h", "account1")))
+	assert.Zero(CollectCounterValue(jc.transitions.transitions.WithLabelValues("PENDING", "COMPLETED", "hw-h", "account1")))
+}
@@ -6,9 +6,11 @@ package exporter
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand"
 	"os"
 	"os/exec"
 	"regexp"
@@ -23,7 +25,45 @@ import (
 )
 
 type SlurmPrimitiveMetric interface {
-	NodeMetric | JobMetric | DiagMetric | LicenseMetric | AccountLimitMetric
+	NodeMetric | JobMetric | DiagMetric | LicenseMetric | AccountLimitMetric | FairshareMetric | JobPriorityMetric | CompletedJobMetric | ReservationMetric | JobEfficiencyMetric | CompletedJobEfficiencyMetric
+}
+
+// accumulates observations for a const histogram, since histograms of the current job set are rebuilt on every scrape
+type HistogramMetric struct {
+	bounds  []float64
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+	max     float64
+}
+
+func NewHistogramMetric(bounds []float64) *HistogramMetric {
+	return &HistogramMetric{bounds: bounds, buckets: make(map[float64]uint64)}
+}
+
+func (hm *HistogramMetric) observe(v float64) {
+	hm.count++
+	hm.sum += v
+	hm.max = max(hm.max, v)
+	for _, bound := range hm.bounds {
+		if v <= bound {
+			hm.buckets[bound]++
+		}
+	}
+}
+
+func (hm *HistogramMetric) constHistogram(desc *prometheus.Desc, labelValues ...string) prometheus.Metric {
+	return prometheus.MustNewConstHistogram(desc, hm.count, hm.sum, hm.buckets, labelValues...)
+}
+
+// observe v in the histogram of key, creating it with bounds if needed
+func observeHistogram(metrics map[string]*HistogramMetric, key string, bounds []float64, v float64) {
+	metric, ok := metrics[key]
+	if !ok {
+		metric = NewHistogramMetric(bounds)
+		metrics[key] = metric
+	}
+	metric.observe(v)
 }
 
 type CoercedInt int
@@ -56,16 +96,15 @@ type SlurmVersion struct {
 }
 
 // interface for getting data from slurm
-// used for dep injection/ease of testing & for add slurmrestd support later
+// used for dep injection/ease of testing & for slurmrestd support
 type SlurmByteScraper interface {
-	FetchRawBytes() ([]byte, error)
+	FetchRawBytes(ctx context.Context) ([]byte, error)
 	Duration() time.Duration
 }
 
 type SlurmMetricFetcher[M SlurmPrimitiveMetric] interface {
-	FetchMetrics() ([]M, error)
+	FetchMetrics(ctx context.Context) ([]M, error)
 	ScrapeDuration() time.Duration
-	ScrapeError() prometheus.Counter
 }
 
 type AtomicThrottledCache[C SlurmPrimitiveMetric] struct {
@@ -75,6 +114,12 @@ type AtomicThrottledCache[C SlurmPrimitiveMetric] struct {
 	cache []C
 	// duration of last cache miss
 	duration time.Duration
+	// serve the last good fetch for up to staleLimit seconds on fetch failure. 0 disables
+	staleLimit float64
+	// cache has been hydrated by at least 1 successful fetch
+	hydrated bool
+	// last fetch failed
+	stale bool
 }
 
 // atomic fetch of either the cache or the collector
@@ -88,14 +133,35 @@ func (atc *AtomicThrottledCache[C]) FetchOrThrottle(fetchFunc func() ([]C, error
 	t := time.Now()
 	slurmData, err := fetchFunc()
 	if err != nil {
+		atc.stale = true
+		if atc.hydrated && time.Since(atc.t).Seconds() < atc.staleLimit {
+			slog.Warn(fmt.Sprintf("fetch failed with %q, serving stale data from %s", err, atc.t.Format(time.RFC3339)))
+			return atc.cache, nil
+		}
 		return nil, err
 	}
 	atc.duration = time.Since(t)
 	atc.cache = slurmData
 	atc.t = time.Now()
+	atc.hydrated = true
+	atc.stale = false
 	return slurmData, nil
 }
 
+// time since the last successful fetch, or since creation if never hydrated
+func (atc *AtomicThrottledCache[C]) Age() time.Duration {
+	atc.Lock()
+	defer atc.Unlock()
+	return time.Since(atc.t)
+}
+
+// whether the last fetch failed
+func (atc *AtomicThrottledCache[C]) Stale() bool {
+	atc.Lock()
+	defer atc.Unlock()
+	return atc.stale
+}
+
 func NewAtomicThrottledCache[C SlurmPrimitiveMetric](limit float64) *AtomicThrottledCache[C] {
 	return &AtomicThrottledCache[C]{
 		t:     time.Now(),
@@ -103,6 +169,53 @@ func NewAtomicThrottledCache[C SlurmPrimitiveMetric](limit float64) *AtomicThrot
 	}
 }
 
+type CacheStatus interface {
+	Age() time.Duration
+	Stale() bool
+}
+
+// create a cache from config settings and track it for cache age reporting
+func newConfiguredCache[C SlurmPrimitiveMetric](config *Config, name string) *AtomicThrottledCache[C] {
+	cache := NewAtomicThrottledCache[C](config.PollLimit)
+	cache.staleLimit = config.StaleMaxAge
+	if config.caches == nil {
+		config.caches = make(map[string]CacheStatus)
+	}
+	config.caches[name] = cache
+	return cache
+}
+
+// reports age and staleness of every cache tracked by config
+type CacheCollector struct {
+	caches     map[string]CacheStatus
+	cacheAge   *prometheus.Desc
+	cacheStale *prometheus.Desc
+}
+
+func NewCacheCollector(config *Config) *CacheCollector {
+	return &CacheCollector{
+		caches:     config.caches,
+		cacheAge:   prometheus.NewDesc("slurm_exporter_cache_age_seconds", "seconds since the fetcher last successfully fetched from slurm", []string{"fetcher"}, config.constLabels()),
+		cacheStale: prometheus.NewDesc("slurm_exporter_cache_stale", "1 if the last fetch failed and any data served is stale", []string{"fetcher"}, config.constLabels()),
+	}
+}
+
+func (cc *CacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cc.cacheAge
+	ch <- cc.cacheStale
+}
+
+func (cc *CacheCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, cache := range cc.caches {
+		stale := 0.
+		if cache.Stale() {
+			stale = 1
+		}
+		ch <- prometheus.MustNewConstMetric(cc.cacheAge, prometheus.GaugeValue, cache.Age().Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(cc.cacheStale, prometheus.GaugeValue, stale, name)
+	}
+}
+
 func track(cmd []string) (string, time.Time) {
 	return strings.Join(cmd, " "), time.Now()
 }
@@ -116,52 +229,133 @@ type CliScraper struct {
 	args     []string
 	timeout  time.Duration
 	duration time.Duration
+	// additional attempts after a failed cmd
+	retries int
+	// base delay between attempts, doubled after every failure
+	backoff time.Duration
+	// log stderr of a clean exit instead of failing, for cmds warning about some of their arguments i.e sstat -j with a finished job
+	stderrWarnings bool
 }
 
 func (cf *CliScraper) Duration() time.Duration {
 	return cf.duration
 }
 
-func (cf *CliScraper) FetchRawBytes() ([]byte, error) {
-	defer func(t time.Time) { cf.duration = time.Since(t) }(time.Now())
-	if len(cf.args) == 0 {
-		return nil, errors.New("need at least 1 args")
-	}
-	defer duration(track(cf.args))
-	cmd := exec.Command(cf.args[0], cf.args[1:]...)
+// run the cmd once. The cmd is killed once either the scraper timeout elapses or ctx is done
+func (cf *CliScraper) run(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, cf.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, cf.args[0], cf.args[1:]...)
 	var outb, errb bytes.Buffer
 	cmd.Stdout = &outb
 	cmd.Stderr = &errb
-	if err := cmd.Start(); err != nil {
-		return nil, err
-	}
-	timer := time.AfterFunc(cf.timeout, func() {
-		if err := cmd.Process.Kill(); err != nil {
-			slog.Error(fmt.Sprintf("failed to cancel cmd: %v", cf.args))
+	if err := cmd.Run(); err != nil {
+		reason := reasonExit
+		if ctx.Err() != nil {
+			reason = reasonTimeout
 		}
-	})
-	defer timer.Stop()
-	if err := cmd.Wait(); err != nil {
-		return nil, err
+		if errb.Len() > 0 {
+			err = fmt.Errorf("%w: %s", err, strings.TrimSpace(errb.String()))
+		}
+		return nil, &CommandError{Command: cf.args[0], Reason: reason, Err: err}
 	}
-	if errb.Len() > 0 {
-		return nil, fmt.Errorf("cmd failed with %s", errb.String())
+	if errb.Len() > 0 && cf.stderrWarnings {
+		slog.Warn(fmt.Sprintf("cmd %v wrote to stderr: %s", cf.args, strings.TrimSpace(errb.String())))
+	} else if errb.Len() > 0 {
+		return nil, &CommandError{Command: cf.args[0], Reason: reasonStderr, Err: errors.New(strings.TrimSpace(errb.String()))}
 	}
 	return outb.Bytes(), nil
 }
 
-func NewCliScraper(args ...string) *CliScraper {
-	var limit float64 = 10
-	var err error
-	if tm, ok := os.LookupEnv("CLI_TIMEOUT"); ok {
-		if limit, err = strconv.ParseFloat(tm, 64); err != nil {
-			slog.Error("`CLI_TIMEOUT` env var parse error")
+// upper bound on the delay between attempts, however many retries are configured
+const maxBackoff = 30 * time.Second
+
+// jittered exponential backoff, uniform in [d/2, d) where d = min(backoff * 2^attempt, maxBackoff)
+func (cf *CliScraper) backoffDelay(attempt int) time.Duration {
+	// doubling stops at the cap, so large attempts can't overflow
+	d := cf.backoff
+	for i := 0; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxBackoff)
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// only non-zero exits are retried. A timed out cmd would time out again, and stderr on a clean exit is deterministic
+func retryable(err error) bool {
+	return errorReason(err) == reasonExit
+}
+
+// retry failed cmds with backoff until retries are exhausted or ctx is done
+func (cf *CliScraper) FetchRawBytes(ctx context.Context) ([]byte, error) {
+	defer func(t time.Time) { cf.duration = time.Since(t) }(time.Now())
+	if len(cf.args) == 0 {
+		return nil, errors.New("need at least 1 args")
+	}
+	defer duration(track(cf.args))
+	for attempt := 0; ; attempt++ {
+		data, err := cf.run(ctx)
+		if err == nil || attempt >= cf.retries || ctx.Err() != nil || !retryable(err) {
+			return data, err
+		}
+		delay := cf.backoffDelay(attempt)
+		// don't sleep past the scrape deadline only to be cancelled
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return nil, err
 		}
+		slog.Warn(fmt.Sprintf("cmd %v failed with %q, retrying in %s", cf.args, err, delay))
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// parse a float env var, returning def if unset or invalid
+func floatEnv(name string, def float64) float64 {
+	val, ok := os.LookupEnv(name)
+	if !ok {
+		return def
 	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		slog.Error(fmt.Sprintf("`%s` env var parse error", name))
+		return def
+	}
+	return f
+}
+
+// timeout applied to each slurm scrape, configurable with the `CLI_TIMEOUT` env var
+func scrapeTimeout() time.Duration {
+	return time.Duration(floatEnv("CLI_TIMEOUT", 10) * float64(time.Second))
+}
+
+func NewCliScraper(args ...string) *CliScraper {
 	return &CliScraper{
 		args:    args,
-		timeout: time.Duration(limit) * time.Second,
+		timeout: scrapeTimeout(),
+		retries: int(floatEnv("CLI_RETRIES", 0)),
+		backoff: time.Duration(floatEnv("CLI_RETRY_BACKOFF", .5) * float64(time.Second)),
+	}
+}
+
+// cli scraper with the configured timeout and retries
+func (co *CliOpts) newCliScraper(args []string) *CliScraper {
+	scraper := NewCliScraper(args...)
+	if co.timeout > 0 {
+		scraper.timeout = co.timeout
+	}
+	if co.retries > 0 {
+		scraper.retries = co.retries
+	}
+	if co.backoff > 0 {
+		scraper.backoff = co.backoff
 	}
+	return scraper
 }
 
 // convert slurm mem string to float64 bytes
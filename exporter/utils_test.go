@@ -5,12 +5,18 @@
 package exporter
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"log/slog"
 )
@@ -36,7 +42,7 @@ func generateRandString(n int) string {
 func TestCliFetcher(t *testing.T) {
 	assert := assert.New(t)
 	cliFetcher := NewCliScraper("ls")
-	data, err := cliFetcher.FetchRawBytes()
+	data, err := cliFetcher.FetchRawBytes(context.Background())
 	assert.NoError(err)
 	assert.NotNil(data)
 }
@@ -44,16 +50,141 @@ func TestCliFetcher(t *testing.T) {
 func TestCliFetcher_Timeout(t *testing.T) {
 	assert := assert.New(t)
 	cliFetcher := NewCliScraper("sleep", "100")
-	cliFetcher.timeout = 0
-	data, err := cliFetcher.FetchRawBytes()
-	assert.EqualError(err, "signal: killed")
+	cliFetcher.timeout = 10 * time.Millisecond
+	data, err := cliFetcher.FetchRawBytes(context.Background())
+	assert.EqualError(err, "sleep timeout error: signal: killed")
+	assert.Equal(reasonTimeout, errorReason(err))
 	assert.Nil(data)
 }
 
+func TestCliFetcher_Cancel(t *testing.T) {
+	assert := assert.New(t)
+	cliFetcher := NewCliScraper("sleep", "100")
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	data, err := cliFetcher.FetchRawBytes(ctx)
+	assert.Error(err)
+	assert.Nil(data)
+	assert.Less(time.Since(start), 10*time.Second)
+}
+
+func TestCliFetcher_Retry(t *testing.T) {
+	assert := assert.New(t)
+	marker := filepath.Join(t.TempDir(), "attempted")
+	// fails on the first attempt only
+	cliFetcher := NewCliScraper("sh", "-c", fmt.Sprintf("if [ -f %[1]s ]; then echo ok; else touch %[1]s; exit 1; fi", marker))
+	cliFetcher.retries = 1
+	cliFetcher.backoff = time.Millisecond
+	data, err := cliFetcher.FetchRawBytes(context.Background())
+	assert.NoError(err)
+	assert.Equal("ok\n", string(data))
+}
+
+func TestCliFetcher_RetriesExhausted(t *testing.T) {
+	assert := assert.New(t)
+	cliFetcher := NewCliScraper("sh", "-c", "echo 'Socket timed out on send/recv operation' >&2; exit 1")
+	cliFetcher.retries = 2
+	cliFetcher.backoff = time.Millisecond
+	data, err := cliFetcher.FetchRawBytes(context.Background())
+	assert.Nil(data)
+	assert.ErrorContains(err, "Socket timed out on send/recv operation")
+	assert.Equal(reasonExit, errorReason(err))
+}
+
+func TestCliFetcher_Stderr(t *testing.T) {
+	assert := assert.New(t)
+	cliFetcher := NewCliScraper("sh", "-c", "echo ok; echo noise >&2")
+	data, err := cliFetcher.FetchRawBytes(context.Background())
+	assert.Nil(data)
+	assert.EqualError(err, "sh stderr error: noise")
+	assert.Equal(reasonStderr, errorReason(err))
+}
+
+func TestCliFetcher_StderrWarnings(t *testing.T) {
+	assert := assert.New(t)
+	cliFetcher := NewCliScraper("sh", "-c", "echo ok; echo 'no steps running for job 1' >&2")
+	cliFetcher.stderrWarnings = true
+	data, err := cliFetcher.FetchRawBytes(context.Background())
+	assert.NoError(err)
+	assert.Equal("ok\n", string(data))
+}
+
+func TestCliFetcher_RetryCancel(t *testing.T) {
+	assert := assert.New(t)
+	cliFetcher := NewCliScraper("false")
+	cliFetcher.retries = 5
+	cliFetcher.backoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := cliFetcher.FetchRawBytes(ctx)
+	assert.Error(err)
+	assert.Less(time.Since(start), time.Minute)
+}
+
+func TestCliFetcher_BackoffDelay(t *testing.T) {
+	assert := assert.New(t)
+	cliFetcher := &CliScraper{backoff: time.Second}
+	for attempt := range 4 {
+		ceiling := time.Second << attempt
+		delay := cliFetcher.backoffDelay(attempt)
+		assert.GreaterOrEqual(delay, ceiling/2)
+		assert.Less(delay, ceiling)
+	}
+}
+
+func TestCliFetcher_BackoffDelayCap(t *testing.T) {
+	assert := assert.New(t)
+	cliFetcher := &CliScraper{backoff: time.Hour}
+	for _, attempt := range []int{0, 10, 63, 1000} {
+		delay := cliFetcher.backoffDelay(attempt)
+		assert.GreaterOrEqual(delay, maxBackoff/2)
+		assert.Less(delay, maxBackoff)
+	}
+}
+
+func TestCliFetcher_NoRetryOnTimeout(t *testing.T) {
+	assert := assert.New(t)
+	marker := filepath.Join(t.TempDir(), "attempts")
+	cliFetcher := NewCliScraper("sh", "-c", fmt.Sprintf("echo >> %s; exec sleep 10", marker))
+	cliFetcher.timeout = 10 * time.Millisecond
+	cliFetcher.retries = 3
+	cliFetcher.backoff = time.Millisecond
+	_, err := cliFetcher.FetchRawBytes(context.Background())
+	assert.Equal(reasonTimeout, errorReason(err))
+	attempts, err := os.ReadFile(marker)
+	assert.NoError(err)
+	assert.Equal("\n", string(attempts))
+}
+
+func TestCliFetcher_NoRetryOnStderr(t *testing.T) {
+	assert := assert.New(t)
+	marker := filepath.Join(t.TempDir(), "attempts")
+	cliFetcher := NewCliScraper("sh", "-c", fmt.Sprintf("echo >> %s; echo noise >&2", marker))
+	cliFetcher.retries = 3
+	cliFetcher.backoff = time.Millisecond
+	_, err := cliFetcher.FetchRawBytes(context.Background())
+	assert.Equal(reasonStderr, errorReason(err))
+	attempts, err := os.ReadFile(marker)
+	assert.NoError(err)
+	assert.Equal("\n", string(attempts))
+}
+
+func TestNewCliScraper_Env(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("CLI_RETRIES", "3")
+	t.Setenv("CLI_RETRY_BACKOFF", "0.25")
+	cliFetcher := NewCliScraper("ls")
+	assert.Equal(3, cliFetcher.retries)
+	assert.Equal(250*time.Millisecond, cliFetcher.backoff)
+	assert.Equal(10*time.Second, cliFetcher.timeout)
+}
+
 func TestCliFetcher_EmptyArgs(t *testing.T) {
 	assert := assert.New(t)
 	cliFetcher := NewCliScraper()
-	data, err := cliFetcher.FetchRawBytes()
+	data, err := cliFetcher.FetchRawBytes(context.Background())
 	assert.EqualError(err, "need at least 1 args")
 	assert.Nil(data)
 }
@@ -61,7 +192,7 @@ func TestCliFetcher_EmptyArgs(t *testing.T) {
 func TestCliFetcher_ExitCodeCmd(t *testing.T) {
 	assert := assert.New(t)
 	cliFetcher := NewCliScraper("ls", generateRandString(64))
-	data, err := cliFetcher.FetchRawBytes()
+	data, err := cliFetcher.FetchRawBytes(context.Background())
 	assert.NotNil(err)
 	assert.Nil(data)
 }
@@ -71,7 +202,7 @@ func TestCliFetcher_StdErr(t *testing.T) {
 	// the rare case where stderr is written but exit code is still 0
 	cmd := `echo -e "error" 1>&2`
 	cliFetcher := NewCliScraper("/bin/bash", "-c", cmd)
-	data, err := cliFetcher.FetchRawBytes()
+	data, err := cliFetcher.FetchRawBytes(context.Background())
 	assert.NotNil(err)
 	assert.Nil(data)
 }
@@ -149,3 +280,97 @@ func TestConvertMemToFloat_Sad(t *testing.T) {
 	assert.Error(err)
 	assert.Equal(-1., n)
 }
+
+func TestAtomicThrottledCache_StaleWhileError(t *testing.T) {
+	assert := assert.New(t)
+	cache := NewAtomicThrottledCache[NodeMetric](0)
+	cache.staleLimit = math.MaxFloat64
+	info, err := cache.FetchOrThrottle(func() ([]NodeMetric, error) {
+		return []NodeMetric{{Hostname: "host1"}}, nil
+	})
+	assert.NoError(err)
+	assert.False(cache.Stale())
+	info, err = cache.FetchOrThrottle(func() ([]NodeMetric, error) {
+		return nil, errors.New("squeue timeout")
+	})
+	// last good data is served
+	assert.NoError(err)
+	assert.Equal("host1", info[0].Hostname)
+	assert.True(cache.Stale())
+	// recovers on the next good fetch
+	_, err = cache.FetchOrThrottle(func() ([]NodeMetric, error) {
+		return []NodeMetric{{Hostname: "host2"}}, nil
+	})
+	assert.NoError(err)
+	assert.False(cache.Stale())
+}
+
+func TestAtomicThrottledCache_StaleExpired(t *testing.T) {
+	assert := assert.New(t)
+	cache := NewAtomicThrottledCache[NodeMetric](0)
+	cache.staleLimit = 10
+	cache.cache = []NodeMetric{{Hostname: "host1"}}
+	cache.hydrated = true
+	cache.t = time.Now().Add(-time.Minute)
+	info, err := cache.FetchOrThrottle(func() ([]NodeMetric, error) {
+		return nil, errors.New("squeue timeout")
+	})
+	assert.Error(err)
+	assert.Nil(info)
+	assert.True(cache.Stale())
+	assert.GreaterOrEqual(cache.Age(), time.Minute)
+}
+
+func TestAtomicThrottledCache_StaleDisabled(t *testing.T) {
+	assert := assert.New(t)
+	cache := NewAtomicThrottledCache[NodeMetric](0)
+	cache.cache = []NodeMetric{{Hostname: "host1"}}
+	cache.hydrated = true
+	info, err := cache.FetchOrThrottle(func() ([]NodeMetric, error) {
+		return nil, errors.New("squeue timeout")
+	})
+	assert.Error(err)
+	assert.Nil(info)
+}
+
+func TestCacheCollector(t *testing.T) {
+	assert := assert.New(t)
+	config := &Config{PollLimit: 10, StaleMaxAge: 60}
+	cache := newConfiguredCache[NodeMetric](config, "nodes")
+	assert.Equal(60., cache.staleLimit)
+	assert.Contains(config.caches, "nodes")
+	cache.FetchOrThrottle(func() ([]NodeMetric, error) {
+		return nil, errors.New("sinfo timeout")
+	})
+	cc := NewCacheCollector(config)
+	metricChan := make(chan prometheus.Metric, 2)
+	cc.Collect(metricChan)
+	close(metricChan)
+	values := make(map[string]float64)
+	for m := range metricChan {
+		dtoMetric := new(dto.Metric)
+		assert.NoError(m.Write(dtoMetric))
+		values[m.Desc().String()] = dtoMetric.GetGauge().GetValue()
+	}
+	assert.Len(values, 2)
+	assert.Equal(1., values[cc.cacheStale.String()])
+}
+
+func TestHistogramMetric(t *testing.T) {
+	assert := assert.New(t)
+	metrics := make(map[string]*HistogramMetric)
+	bounds := []float64{1, 5, 10}
+	for _, v := range []float64{0.5, 3, 7, 20} {
+		observeHistogram(metrics, "a", bounds, v)
+	}
+	hm := metrics["a"]
+	assert.Equal(uint64(4), hm.count)
+	assert.Equal(30.5, hm.sum)
+	assert.Equal(20., hm.max)
+	// buckets are cumulative
+	assert.Equal(map[float64]uint64{1: 1, 5: 2, 10: 3}, hm.buckets)
+	desc := prometheus.NewDesc("test_histogram", "test", []string{"key"}, nil)
+	dtoMetric := new(dto.Metric)
+	assert.NoError(hm.constHistogram(desc, "a").Write(dtoMetric))
+	assert.Equal(uint64(4), dtoMetric.GetHistogram().GetSampleCount())
+}
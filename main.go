@@ -7,61 +7,123 @@ import (
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"log/slog"
 
 	"github.com/rivosinc/prometheus-slurm-exporter/exporter"
 )
 
-var (
-	listenAddress = flag.String("web.listen-address", "",
-		`Address to listen on for telemetry "(default: :9092)"`)
-	metricsPath = flag.String("web.telemetry-path", "",
-		"Path under which to expose metrics (default: /metrics)")
-	logLevel             = flag.String("web.log-level", "", "Log level: info, debug, error, warning")
-	traceEnabled         = flag.Bool("trace.enabled", false, "Set up Post endpoint for collecting traces")
-	tracePath            = flag.String("trace.path", "", "POST path to upload job proc info")
-	traceRate            = flag.Uint64("trace.rate", 0, "number of seconds proc info should stay in memory before being marked as stale (default 10)")
-	slurmPollLimit       = flag.Float64("slurm.poll-limit", 0, "throttle for slurmctld (default: 10s)")
-	slurmSinfoOverride   = flag.String("slurm.sinfo-cli", "", "sinfo cli override")
-	slurmSqueueOverride  = flag.String("slurm.squeue-cli", "", "squeue cli override")
-	slurmLicenseOverride = flag.String("slurm.lic-cli", "", "squeue cli override")
-	slurmDiagOverride    = flag.String("slurm.diag-cli", "", "sdiag cli override")
-	slurmSaactOverride   = flag.String("slurm.sacctmgr-cli", "", "saactmgr cli override")
-	slurmLicEnabled      = flag.Bool("slurm.collect-licenses", false, "Collect license info from slurm")
-	slurmDiagEnabled     = flag.Bool("slurm.collect-diags", false, "Collect daemon diagnostics stats from slurm")
-	slurmSacctEnabled    = flag.Bool("slurm.collect-limits", false, "Collect account and user limits from slurm")
-	slurmCliFallback     = flag.Bool("slurm.cli-fallback", true, "drop the --json arg and revert back to standard squeue for performance reasons")
-	metricsFilterRegex   = flag.String("metrics.exclude", "", "Regex pattern for metrics to exclude")
-)
+// register every flag on a new flag set, writing parsed values into cliFlags
+func newFlagSet(cliFlags *exporter.CliFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.StringVar(&cliFlags.ConfigFile, "config.file", "", "yaml config file. Flags set on the command line take precedence. Reloaded on SIGHUP")
+	fs.StringVar(&cliFlags.ListenAddress, "web.listen-address", "", `Address to listen on for telemetry "(default: :9092)"`)
+	fs.StringVar(&cliFlags.MetricsPath, "web.telemetry-path", "", "Path under which to expose metrics (default: /metrics)")
+	fs.StringVar(&cliFlags.LogLevel, "web.log-level", "", "Log level: info, debug, error, warning")
+	fs.BoolVar(&cliFlags.TraceEnabled, "trace.enabled", false, "Set up Post endpoint for collecting traces")
+	fs.StringVar(&cliFlags.TracePath, "trace.path", "", "POST path to upload job proc info")
+	fs.Uint64Var(&cliFlags.TraceRate, "trace.rate", 0, "number of seconds proc info should stay in memory before being marked as stale (default 10)")
+	fs.Float64Var(&cliFlags.SlurmPollLimit, "slurm.poll-limit", 0, "throttle for slurmctld (default: 10s)")
+	fs.Float64Var(&cliFlags.SlurmPollInterval, "slurm.poll-interval", 0, "seconds between background polls of slurm. Scrapes are served from the last snapshot. Unset fetches on scrape")
+	fs.Float64Var(&cliFlags.SlurmStaleMaxAge, "slurm.stale-max-age", 0, "seconds to keep serving the last good data when a slurm fetch fails. Unset drops series on failure")
+	fs.Float64Var(&cliFlags.SlurmCliTimeout, "slurm.cli-timeout", 0, "seconds before a slurm command is killed (default: $CLI_TIMEOUT or 10s)")
+	fs.IntVar(&cliFlags.SlurmCliRetries, "slurm.cli-retries", 0, "times a failed slurm command is retried (default: $CLI_RETRIES or 0)")
+	fs.Float64Var(&cliFlags.SlurmCliRetryBackoff, "slurm.cli-retry-backoff", 0, "base seconds between retries (default: $CLI_RETRY_BACKOFF or 0.5s)")
+	fs.StringVar(&cliFlags.SlurmSinfoOverride, "slurm.sinfo-cli", "", "sinfo cli override")
+	fs.StringVar(&cliFlags.SlurmSqueueOverride, "slurm.squeue-cli", "", "squeue cli override")
+	fs.StringVar(&cliFlags.SlurmLicenseOverride, "slurm.lic-cli", "", "squeue cli override")
+	fs.StringVar(&cliFlags.SlurmDiagOverride, "slurm.diag-cli", "", "sdiag cli override")
+	fs.StringVar(&cliFlags.SlurmAcctOverride, "slurm.sacctmgr-cli", "", "saactmgr cli override")
+	fs.StringVar(&cliFlags.SlurmSshareOverride, "slurm.sshare-cli", "", "sshare cli override")
+	fs.StringVar(&cliFlags.SlurmSprioOverride, "slurm.sprio-cli", "", "sprio cli override")
+	fs.StringVar(&cliFlags.SlurmReservationOverride, "slurm.reservation-cli", "", "scontrol show reservation cli override")
+	fs.StringVar(&cliFlags.SlurmSacctEffOverride, "slurm.sacct-efficiency-cli", "", "sacct cli override for completed job efficiency. The -S and -E window is appended on every fetch")
+	fs.StringVar(&cliFlags.SlurmSstatOverride, "slurm.sstat-cli", "", "sstat cli override. The -j job ids are appended on every fetch")
+	fs.StringVar(&cliFlags.SlurmSacctOverride, "slurm.sacct-cli", "", "sacct cli override. The -S and -E window is appended on every fetch")
+	fs.BoolVar(&cliFlags.SlurmLicEnabled, "slurm.collect-licenses", false, "Collect license info from slurm")
+	fs.BoolVar(&cliFlags.SlurmDiagEnabled, "slurm.collect-diags", false, "Collect daemon diagnostics stats from slurm")
+	fs.BoolVar(&cliFlags.SacctEnabled, "slurm.collect-limits", false, "Collect account and user limits from slurm")
+	fs.BoolVar(&cliFlags.FairshareEnabled, "slurm.collect-fairshare", false, "Collect account and user fairshare from sshare")
+	fs.BoolVar(&cliFlags.PriorityEnabled, "slurm.collect-priority", false, "Collect the priority components of pending jobs from sprio")
+	fs.BoolVar(&cliFlags.CompletedJobsEnabled, "slurm.collect-completed-jobs", false, "Count jobs that ended since the last fetch with sacct")
+	fs.BoolVar(&cliFlags.ReservationsEnabled, "slurm.collect-reservations", false, "Collect reservation windows and reserved cpus from scontrol")
+	fs.BoolVar(&cliFlags.EfficiencyEnabled, "slurm.collect-efficiency", false, "Sample the cpu, memory and disk usage of running jobs with sstat")
+	fs.BoolVar(&cliFlags.SeffEnabled, "slurm.collect-completed-efficiency", false, "Measure the cpu and memory efficiency of jobs that ended since the last fetch with sacct, like seff")
+	fs.IntVar(&cliFlags.SstatBatchSize, "slurm.sstat-batch-size", 0, "max running jobs sampled by each sstat call (default: 50)")
+	fs.StringVar(&cliFlags.SacctCursorFile, "slurm.sacct-cursor-file", "", "file persisting the end time of the last sacct window across restarts. Unset starts from now on every start")
+	fs.IntVar(&cliFlags.PriorityTopJobs, "slurm.priority-top-jobs", 0, "highest priority pending jobs exported individually by the priority collector (default: 10)")
+	fs.BoolVar(&cliFlags.JobMetricsEnabled, "slurm.collect-job-metrics", false, "Collect per job cpu and mem allocations. Adds a series per job")
+	fs.IntVar(&cliFlags.JobMetricsMax, "slurm.job-metrics-max", 0, "max jobs exported by the per job metrics (default: 5000)")
+	fs.StringVar(&cliFlags.JobMetricsStates, "slurm.job-metrics-states", "", "comma separated job states exported by the per job metrics (default: RUNNING)")
+	fs.BoolVar(&cliFlags.NodeJobsEnabled, "slurm.collect-node-jobs", false, "Collect job counts and cpu and mem allocations per node. Adds series per node")
+	fs.BoolVar(&cliFlags.NodeMetricsEnabled, "slurm.collect-node-metrics", false, "Collect sinfo cpus, memory, load, weight and state per node. Adds series per node")
+	fs.BoolVar(&cliFlags.AccountQosLabels, "slurm.account-qos-labels", false, "Add a qos label to the account job metrics")
+	fs.IntVar(&cliFlags.MaxUsers, "slurm.max-users", 0, "Max users exported by the user metrics, the rest are folded into other. Counters keep users active in the last 24h (default: no limit)")
+	fs.IntVar(&cliFlags.MaxAccounts, "slurm.max-accounts", 0, "Max accounts exported by the account metrics, the rest are folded into other. Counters keep accounts active in the last 24h (default: no limit)")
+	fs.StringVar(&cliFlags.LimitLabelsBy, "slurm.limit-labels-by", "", "Quantity the users and accounts kept by -slurm.max-users and -slurm.max-accounts are ranked by: cpus, mem or jobs (default: cpus)")
+	fs.Float64Var(&cliFlags.TimeLimitWarning, "slurm.time-limit-warning", 0, "seconds of walltime left under which running jobs count as near their time limit (default: 1800)")
+	fs.BoolVar(&cliFlags.SlurmCliFallback, "slurm.cli-fallback", true, "drop the --json arg and revert back to standard squeue for performance reasons")
+	fs.Var(&cliFlags.Clusters, "slurm.clusters", "comma separated clusters to scrape with -M. Per cluster overrides are only available in the config file")
+	fs.StringVar(&cliFlags.MetricsExcludeFilterRegex, "metrics.exclude", "", "Regex pattern for metrics to exclude")
+	fs.StringVar(&cliFlags.SlurmRestUrl, "slurm.rest-url", "", "slurmrestd url, either http(s)://host:port or unix:///path/to/slurmrestd.sock. Unset uses the cli")
+	fs.StringVar(&cliFlags.SlurmRestUser, "slurm.rest-user", "", "user sent to slurmrestd as X-SLURM-USER-NAME")
+	fs.StringVar(&cliFlags.SlurmRestToken, "slurm.rest-token", "", "jwt sent to slurmrestd as X-SLURM-USER-TOKEN (default: $SLURM_JWT)")
+	fs.StringVar(&cliFlags.SlurmRestApiVersion, "slurm.rest-api-version", "", "slurmrestd api version (default: v0.0.37)")
+	fs.StringVar(&cliFlags.SlurmRestCollectors, "slurm.rest-collectors", "", "comma separated collectors to serve from slurmrestd: jobs,nodes,diags,licenses,reservations (default: all)")
+	return fs
+}
 
-func main() {
-	flag.Parse()
-	cliFlags := exporter.CliFlags{
-		ListenAddress:             *listenAddress,
-		MetricsPath:               *metricsPath,
-		LogLevel:                  *logLevel,
-		TraceEnabled:              *traceEnabled,
-		TracePath:                 *tracePath,
-		SlurmPollLimit:            *slurmPollLimit,
-		SlurmSinfoOverride:        *slurmSinfoOverride,
-		SlurmSqueueOverride:       *slurmSqueueOverride,
-		SlurmLicenseOverride:      *slurmLicenseOverride,
-		SlurmDiagOverride:         *slurmDiagOverride,
-		SlurmLicEnabled:           *slurmLicEnabled,
-		SlurmDiagEnabled:          *slurmDiagEnabled,
-		SacctEnabled:              *slurmSacctEnabled,
-		SlurmCliFallback:          *slurmCliFallback,
-		TraceRate:                 *traceRate,
-		SlurmAcctOverride:         *slurmSaactOverride,
-		MetricsExcludeFilterRegex: *metricsFilterRegex,
+// parse args and layer the config file, if any, underneath the flags set explicitly
+func loadCliFlags(args []string) (*exporter.CliFlags, error) {
+	cliFlags := new(exporter.CliFlags)
+	fs := newFlagSet(cliFlags)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if cliFlags.ConfigFile == "" {
+		return cliFlags, nil
 	}
-	config, err := exporter.NewConfig(&cliFlags)
+	explicit := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+	if err := exporter.ReadConfigFile(cliFlags.ConfigFile, cliFlags); err != nil {
+		return nil, err
+	}
+	for name, value := range explicit {
+		if err := fs.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+	return cliFlags, nil
+}
+
+func loadConfig() (*exporter.Config, error) {
+	cliFlags, err := loadCliFlags(os.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	return exporter.NewConfig(cliFlags)
+}
+
+func main() {
+	reloader, err := exporter.NewReloader(loadConfig)
 	if err != nil {
 		log.Fatalf("failed to init config with %q", err)
 	}
-	handler := exporter.InitPromServer(config)
-	http.Handle(config.MetricsPath, handler)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			slog.Info("received SIGHUP, reloading config")
+			// failures are logged and exported by the reloader
+			_ = reloader.Reload()
+		}
+	}()
+	config := reloader.Register(http.DefaultServeMux)
 	slog.Info("serving metrics at " + config.ListenAddress + config.MetricsPath)
 	log.Fatalf("server exited with %q", http.ListenAndServe(config.ListenAddress, nil))
 